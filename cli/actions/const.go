@@ -37,4 +37,19 @@ const (
 	ActionDomainAdd
 	ActionDomainList
 	ActionDomainRemove
+
+	// Cache actions
+
+	ActionCachePurge
+
+	// Maintenance actions
+
+	ActionServerMaintenance
+
+	// Actions added for the admin API, kept last so that the values above do not change
+
+	ActionServerRemove
+	ActionCloudDomain
+	ActionConfigGet
+	ActionConfigApply
 )
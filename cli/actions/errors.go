@@ -0,0 +1,59 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/DoniLite/Mogoly/core/domain"
+	"github.com/DoniLite/Mogoly/core/router"
+	"github.com/DoniLite/Mogoly/sync"
+)
+
+// ErrNotFound and ErrAlreadyExists are wrapped by the handler errors about missing
+// and duplicated targets, ErrInvalid by the ones about invalid payloads and
+// ErrUnavailable by the ones about a daemon not ready to handle the action.
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+	ErrInvalid       = errors.New("invalid request")
+	ErrUnavailable   = errors.New("unavailable")
+)
+
+// Invalid marks err as caused by the payload of the action, its message is kept.
+func Invalid(err error) error {
+	return &invalidError{err}
+}
+
+type invalidError struct {
+	err error
+}
+
+func (e *invalidError) Error() string {
+	return e.err.Error()
+}
+
+func (e *invalidError) Unwrap() []error {
+	return []error{e.err, ErrInvalid}
+}
+
+// ErrorCode returns the sync error code of err, 0 when it is of no particular kind.
+func ErrorCode(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, router.ErrNotFound), errors.Is(err, domain.ErrNotFound):
+		return sync.ErrorCodeNotFound
+	case errors.Is(err, ErrAlreadyExists), errors.Is(err, domain.ErrAlreadyExists):
+		return sync.ErrorCodeAlreadyExists
+	case errors.Is(err, ErrInvalid), errors.Is(err, router.ErrInvalidConfig):
+		return sync.ErrorCodeInvalid
+	case errors.Is(err, ErrUnavailable):
+		return sync.ErrorCodeUnavailable
+	default:
+		return 0
+	}
+}
+
+// NewErrorMessage returns the answer of a handler failing with err, its payload
+// carries the request id and the code of the error.
+func NewErrorMessage(err error, reqID string) *sync.Message {
+	return sync.NewErrorMessageWithCode(err.Error(), fmt.Sprintf("request id: %s", reqID), ErrorCode(err))
+}
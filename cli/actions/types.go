@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/DoniLite/Mogoly/cloud"
+	"github.com/DoniLite/Mogoly/core/router"
 	"github.com/DoniLite/Mogoly/core/server"
 	"github.com/DoniLite/Mogoly/sync"
 )
@@ -29,7 +30,59 @@ type ServerRemoveBackendPayload struct {
 	BackendName    string `json:"backend_name"`
 }
 
+// ServerRemovePayload for server.remove action
+type ServerRemovePayload struct {
+	Name string `json:"name"`
+}
+
 type CheckServerHealthPayload struct {
 	Name       string `json:"name"`
 	SelfOnly   bool   `json:"self_only"`
-}
\ No newline at end of file
+}
+
+// CachePurgePayload for cache.purge action, empty fields match everything
+type CachePurgePayload struct {
+	Host   string `json:"host"`
+	Prefix string `json:"prefix"`
+}
+
+// ServerMaintenancePayload for server.maintenance action
+type ServerMaintenancePayload struct {
+	Name       string   `json:"name"`
+	Enabled    bool     `json:"enabled"`
+	RetryAfter int      `json:"retry_after"`
+	AllowIPs   []string `json:"allow_ips"`
+	Message    string   `json:"message"`
+}
+
+// DomainAddPayload for domain.add action, the domain is served by the LBName server when set
+type DomainAddPayload struct {
+	Domain  string `json:"domain"`
+	IsLocal bool   `json:"is_local"`
+	LBName  string `json:"lb_name"`
+	AutoSSL bool   `json:"auto_ssl"`
+}
+
+// DomainRemovePayload for domain.remove action
+type DomainRemovePayload struct {
+	Domain string `json:"domain"`
+}
+
+// CloudInstancePayload for the cloud actions targeting an instance by ID
+type CloudInstancePayload struct {
+	ID string `json:"id"`
+}
+
+// CloudLogsPayload for cloud.logs action
+type CloudLogsPayload struct {
+	ID   string `json:"id"`
+	Tail int    `json:"tail"`
+}
+
+// CloudDomainPayload for cloud.domain action
+type CloudDomainPayload struct {
+	ID     string              `json:"id"`
+	Domain *cloud.DomainConfig `json:"domain"`
+}
+
+type ConfigApplyPayload = router.Config
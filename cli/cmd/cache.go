@@ -0,0 +1,75 @@
+/*
+Copyright © 2025 Doni Lite hello@donilite.me
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/DoniLite/Mogoly/cli/actions"
+	"github.com/DoniLite/Mogoly/cli/daemon"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cachePurgeHost   string
+	cachePurgePrefix string
+)
+
+// cacheCmd represents the cache command
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the HTTP response cache",
+	Long: `Manage the responses stored by the mogoly:cache middleware.
+
+Examples:
+  mogoly cache purge
+  mogoly cache purge --host example.com
+  mogoly cache purge --host example.com --prefix /assets`,
+}
+
+// cachePurgeCmd drops cached responses
+var cachePurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Purge cached responses by host and path prefix",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client := daemon.NewClient("")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		payload := actions.CachePurgePayload{
+			Host:   cachePurgeHost,
+			Prefix: cachePurgePrefix,
+		}
+
+		resp, err := client.SendAction(ctx, actions.ActionCachePurge, payload)
+		if err != nil {
+			return fmt.Errorf("failed to communicate with daemon: %v", err)
+		}
+
+		if resp.Error != "" {
+			return fmt.Errorf("failed to purge cache: %s", resp.Error)
+		}
+
+		var result struct {
+			Purged int `json:"purged"`
+		}
+		if err := resp.DecodePayload(&result); err != nil {
+			return err
+		}
+
+		fmt.Printf("✓ Purged %d cached URLs\n", result.Purged)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+
+	cacheCmd.AddCommand(cachePurgeCmd)
+
+	cachePurgeCmd.Flags().StringVar(&cachePurgeHost, "host", "", "Only purge responses of this host")
+	cachePurgeCmd.Flags().StringVar(&cachePurgePrefix, "prefix", "", "Only purge responses whose path starts with this prefix")
+}
@@ -6,18 +6,35 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"maps"
 	"os"
 	"os/exec"
+	"slices"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/DoniLite/Mogoly/cli/actions"
 	"github.com/DoniLite/Mogoly/cli/daemon"
+	_ "github.com/DoniLite/Mogoly/cli/handler" // Registers the action handlers of the daemon
 	"github.com/spf13/cobra"
 )
 
 var (
-	daemonDetach bool
+	daemonDetach      bool
+	daemonConfigPath  string
+	daemonGracePeriod time.Duration
+	daemonAdminAddr   string
+)
+
+const (
+	// How long `daemon start -d` waits for the daemon to bind its listeners.
+	daemonStartTimeout = 10 * time.Second
+	// How long `daemon stop` waits for the daemon to drain and exit.
+	daemonStopTimeout = 5 * time.Minute
+	// How long `daemon upgrade` waits for the new daemon to take over.
+	daemonUpgradeTimeout = 45 * time.Second
 )
 
 // daemonCmd represents the daemon command
@@ -29,17 +46,19 @@ var daemonCmd = &cobra.Command{
 
 Examples:
   mogoly daemon start
+  mogoly daemon upgrade
   mogoly daemon status
   mogoly daemon logs --tail 50`,
 }
 
 // daemonStartCmd starts the daemon
 var daemonStartCmd = &cobra.Command{
-	Use:   "start",
-	Short: "Start the Mogoly daemon",
+	Use:          "start",
+	Short:        "Start the Mogoly daemon",
+	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// Check if daemon is already running
-		if isDaemonRunning() {
+		// Check if daemon is already running, the one being upgraded excepted
+		if !daemon.IsUpgrade() && isDaemonRunning() {
 			fmt.Println("Daemon is already running")
 			return nil
 		}
@@ -85,12 +104,32 @@ var daemonStopCmd = &cobra.Command{
 			return fmt.Errorf("failed to find process: %v", err)
 		}
 
-		// Send SIGTERM
+		// The report of a previous shutdown must not be taken for this one
+		if reportPath, err := daemon.GetShutdownReportPath(); err == nil {
+			os.Remove(reportPath)
+		}
+
+		// Send SIGTERM, the daemon drains the in-flight requests before exiting
 		if err := process.Signal(syscall.SIGTERM); err != nil {
 			return fmt.Errorf("failed to stop daemon: %v", err)
 		}
 
+		fmt.Println("Waiting for the in-flight requests to drain...")
+		deadline := time.Now().Add(daemonStopTimeout)
+		for process.Signal(syscall.Signal(0)) == nil {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("daemon still running after %s", daemonStopTimeout)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
 		fmt.Println("✓ Daemon stopped successfully")
+		if report, err := daemon.ReadShutdownReport(); err == nil {
+			fmt.Printf("  In flight:  %d requests, drained in %s\n", report.InFlight, report.Duration.Round(time.Millisecond))
+			if report.Aborted > 0 {
+				fmt.Printf("  Aborted:    %d requests still in flight at the end of the grace period\n", report.Aborted)
+			}
+		}
 
 		// Remove PID file
 		os.Remove(pidPath)
@@ -110,9 +149,6 @@ var daemonRestartCmd = &cobra.Command{
 			if err := daemonStopCmd.RunE(cmd, args); err != nil {
 				return err
 			}
-
-			// Wait for daemon to stop
-			time.Sleep(1 * time.Second)
 		}
 
 		// Start daemon
@@ -120,6 +156,63 @@ var daemonRestartCmd = &cobra.Command{
 	},
 }
 
+// daemonUpgradeCmd replaces the daemon process without dropping connections
+var daemonUpgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Upgrade the daemon to the installed binary without downtime",
+	Long: `Start the installed mogoly binary as a new daemon taking over the listening
+sockets. The running daemon drains its in-flight requests and exits once the new
+one is ready, and keeps serving if the new one fails to start.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if daemon.UpgradeSignal == nil {
+			return fmt.Errorf("upgrade is not supported on this platform, use `mogoly daemon restart`")
+		}
+		oldPID, err := readDaemonPID()
+		if err != nil || !isDaemonRunning() {
+			fmt.Println("Daemon is not running")
+			return nil
+		}
+		process, err := os.FindProcess(oldPID)
+		if err != nil {
+			return fmt.Errorf("failed to find process: %v", err)
+		}
+
+		if reportPath, err := daemon.GetShutdownReportPath(); err == nil {
+			os.Remove(reportPath)
+		}
+		if err := process.Signal(daemon.UpgradeSignal); err != nil {
+			return fmt.Errorf("failed to upgrade daemon: %v", err)
+		}
+
+		fmt.Println("Waiting for the new daemon to take over...")
+		deadline := time.Now().Add(daemonUpgradeTimeout)
+		newPID := oldPID
+		for newPID == oldPID {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("the new daemon did not start, the current one keeps serving (see `mogoly daemon logs`)")
+			}
+			time.Sleep(100 * time.Millisecond)
+			if pid, err := readDaemonPID(); err == nil {
+				newPID = pid
+			}
+		}
+		fmt.Printf("✓ Daemon upgraded (pid %d -> %d)\n", oldPID, newPID)
+
+		// The previous daemon drains before exiting
+		deadline = time.Now().Add(daemonStopTimeout)
+		for process.Signal(syscall.Signal(0)) == nil && time.Now().Before(deadline) {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if report, err := daemon.ReadShutdownReport(); err == nil {
+			fmt.Printf("  Previous daemon drained %d requests in %s\n", report.InFlight, report.Duration.Round(time.Millisecond))
+			if report.Aborted > 0 {
+				fmt.Printf("  Aborted:    %d requests still in flight at the end of the grace period\n", report.Aborted)
+			}
+		}
+		return nil
+	},
+}
+
 // daemonStatusCmd shows daemon status
 var daemonStatusCmd = &cobra.Command{
 	Use:     "status",
@@ -135,7 +228,7 @@ var daemonStatusCmd = &cobra.Command{
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		resp, err := client.SendAction(ctx, daemon.ActionDaemonStatus, nil)
+		resp, err := client.SendAction(ctx, actions.ActionDaemonStatus, nil)
 		if err != nil {
 			fmt.Println("Daemon is not responding")
 			return nil
@@ -154,6 +247,11 @@ var daemonStatusCmd = &cobra.Command{
 		fmt.Printf("  PID:        %.0f\n", status["pid"])
 		fmt.Printf("  Socket:     %s\n", status["socket"])
 		fmt.Printf("  Started At: %s\n", status["started_at"])
+		if ready, _ := status["ready"].(bool); ready {
+			fmt.Println("  Ready:      yes")
+		} else {
+			fmt.Println("  Ready:      no, draining")
+		}
 
 		return nil
 	},
@@ -191,6 +289,18 @@ var daemonLogsCmd = &cobra.Command{
 
 // Helper functions
 
+func readDaemonPID() (int, error) {
+	pidPath, err := daemon.GetPIDFilePath()
+	if err != nil {
+		return 0, err
+	}
+	pidBytes, err := os.ReadFile(pidPath)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(pidBytes))
+}
+
 func isDaemonRunning() bool {
 	pidPath, err := daemon.GetPIDFilePath()
 	if err != nil {
@@ -224,17 +334,31 @@ func startDaemonForeground() error {
 	if err != nil {
 		return fmt.Errorf("failed to create daemon server: %v", err)
 	}
+	server.SetConfigPath(daemonConfigPath)
+	server.SetGracePeriod(daemonGracePeriod)
+	server.SetAdminAddr(daemonAdminAddr)
 
 	if err := server.Start(); err != nil {
 		return fmt.Errorf("failed to start daemon: %v", err)
 	}
 
 	fmt.Println("✓ Daemon started successfully")
+	entryPoints := server.EntryPoints()
+	for _, name := range slices.Sorted(maps.Keys(entryPoints)) {
+		fmt.Printf("  %-10s %s\n", name+":", entryPoints[name])
+	}
+	if addr := server.AdminAddr(); addr != "" {
+		fmt.Printf("  %-10s http://%s/api/v1\n", "admin:", addr)
+	}
 	fmt.Println("Press Ctrl+C to stop")
 
 	// Wait for shutdown
 	server.Wait()
 
+	if report, err := daemon.ReadShutdownReport(); err == nil && report.Aborted > 0 {
+		fmt.Printf("Daemon stopped, %d requests were still in flight at the end of the grace period\n", report.Aborted)
+	}
+
 	return nil
 }
 
@@ -246,21 +370,57 @@ func startDaemonDetached() error {
 	}
 
 	// Start daemon as background process
-	cmd := exec.Command(executable, "daemon", "start")
+	args := []string{"daemon", "start"}
+	if daemonConfigPath != "" {
+		args = append(args, "--config", daemonConfigPath)
+	}
+	args = append(args, "--grace-period", daemonGracePeriod.String())
+	if daemonAdminAddr != "" {
+		args = append(args, "--admin-addr", daemonAdminAddr)
+	}
+	// The start errors of the daemon, a port already in use for instance, go to a file
+	// read back if it exits while starting.
+	errFile, err := os.CreateTemp("", "mogoly-daemon-*.err")
+	if err != nil {
+		return fmt.Errorf("failed to start daemon: %v", err)
+	}
+	defer os.Remove(errFile.Name())
+	defer errFile.Close()
+
+	cmd := exec.Command(executable, args...)
 	cmd.Stdout = nil
-	cmd.Stderr = nil
+	cmd.Stderr = errFile
 	cmd.Stdin = nil
 
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start daemon: %v", err)
 	}
 
-	// Detach from parent
-	cmd.Process.Release()
-
-	fmt.Println("✓ Daemon started successfully in background")
-
-	return nil
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(daemonStartTimeout)
+	for {
+		select {
+		case <-exited:
+			out, _ := os.ReadFile(errFile.Name())
+			msg := strings.TrimPrefix(strings.TrimSpace(string(out)), "Error: ")
+			if msg == "" {
+				msg = "see `mogoly daemon logs`"
+			}
+			return fmt.Errorf("daemon exited while starting: %s", msg)
+		case <-timeout:
+			fmt.Println("Daemon is still starting, check `mogoly daemon status`")
+			return nil
+		case <-ticker.C:
+			if isDaemonRunning() {
+				fmt.Println("✓ Daemon started successfully in background")
+				return nil
+			}
+		}
+	}
 }
 
 func init() {
@@ -270,11 +430,15 @@ func init() {
 	daemonCmd.AddCommand(daemonStartCmd)
 	daemonCmd.AddCommand(daemonStopCmd)
 	daemonCmd.AddCommand(daemonRestartCmd)
+	daemonCmd.AddCommand(daemonUpgradeCmd)
 	daemonCmd.AddCommand(daemonStatusCmd)
 	daemonCmd.AddCommand(daemonLogsCmd)
 
 	// Flags
 	daemonStartCmd.Flags().BoolVarP(&daemonDetach, "detach", "d", false, "Run daemon in background")
+	daemonStartCmd.Flags().StringVarP(&daemonConfigPath, "config", "c", "", "Config file applied and watched for live reloads (default ~/.mogoly/router.yaml)")
+	daemonStartCmd.Flags().DurationVar(&daemonGracePeriod, "grace-period", daemon.DefaultGracePeriod, "Time given to the in-flight requests to complete when stopping")
+	daemonStartCmd.Flags().StringVar(&daemonAdminAddr, "admin-addr", "", "Listen address of the authenticated admin HTTP API, e.g. 127.0.0.1:9090 (disabled when empty)")
 	daemonLogsCmd.Flags().IntVarP(&tailLines, "tail", "t", 100, "Number of lines to show")
 	daemonLogsCmd.Flags().BoolVarP(&followLogs, "follow", "f", false, "Follow log output")
 }
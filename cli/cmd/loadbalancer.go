@@ -10,7 +10,10 @@ import (
 	"text/tabwriter"
 	"time"
 
+	"github.com/DoniLite/Mogoly/cli/actions"
 	"github.com/DoniLite/Mogoly/cli/daemon"
+	"github.com/DoniLite/Mogoly/core"
+	"github.com/DoniLite/Mogoly/core/server"
 	"github.com/spf13/cobra"
 )
 
@@ -19,6 +22,10 @@ var (
 	lbName       string
 	backendURL   string
 	backendName  string
+
+	maintenanceRetryAfter int
+	maintenanceAllowIPs   []string
+	maintenanceMessage    string
 )
 
 // lbCmd represents the load balancer command
@@ -32,7 +39,8 @@ Examples:
   mogoly lb create --name api-gateway --config config.yaml
   mogoly lb list
   mogoly lb add-backend api-gateway --url http://localhost:8081
-  mogoly lb health api-gateway`,
+  mogoly lb health api-gateway
+  mogoly lb maintenance api-gateway on --retry-after 600 --allow-ip 10.0.0.0/8`,
 }
 
 // lbCreateCmd creates a new load balancer
@@ -44,12 +52,15 @@ var lbCreateCmd = &cobra.Command{
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		payload := daemon.LBCreatePayload{
-			Name:       lbName,
-			ConfigPath: lbConfigPath,
+		payload := &actions.ServerCreatePayload{Name: lbName}
+		if lbConfigPath != "" {
+			var err error
+			if payload, err = loadServerConfig(lbConfigPath, lbName); err != nil {
+				return err
+			}
 		}
 
-		resp, err := client.SendAction(ctx, daemon.ActionLBCreate, payload)
+		resp, err := client.SendAction(ctx, actions.ActionServerCreate, payload)
 		if err != nil {
 			return fmt.Errorf("failed to communicate with daemon: %v", err)
 		}
@@ -73,7 +84,7 @@ var lbListCmd = &cobra.Command{
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		resp, err := client.SendAction(ctx, daemon.ActionLBList, nil)
+		resp, err := client.SendAction(ctx, actions.ActionServerList, nil)
 		if err != nil {
 			return fmt.Errorf("failed to communicate with daemon: %v", err)
 		}
@@ -97,12 +108,17 @@ var lbListCmd = &cobra.Command{
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
 		fmt.Fprintln(w, "NAME\tHOST\tPORT\tBACKENDS\tSTATUS")
 		for _, lb := range lbs {
-			fmt.Fprintf(w, "%s\t%s\t%.0f\t%.0f\t%s\n",
+			status := "unhealthy"
+			if healthy, _ := lb["is_healthy"].(bool); healthy {
+				status = "healthy"
+			}
+			backends, _ := lb["balance"].([]interface{})
+			fmt.Fprintf(w, "%s\t%s\t%.0f\t%d\t%s\n",
 				lb["name"],
 				lb["host"],
 				lb["port"],
-				lb["backends_count"],
-				lb["status"])
+				len(backends),
+				status)
 		}
 		w.Flush()
 
@@ -123,13 +139,12 @@ var lbAddBackendCmd = &cobra.Command{
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		payload := daemon.LBAddBackendPayload{
-			LBName:      lbName,
-			BackendName: backendName,
-			BackendURL:  backendURL,
+		payload := actions.ServerAddBackendPayload{
+			Name:   lbName,
+			Server: &server.Server{Name: backendName, URL: backendURL},
 		}
 
-		resp, err := client.SendAction(ctx, daemon.ActionLBAddBackend, payload)
+		resp, err := client.SendAction(ctx, actions.ActionServerAddBackend, payload)
 		if err != nil {
 			return fmt.Errorf("failed to communicate with daemon: %v", err)
 		}
@@ -157,12 +172,12 @@ var lbRemoveBackendCmd = &cobra.Command{
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		payload := map[string]string{
-			"lb_name":      lbName,
-			"backend_name": backendName,
+		payload := actions.ServerRemoveBackendPayload{
+			BaseServerName: lbName,
+			BackendName:    backendName,
 		}
 
-		resp, err := client.SendAction(ctx, daemon.ActionLBRemoveBackend, payload)
+		resp, err := client.SendAction(ctx, actions.ActionServerRemoveBackend, payload)
 		if err != nil {
 			return fmt.Errorf("failed to communicate with daemon: %v", err)
 		}
@@ -189,7 +204,7 @@ var lbHealthCmd = &cobra.Command{
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		resp, err := client.SendAction(ctx, daemon.ActionLBHealth, map[string]string{"lb_name": lbName})
+		resp, err := client.SendAction(ctx, actions.ActionServerHealth, actions.CheckServerHealthPayload{Name: lbName})
 		if err != nil {
 			return fmt.Errorf("failed to communicate with daemon: %v", err)
 		}
@@ -198,29 +213,32 @@ var lbHealthCmd = &cobra.Command{
 			return fmt.Errorf("failed to check health: %s", resp.Error)
 		}
 
-		var health map[string]interface{}
+		var health struct {
+			SelfStatus       *server.ServerStatus      `json:"self_status"`
+			AllServersStatus *server.HealthCheckStatus `json:"all_servers_status"`
+		}
 		if err := resp.DecodePayload(&health); err != nil {
 			return err
 		}
 
 		fmt.Printf("Load Balancer: %s\n", lbName)
-		fmt.Printf("Status: %s\n", health["status"])
+		status := "unhealthy"
+		if health.SelfStatus != nil && health.SelfStatus.Healthy {
+			status = "healthy"
+		}
+		fmt.Printf("Status: %s\n", status)
 
-		if pass, ok := health["healthy_backends"].([]interface{}); ok && len(pass) > 0 {
+		if health.AllServersStatus != nil && len(health.AllServersStatus.Pass) > 0 {
 			fmt.Println("\nHealthy Backends:")
-			for _, b := range pass {
-				if backend, ok := b.(map[string]interface{}); ok {
-					fmt.Printf("  ✓ %s (%s)\n", backend["name"], backend["url"])
-				}
+			for _, backend := range health.AllServersStatus.Pass {
+				fmt.Printf("  ✓ %s (%s)\n", backend.Name, backend.Url)
 			}
 		}
 
-		if fail, ok := health["unhealthy_backends"].([]interface{}); ok && len(fail) > 0 {
+		if health.AllServersStatus != nil && len(health.AllServersStatus.Fail) > 0 {
 			fmt.Println("\nUnhealthy Backends:")
-			for _, b := range fail {
-				if backend, ok := b.(map[string]interface{}); ok {
-					fmt.Printf("  ✗ %s (%s)\n", backend["name"], backend["url"])
-				}
+			for _, backend := range health.AllServersStatus.Fail {
+				fmt.Printf("  ✗ %s (%s)\n", backend.Name, backend.Url)
 			}
 		}
 
@@ -241,7 +259,7 @@ var lbStartCmd = &cobra.Command{
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		resp, err := client.SendAction(ctx, daemon.ActionLBStart, map[string]string{"lb_name": lbName})
+		resp, err := client.SendAction(ctx, actions.ActionServerStart, map[string]string{"name": lbName})
 		if err != nil {
 			return fmt.Errorf("failed to communicate with daemon: %v", err)
 		}
@@ -268,7 +286,7 @@ var lbStopCmd = &cobra.Command{
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		resp, err := client.SendAction(ctx, daemon.ActionLBStop, map[string]string{"lb_name": lbName})
+		resp, err := client.SendAction(ctx, actions.ActionServerStop, map[string]string{"name": lbName})
 		if err != nil {
 			return fmt.Errorf("failed to communicate with daemon: %v", err)
 		}
@@ -282,6 +300,81 @@ var lbStopCmd = &cobra.Command{
 	},
 }
 
+// lbMaintenanceCmd toggles the maintenance mode of a load balancer
+var lbMaintenanceCmd = &cobra.Command{
+	Use:       "maintenance [lb-name] [on|off]",
+	Short:     "Toggle the maintenance mode of a load balancer",
+	Long:      `While in maintenance, every client outside of the allow-list receives a 503 page with a Retry-After header.`,
+	Args:      cobra.ExactArgs(2),
+	ValidArgs: []string{"on", "off"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		lbName := args[0]
+		var enabled bool
+		switch args[1] {
+		case "on":
+			enabled = true
+		case "off":
+		default:
+			return fmt.Errorf("invalid maintenance state %q, expected on or off", args[1])
+		}
+
+		client := daemon.NewClient("")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		payload := actions.ServerMaintenancePayload{
+			Name:       lbName,
+			Enabled:    enabled,
+			RetryAfter: maintenanceRetryAfter,
+			AllowIPs:   maintenanceAllowIPs,
+			Message:    maintenanceMessage,
+		}
+
+		resp, err := client.SendAction(ctx, actions.ActionServerMaintenance, payload)
+		if err != nil {
+			return fmt.Errorf("failed to communicate with daemon: %v", err)
+		}
+
+		if resp.Error != "" {
+			return fmt.Errorf("failed to update maintenance mode: %s", resp.Error)
+		}
+
+		if enabled {
+			fmt.Printf("✓ Load balancer '%s' is now in maintenance\n", lbName)
+		} else {
+			fmt.Printf("✓ Load balancer '%s' is back online\n", lbName)
+		}
+		return nil
+	},
+}
+
+// loadServerConfig returns the server named name of the router config file at path,
+// its only server when it declares one.
+func loadServerConfig(path, name string) (*server.Server, error) {
+	content, err := core.LoadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format, err := core.DiscoverConfigFormat(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := core.ParseConfig(content, format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	for _, s := range cfg.Servers {
+		if s != nil && s.Name == name {
+			return s, nil
+		}
+	}
+	if len(cfg.Servers) == 1 && cfg.Servers[0] != nil {
+		cfg.Servers[0].Name = name
+		return cfg.Servers[0], nil
+	}
+	return nil, fmt.Errorf("no server %s in %s", name, path)
+}
+
 func init() {
 	rootCmd.AddCommand(lbCmd)
 
@@ -293,6 +386,7 @@ func init() {
 	lbCmd.AddCommand(lbHealthCmd)
 	lbCmd.AddCommand(lbStartCmd)
 	lbCmd.AddCommand(lbStopCmd)
+	lbCmd.AddCommand(lbMaintenanceCmd)
 
 	// Flags for create command
 	lbCreateCmd.Flags().StringVarP(&lbName, "name", "n", "", "Load balancer name (required)")
@@ -304,6 +398,11 @@ func init() {
 	lbAddBackendCmd.MarkFlagRequired("url")
 	lbAddBackendCmd.Flags().StringVarP(&backendName, "name", "n", "", "Backend name")
 
+	// Flags for maintenance command
+	lbMaintenanceCmd.Flags().IntVar(&maintenanceRetryAfter, "retry-after", 300, "Seconds sent in the Retry-After header")
+	lbMaintenanceCmd.Flags().StringSliceVar(&maintenanceAllowIPs, "allow-ip", nil, "IPs or CIDRs still reaching the backends")
+	lbMaintenanceCmd.Flags().StringVar(&maintenanceMessage, "message", "", "Message shown on the maintenance page")
+
 	// Global flags
 	lbCmd.PersistentFlags().StringVarP(&outputFormat, "format", "o", "table", "Output format (table, json, yaml)")
 }
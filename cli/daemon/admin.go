@@ -0,0 +1,348 @@
+package daemon
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DoniLite/Mogoly/cli/actions"
+	mogoly_sync "github.com/DoniLite/Mogoly/sync"
+)
+
+// envAdminToken overrides the token of the admin API file, see GetAdminTokenPath.
+const envAdminToken = "MOGOLY_ADMIN_TOKEN"
+
+// Largest request body accepted by the admin API, a whole config for PUT /config.
+const adminMaxBodyBytes = 8 << 20
+
+// The OpenAPI description of the admin API, served at GET /api/v1/openapi.yaml.
+//
+//go:embed openapi.yaml
+var adminOpenAPI []byte
+
+// adminRoute maps a request of the admin API to a daemon action.
+type adminRoute struct {
+	pattern string                  // Method and path, see http.ServeMux
+	action  mogoly_sync.Action_Type // Action run by the route
+	created bool                    // Answers 201 Created instead of 200 OK
+	// Builds the action payload from the request, the body is sent as is when nil
+	payload func(r *http.Request) (json.RawMessage, error)
+}
+
+// adminRoutes are the routes of the admin API, keep openapi.yaml in sync.
+var adminRoutes = []adminRoute{
+	{pattern: "GET /api/v1/servers", action: actions.ActionServerList},
+	{pattern: "POST /api/v1/servers", action: actions.ActionServerCreate, created: true},
+	{pattern: "DELETE /api/v1/servers/{name}", action: actions.ActionServerRemove, payload: fromPath(map[string]string{"name": "name"})},
+	{pattern: "POST /api/v1/servers/{name}/backends", action: actions.ActionServerAddBackend, created: true, payload: bodyAs("server", map[string]string{"name": "name"})},
+	{pattern: "DELETE /api/v1/servers/{name}/backends/{backend}", action: actions.ActionServerRemoveBackend, payload: fromPath(map[string]string{"base_server_name": "name", "backend_name": "backend"})},
+	{pattern: "GET /api/v1/servers/{name}/health", action: actions.ActionServerHealth, payload: healthPayload},
+	{pattern: "PUT /api/v1/servers/{name}/maintenance", action: actions.ActionServerMaintenance, payload: bodyWith(map[string]string{"name": "name"})},
+	{pattern: "POST /api/v1/cache/purge", action: actions.ActionCachePurge},
+
+	{pattern: "GET /api/v1/domains", action: actions.ActionDomainList},
+	{pattern: "POST /api/v1/domains", action: actions.ActionDomainAdd, created: true},
+	{pattern: "DELETE /api/v1/domains/{domain}", action: actions.ActionDomainRemove, payload: fromPath(map[string]string{"domain": "domain"})},
+
+	{pattern: "GET /api/v1/instances", action: actions.ActionCloudList},
+	{pattern: "POST /api/v1/instances", action: actions.ActionCloudCreate, created: true},
+	{pattern: "GET /api/v1/instances/{id}", action: actions.ActionCloudInspect, payload: fromPath(map[string]string{"id": "id"})},
+	{pattern: "DELETE /api/v1/instances/{id}", action: actions.ActionCloudDelete, payload: fromPath(map[string]string{"id": "id"})},
+	{pattern: "POST /api/v1/instances/{id}/start", action: actions.ActionCloudStart, payload: fromPath(map[string]string{"id": "id"})},
+	{pattern: "POST /api/v1/instances/{id}/stop", action: actions.ActionCloudStop, payload: fromPath(map[string]string{"id": "id"})},
+	{pattern: "POST /api/v1/instances/{id}/restart", action: actions.ActionCloudRestart, payload: fromPath(map[string]string{"id": "id"})},
+	{pattern: "GET /api/v1/instances/{id}/logs", action: actions.ActionCloudLogs, payload: logsPayload},
+	{pattern: "PUT /api/v1/instances/{id}/domain", action: actions.ActionCloudDomain, payload: bodyAs("domain", map[string]string{"id": "id"})},
+
+	{pattern: "GET /api/v1/config", action: actions.ActionConfigGet},
+	{pattern: "PUT /api/v1/config", action: actions.ActionConfigApply},
+}
+
+// serveAdmin starts the admin API when an address is set, on the listener inherited
+// from the daemon being upgraded if any.
+func (s *Server) serveAdmin(ln net.Listener) error {
+	s.mu.RLock()
+	addr := s.adminAddr
+	s.mu.RUnlock()
+	if addr == "" {
+		if ln != nil {
+			ln.Close()
+		}
+		return nil
+	}
+
+	token, err := adminToken()
+	if err != nil {
+		return fmt.Errorf("failed to load the admin token: %v", err)
+	}
+	if ln == nil {
+		if ln, err = net.Listen("tcp", addr); err != nil {
+			return fmt.Errorf("failed to start the admin API: %v", err)
+		}
+	}
+
+	hs := &http.Server{
+		Addr:              ln.Addr().String(),
+		Handler:           s.adminHandler(token),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	s.mu.Lock()
+	s.adminServer = hs
+	s.adminListener = ln
+	s.mu.Unlock()
+
+	s.log("Admin API listening on %s", hs.Addr)
+	go func() {
+		if err := hs.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log("Admin API error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// AdminAddr returns the listen address of the admin API, empty when it is disabled.
+func (s *Server) AdminAddr() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.adminServer == nil {
+		return ""
+	}
+	return s.adminServer.Addr
+}
+
+// adminHandler serves the admin API, the routes but the OpenAPI description and the
+// health check require the token as a bearer token.
+func (s *Server) adminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(adminOpenAPI)
+	})
+	// The probes get 503 once the daemon drains
+	mux.HandleFunc("GET /api/v1/health", func(w http.ResponseWriter, r *http.Request) {
+		ready := s.Ready()
+		status := http.StatusOK
+		if !ready {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]bool{"ready": ready})
+	})
+	for _, route := range adminRoutes {
+		mux.Handle(route.pattern, requireToken(token, s.adminAction(route)))
+	}
+	return mux
+}
+
+// adminAction runs the action of the route and answers with its payload.
+func (s *Server) adminAction(route adminRoute) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, adminMaxBodyBytes)
+		build := route.payload
+		if build == nil {
+			build = readBody
+		}
+		payload, err := build(r)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		reqID := r.Header.Get("X-Request-ID")
+		if reqID == "" {
+			reqID = randomHex(16)
+		}
+		w.Header().Set("X-Request-ID", reqID)
+
+		response := s.dispatch(r.Context(), reqID, route.action, payload)
+		if response == nil || len(response.Action.Payload) == 0 && response.Error == "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if response.Error != "" || response.Action.Type == mogoly_sync.ERROR {
+			writeAdminError(w, adminErrorStatus(response), response.Error)
+			return
+		}
+
+		status := http.StatusOK
+		if route.created {
+			status = http.StatusCreated
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(response.Action.Payload)
+	}
+}
+
+// adminErrorStatus returns the HTTP status of an action error from the code of its payload.
+func adminErrorStatus(response *mogoly_sync.Message) int {
+	var payload mogoly_sync.ErrorPayload
+	json.Unmarshal(response.Action.Payload, &payload)
+	switch payload.Code {
+	case mogoly_sync.ErrorCodeNotFound:
+		return http.StatusNotFound
+	case mogoly_sync.ErrorCodeAlreadyExists:
+		return http.StatusConflict
+	case mogoly_sync.ErrorCodeUnavailable:
+		return http.StatusServiceUnavailable
+	case mogoly_sync.ErrorCodeInvalid:
+		return http.StatusBadRequest
+	default:
+		// Docker, filesystem and persistence failures are not the client's fault
+		return http.StatusInternalServerError
+	}
+}
+
+func writeAdminError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// requireToken rejects the requests not carrying token as a bearer token.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mogoly"`)
+			writeAdminError(w, http.StatusUnauthorized, "missing or invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminToken returns the token of the admin API, from MOGOLY_ADMIN_TOKEN or the token
+// file. The file is created with a random token on first use.
+func adminToken() (string, error) {
+	if token := os.Getenv(envAdminToken); token != "" {
+		return token, nil
+	}
+	path, err := GetAdminTokenPath()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", fmt.Errorf("token file %s is empty", path)
+		}
+		return token, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	token := randomHex(32)
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// readBody returns the request body, nil when empty.
+func readBody(r *http.Request) (json.RawMessage, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return nil, nil
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("invalid JSON body")
+	}
+	return body, nil
+}
+
+// pathValues returns the path wildcards of r by payload field, fields maps the payload
+// fields to the wildcard names.
+func pathValues(r *http.Request, fields map[string]string) map[string]any {
+	values := make(map[string]any, len(fields))
+	for field, wildcard := range fields {
+		values[field] = r.PathValue(wildcard)
+	}
+	return values
+}
+
+// fromPath builds the payload from the path wildcards.
+func fromPath(fields map[string]string) func(r *http.Request) (json.RawMessage, error) {
+	return func(r *http.Request) (json.RawMessage, error) {
+		return json.Marshal(pathValues(r, fields))
+	}
+}
+
+// bodyWith sets the path wildcards on the JSON object of the body.
+func bodyWith(fields map[string]string) func(r *http.Request) (json.RawMessage, error) {
+	return func(r *http.Request) (json.RawMessage, error) {
+		body, err := readBody(r)
+		if err != nil {
+			return nil, err
+		}
+		payload := make(map[string]any)
+		if body != nil {
+			if err := json.Unmarshal(body, &payload); err != nil {
+				return nil, fmt.Errorf("invalid JSON body: %v", err)
+			}
+		}
+		maps.Copy(payload, pathValues(r, fields))
+		return json.Marshal(payload)
+	}
+}
+
+// bodyAs sets the body as the field of the payload, next to the path wildcards.
+func bodyAs(field string, fields map[string]string) func(r *http.Request) (json.RawMessage, error) {
+	return func(r *http.Request) (json.RawMessage, error) {
+		body, err := readBody(r)
+		if err != nil {
+			return nil, err
+		}
+		payload := pathValues(r, fields)
+		if body != nil {
+			payload[field] = body
+		}
+		return json.Marshal(payload)
+	}
+}
+
+func healthPayload(r *http.Request) (json.RawMessage, error) {
+	selfOnly := false
+	if v := r.URL.Query().Get("self_only"); v != "" {
+		var err error
+		if selfOnly, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid self_only %q", v)
+		}
+	}
+	return json.Marshal(actions.CheckServerHealthPayload{Name: r.PathValue("name"), SelfOnly: selfOnly})
+}
+
+func logsPayload(r *http.Request) (json.RawMessage, error) {
+	tail := 0
+	if v := r.URL.Query().Get("tail"); v != "" {
+		var err error
+		if tail, err = strconv.Atoi(v); err != nil || tail < 0 {
+			return nil, fmt.Errorf("invalid tail %q", v)
+		}
+	}
+	return json.Marshal(actions.CloudLogsPayload{ID: r.PathValue("id"), Tail: tail})
+}
@@ -0,0 +1,126 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DoniLite/Mogoly/cli/actions"
+	"github.com/DoniLite/Mogoly/core/router"
+	"github.com/DoniLite/Mogoly/sync"
+)
+
+func TestAdminAPI(t *testing.T) {
+	// The handlers answer with the payload they got, or the error asked for
+	failures := map[string]error{
+		"missing":   fmt.Errorf("server app.test %w", router.ErrNotFound),
+		"duplicate": fmt.Errorf("server app.test %w", actions.ErrAlreadyExists),
+		"invalid":   actions.Invalid(errors.New("invalid server")),
+		"config":    fmt.Errorf("%w: unknown tls mode", router.ErrInvalidConfig),
+		"starting":  fmt.Errorf("domain manager %w", actions.ErrUnavailable),
+		"uncoded":   errors.New("backend api-1 not found"), // The status comes from the code, not the message
+	}
+	echo := func(ctx context.Context, reqID string, payload any) *sync.Message {
+		var p map[string]any
+		json.Unmarshal(payload.(json.RawMessage), &p)
+		if name, ok := p["fail"].(string); ok {
+			return actions.NewErrorMessage(failures[name], reqID)
+		}
+		p["request_id"] = reqID
+		msg, _ := sync.NewMessage(actions.ActionServerCreate, p, nil)
+		return msg
+	}
+	for _, action := range []sync.Action_Type{actions.ActionServerCreate, actions.ActionServerRemoveBackend, actions.ActionServerAddBackend, actions.ActionServerMaintenance, actions.ActionCloudLogs} {
+		actions.RegisterHandler(action, echo)
+	}
+
+	s := &Server{running: true}
+	ts := httptest.NewServer(s.adminHandler("secret"))
+	defer ts.Close()
+
+	cases := []struct {
+		name, method, path, body, token string
+		status                          int
+		want                            string // Expected in the response body
+	}{
+		{"no token", "GET", "/api/v1/servers", "", "", http.StatusUnauthorized, `"error":"missing or invalid admin token"`},
+		{"bad token", "GET", "/api/v1/servers", "", "wrong", http.StatusUnauthorized, "missing or invalid admin token"},
+		{"spec without token", "GET", "/api/v1/openapi.yaml", "", "", http.StatusOK, "openapi: 3.0.3"},
+		{"health without token", "GET", "/api/v1/health", "", "", http.StatusServiceUnavailable, `{"ready":false}`}, // No router built
+		{"body payload", "POST", "/api/v1/servers", `{"name":"app.test"}`, "secret", http.StatusCreated, `"name":"app.test"`},
+		{"invalid body", "POST", "/api/v1/servers", `{"name"`, "secret", http.StatusBadRequest, "invalid JSON body"},
+		{"path payload", "DELETE", "/api/v1/servers/app.test/backends/api-1", "", "secret", http.StatusOK, `"backend_name":"api-1","base_server_name":"app.test"`},
+		{"nested body", "POST", "/api/v1/servers/app.test/backends", `{"url":"http://10.0.0.2"}`, "secret", http.StatusCreated, `"name":"app.test","request_id":"req-1","server":{"url":"http://10.0.0.2"}`},
+		{"body with path", "PUT", "/api/v1/servers/app.test/maintenance", `{"enabled":true,"name":"other"}`, "secret", http.StatusOK, `"enabled":true,"name":"app.test"`},
+		{"query payload", "GET", "/api/v1/instances/db/logs?tail=20", "", "secret", http.StatusOK, `"id":"db","request_id":"req-1","tail":20`},
+		{"invalid query", "GET", "/api/v1/instances/db/logs?tail=all", "", "secret", http.StatusBadRequest, `invalid tail`},
+		{"not found", "POST", "/api/v1/servers", `{"fail":"missing"}`, "secret", http.StatusNotFound, `"error":"server app.test not found"`},
+		{"conflict", "POST", "/api/v1/servers", `{"fail":"duplicate"}`, "secret", http.StatusConflict, "already exists"},
+		{"failure", "POST", "/api/v1/servers", `{"fail":"invalid"}`, "secret", http.StatusBadRequest, "invalid server"},
+		{"invalid config", "POST", "/api/v1/servers", `{"fail":"config"}`, "secret", http.StatusBadRequest, "unknown tls mode"},
+		{"unavailable", "POST", "/api/v1/servers", `{"fail":"starting"}`, "secret", http.StatusServiceUnavailable, "domain manager unavailable"},
+		{"uncoded failure", "POST", "/api/v1/servers", `{"fail":"uncoded"}`, "secret", http.StatusInternalServerError, "backend api-1 not found"},
+		{"no handler", "GET", "/api/v1/config", "", "secret", http.StatusBadRequest, "unknown action"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tc.method, ts.URL+tc.path, strings.NewReader(tc.body))
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			req.Header.Set("X-Request-ID", "req-1")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tc.status || !strings.Contains(string(body), tc.want) {
+				t.Fatalf("got %d %s, want %d with %s", resp.StatusCode, body, tc.status, tc.want)
+			}
+		})
+	}
+
+	s.running = false
+	req, _ := http.NewRequest("POST", ts.URL+"/api/v1/servers", strings.NewReader(`{}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("stopping daemon answered %d", resp.StatusCode)
+	}
+}
+
+// The OpenAPI description lists every route of the API.
+func TestAdminAPI_OpenAPI(t *testing.T) {
+	documented := make(map[string]bool)
+	path := ""
+	for _, line := range strings.Split(string(adminOpenAPI), "\n") {
+		if m := regexp.MustCompile(`^  (/\S*):$`).FindStringSubmatch(line); m != nil {
+			path = m[1]
+		} else if m := regexp.MustCompile(`^    (get|post|put|delete):$`).FindStringSubmatch(line); m != nil {
+			documented[strings.ToUpper(m[1])+" /api/v1"+path] = true
+		}
+	}
+	for _, route := range adminRoutes {
+		if !documented[route.pattern] {
+			t.Errorf("route %s missing from openapi.yaml", route.pattern)
+		}
+		delete(documented, route.pattern)
+	}
+	delete(documented, "GET /api/v1/openapi.yaml")
+	delete(documented, "GET /api/v1/health")
+	for route := range documented {
+		t.Errorf("openapi.yaml documents %s which is not served", route)
+	}
+}
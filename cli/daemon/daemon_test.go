@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -94,3 +95,46 @@ func TestDaemonStatus(t *testing.T) {
 		t.Errorf("Expected socket path %s, got %v", socketPath, status["socket"])
 	}
 }
+
+// A start failing before the entrypoints are served releases the socket and can be retried.
+func TestDaemonStart_Failure(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("HOME", tmpDir)
+	socketPath := filepath.Join(tmpDir, "mogoly_test_failure.sock")
+
+	server, err := NewServer(socketPath)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.SetConfigPath(filepath.Join(tmpDir, "missing.yaml"))
+
+	for range 2 {
+		if err := server.Start(); err == nil || strings.Contains(err.Error(), "already running") {
+			t.Fatalf("expected a start failure, got %v", err)
+		}
+		if server.running {
+			t.Fatal("failed start left the daemon running")
+		}
+		if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+			t.Fatalf("failed start left the socket: %v", err)
+		}
+	}
+}
+
+func TestDaemonStatusHandler(t *testing.T) {
+	s := &Server{running: true, socketPath: "/tmp/mogoly_test_status.sock"}
+	s.registerHandlers()
+
+	response := s.dispatch(context.Background(), "req-1", actions.ActionDaemonStatus, nil)
+	var status Status
+	if err := response.DecodePayload(&status); err != nil {
+		t.Fatal(err)
+	}
+	// No router is built, the daemon cannot serve yet.
+	if status.PID != os.Getpid() || status.Socket != s.socketPath || status.Ready {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if response := s.dispatch(context.Background(), "req-2", actions.ActionDaemonPing, nil); response.Error != "" {
+		t.Fatalf("ping failed: %s", response.Error)
+	}
+}
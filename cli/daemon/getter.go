@@ -1,7 +1,17 @@
 package daemon
 
-import "github.com/DoniLite/Mogoly/core/router"
+import (
+	"github.com/DoniLite/Mogoly/core/domain"
+	"github.com/DoniLite/Mogoly/core/router"
+)
 
 func GetServerRouter() *router.RouterState {
 	return server.mogolyRouter
-}
\ No newline at end of file
+}
+
+// GetDomainManager returns the domain manager providing the certificates of the entrypoints.
+func GetDomainManager() *domain.Manager {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	return server.domainManager
+}
@@ -17,9 +17,12 @@ const (
 	WindowsPipePath = `\\.\pipe\mogoly`
 
 	// State and config directories
-	ConfigDir = config.BASE_CONFIG_DIR
-	StateFile = "daemon.json"
-	PIDFile   = "mogoly.pid"
+	ConfigDir    = config.BASE_CONFIG_DIR
+	StateFile    = "daemon.json"
+	PIDFile      = "mogoly.pid"
+	ShutdownFile = "shutdown.json"
+	AdminToken   = "admin.token"
+	LogFile      = "mogoly.log"
 )
 
 // GetSocketPath returns the appropriate socket path for the current platform
@@ -68,6 +71,33 @@ func GetStateFilePath() (string, error) {
 	return filepath.Join(configDir, StateFile), nil
 }
 
+// GetShutdownReportPath returns the path to the report written by the daemon when it stops
+func GetShutdownReportPath() (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, ShutdownFile), nil
+}
+
+// GetLogFilePath returns the path to the file the daemon logs are written to
+func GetLogFilePath() (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, config.GetEnv(config.LOG_FILE, LogFile)), nil
+}
+
+// GetAdminTokenPath returns the path to the file holding the token of the admin API
+func GetAdminTokenPath() (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, AdminToken), nil
+}
+
 // GetPIDFilePath returns the path to the PID file
 func GetPIDFilePath() (string, error) {
 	configDir, err := GetConfigDir()
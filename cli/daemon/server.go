@@ -2,6 +2,8 @@ package daemon
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -12,12 +14,18 @@ import (
 	"time"
 
 	"github.com/DoniLite/Mogoly/cli/actions"
+	"github.com/DoniLite/Mogoly/core"
 	"github.com/DoniLite/Mogoly/core/domain"
 	"github.com/DoniLite/Mogoly/core/events"
 	"github.com/DoniLite/Mogoly/core/router"
 	mogoly_sync "github.com/DoniLite/Mogoly/sync"
 )
 
+// DefaultGracePeriod is the time given to the in-flight requests and actions to complete
+// when the daemon stops.
+const DefaultGracePeriod = 30 * time.Second
+
+// Deprecated: the listen addresses come from the entrypoints section of the router config.
 const (
 	HTTP_ADDRESS string = ":80"
 	TLS_ADDRESS  string = ":443"
@@ -27,16 +35,27 @@ var server *Server
 
 // Server represents the daemon server
 type Server struct {
-	socketPath       string
-	listener         net.Listener
-	mu               sync.RWMutex
-	running          bool
-	shutdownChan     chan struct{}
-	syncServer       *mogoly_sync.Server
-	httpServer       *http.Server
-	mogolyRouter     *router.RouterState
-	mogolyHttpServer *http.Server
-	mogolyTlsServer  *http.Server
+	socketPath     string
+	listener       net.Listener
+	mu             sync.RWMutex
+	running        bool
+	shutdownChan   chan struct{}
+	syncServer     *mogoly_sync.Server
+	httpServer     *http.Server
+	mogolyRouter   *router.RouterState
+	entryPoints    map[string]*http.Server      // Listeners of the router entrypoints by name
+	tcpEntryPoints map[string]*router.TCPServer // Listeners of the tcp entrypoints by name
+	udpEntryPoints map[string]*router.UDPServer // Sockets of the udp entrypoints by name
+	domainManager  *domain.Manager              // Certificates of the https and tcp entrypoints
+	adminAddr      string                       // Listen address of the admin API, disabled when empty
+	adminServer    *http.Server                 // Server of the admin API
+	adminListener  net.Listener                 // Listener of the admin API, passed on upgrades
+	configPath     string                       // Config file watched for live reloads, ~/.mogoly/router.yaml when empty
+	stopWatch      func()                       // Stops the config file watcher, set once watching
+	gracePeriod    time.Duration                // Time given to the in-flight work when stopping
+	actions        sync.WaitGroup               // Actions being handled, they may be running Docker operations
+	upgrading      bool                         // Another daemon process takes over the listeners, socket and PID files
+	startedAt      time.Time                    // Set once the start succeeded
 }
 
 // NewServer creates a new daemon server
@@ -48,16 +67,40 @@ func NewServer(socketPath string) (*Server, error) {
 	s := &Server{
 		socketPath:   socketPath,
 		shutdownChan: make(chan struct{}),
+		gracePeriod:  DefaultGracePeriod,
 	}
 
 	// Initialize sync server
 	s.syncServer = mogoly_sync.NewServer(s.handleMessage, nil)
+	s.registerHandlers()
 
 	server = s
 
 	return s, nil
 }
 
+// SetConfigPath sets the config file applied at startup and watched for live reloads.
+func (s *Server) SetConfigPath(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.configPath = path
+}
+
+// SetAdminAddr sets the listen address of the admin API, it is disabled when empty.
+func (s *Server) SetAdminAddr(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.adminAddr = addr
+}
+
+// SetGracePeriod sets the time given to the in-flight requests and actions to complete
+// when the daemon stops, before their connections are closed.
+func (s *Server) SetGracePeriod(grace time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gracePeriod = grace
+}
+
 // Start starts the daemon server
 func (s *Server) Start() error {
 	s.mu.Lock()
@@ -68,29 +111,36 @@ func (s *Server) Start() error {
 	s.running = true
 	s.mu.Unlock()
 
-	// Remove existing socket file
-	if err := os.RemoveAll(s.socketPath); err != nil {
-		return fmt.Errorf("failed to remove existing socket: %v", err)
-	}
-
-	// Create listener
-	listener, err := net.Listen("unix", s.socketPath)
+	// Listeners passed by the daemon being upgraded, if any
+	inherited, inheritedPackets, err := inheritedListeners()
 	if err != nil {
-		return fmt.Errorf("failed to create listener: %v", err)
+		return err
 	}
-	s.listener = listener
+	upgrade := inherited != nil
 
-	// Set socket permissions
-	if err := os.Chmod(s.socketPath, 0666); err != nil {
-		return fmt.Errorf("failed to set socket permissions: %v", err)
-	}
+	listener, ok := inherited[socketListenerName]
+	if ok {
+		delete(inherited, socketListenerName)
+	} else {
+		// Remove existing socket file
+		if err := os.RemoveAll(s.socketPath); err != nil {
+			return fmt.Errorf("failed to remove existing socket: %v", err)
+		}
 
-	s.log("Daemon started on socket: %s", s.socketPath)
+		// Create listener
+		listener, err = net.Listen("unix", s.socketPath)
+		if err != nil {
+			return fmt.Errorf("failed to create listener: %v", err)
+		}
 
-	// Write PID file
-	if err := s.writePIDFile(); err != nil {
-		s.log("Warning: failed to write PID file: %v", err)
+		// Set socket permissions
+		if err := os.Chmod(s.socketPath, 0666); err != nil {
+			return fmt.Errorf("failed to set socket permissions: %v", err)
+		}
 	}
+	s.listener = listener
+
+	s.log("Daemon started on socket: %s", s.socketPath)
 
 	// Start sync server hub
 	s.syncServer.Run()
@@ -100,26 +150,68 @@ func (s *Server) Start() error {
 		Handler: s.syncServer,
 	}
 
-	// Build the router state
-	router.Startup(nil)
+	// Build the router state, an invalid router config fails the start
+	if err := router.Startup(nil); err != nil {
+		s.abortStart(upgrade)
+		return fmt.Errorf("failed to build router: %v", err)
+	}
 	r, err := router.GetRouter()
 	if err != nil || r == nil {
+		s.abortStart(upgrade)
 		return fmt.Errorf("failed to get or build router: %v", err)
 	}
 	s.mogolyRouter = r
 
+	if err := s.watchConfig(); err != nil {
+		s.abortStart(upgrade)
+		return err
+	}
+
 	domainManager, err := domain.NewManager()
 	if err != nil {
+		s.abortStart(upgrade)
 		return fmt.Errorf("failed to create domain manager: %v", err)
 	}
+	s.mu.Lock()
+	s.domainManager = domainManager
+	s.mu.Unlock()
+
+	// The admin listener is not an entrypoint
+	adminListener := inherited[adminListenerName]
+	delete(inherited, adminListenerName)
+
+	// Bind the entrypoints, a port already in use fails the start
+	router.InheritListeners(inherited)
+	router.InheritPacketConns(inheritedPackets)
+	entryPoints, err := router.ServeEntryPoints(domainManager)
+	tcpEntryPoints, tcpErr := router.ServeTCPEntryPoints(domainManager)
+	udpEntryPoints, udpErr := router.ServeUDPEntryPoints()
+	s.mu.Lock()
+	s.entryPoints = entryPoints
+	s.tcpEntryPoints = tcpEntryPoints
+	s.udpEntryPoints = udpEntryPoints
+	s.mu.Unlock()
+	if err = errors.Join(err, tcpErr, udpErr); err != nil {
+		// The daemon being upgraded keeps serving with its socket and PID files
+		s.mu.Lock()
+		s.upgrading = upgrade
+		s.mu.Unlock()
+		s.Stop()
+		return fmt.Errorf("failed to start the entrypoints: %v", err)
+	}
 
-	// Build the mogoly servers
-	svr := router.ServeHTTP(HTTP_ADDRESS)
-	s.mogolyHttpServer = svr
+	if err := s.serveAdmin(adminListener); err != nil {
+		s.mu.Lock()
+		s.upgrading = upgrade
+		s.mu.Unlock()
+		s.Stop()
+		return err
+	}
 
-	// Build the mogoly TLS servers
-	tlsSvr := router.ServeHTTPS(TLS_ADDRESS, domainManager)
-	s.mogolyTlsServer = tlsSvr
+	// Write PID file, once started the daemon is reported as running
+	if err := s.writePIDFile(); err != nil {
+		s.log("Warning: failed to write PID file: %v", err)
+	}
 
 	go func() {
 		if err := s.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
@@ -127,9 +219,92 @@ func (s *Server) Start() error {
 		}
 	}()
 
+	s.mu.Lock()
+	s.startedAt = time.Now()
+	s.mu.Unlock()
+
 	// Handle shutdown signals
 	go s.handleSignals()
 
+	// The daemon being upgraded, if any, can drain
+	notifyReady()
+
+	return nil
+}
+
+// abortStart releases the socket of a start failing before the entrypoints are served,
+// the socket of the daemon being upgraded is left to it.
+func (s *Server) abortStart(upgrade bool) {
+	s.listener.Close()
+	if !upgrade {
+		os.RemoveAll(s.socketPath)
+	}
+	s.mu.Lock()
+	s.running = false
+	stopWatch := s.stopWatch
+	s.mu.Unlock()
+	if stopWatch != nil {
+		stopWatch()
+	}
+}
+
+// EntryPoints returns the listen address of the started entrypoints by name.
+func (s *Server) EntryPoints() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	addrs := make(map[string]string, len(s.entryPoints)+len(s.tcpEntryPoints)+len(s.udpEntryPoints))
+	for name, hs := range s.entryPoints {
+		addrs[name] = hs.Addr
+	}
+	for name, ts := range s.tcpEntryPoints {
+		addrs[name] = ts.Addr
+	}
+	for name, us := range s.udpEntryPoints {
+		addrs[name] = us.Addr
+	}
+	return addrs
+}
+
+// watchConfig applies the user supplied config file, if any, and watches the config
+// file so that its edits are reconciled with the running router.
+func (s *Server) watchConfig() error {
+	s.mu.RLock()
+	path := s.configPath
+	s.mu.RUnlock()
+
+	if path != "" {
+		content, err := core.LoadConfigFile(path)
+		if err != nil {
+			return err
+		}
+		format, err := core.DiscoverConfigFormat(path)
+		if err != nil {
+			return err
+		}
+		cfg, err := core.ParseConfig(content, format)
+		if err != nil {
+			return fmt.Errorf("invalid config file %s: %v", path, err)
+		}
+		summary, err := router.ReconcileConfig(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to apply config file %s: %v", path, err)
+		}
+		s.log("Config file %s applied: %s", path, summary)
+	} else {
+		var err error
+		if path, err = router.ConfigPath(); err != nil {
+			return fmt.Errorf("failed to resolve the router config path: %v", err)
+		}
+	}
+
+	stopWatch, err := core.WatchRouterConfig(path)
+	if err != nil {
+		return fmt.Errorf("failed to watch config file %s: %v", path, err)
+	}
+	s.mu.Lock()
+	s.stopWatch = stopWatch
+	s.mu.Unlock()
+	s.log("Watching %s for config changes", path)
 	return nil
 }
 
@@ -142,12 +317,7 @@ func (s *Server) handleMessage(msg *mogoly_sync.Message, conn *mogoly_sync.Conne
 
 	reqID := msg.RequestID
 
-	if handler, ok := actions.GetHandler(msg.Action.Type); ok {
-		response = handler(ctx, reqID, msg.Action.Payload)
-	} else {
-		response = NewErrorMessage(reqID, msg.Action.Type, fmt.Sprintf("unknown action: %d", msg.Action.Type))
-	}
-
+	response = s.dispatch(ctx, reqID, msg.Action.Type, msg.Action.Payload)
 	if response != nil {
 		conn.SendMsg(response)
 	}
@@ -155,39 +325,85 @@ func (s *Server) handleMessage(msg *mogoly_sync.Message, conn *mogoly_sync.Conne
 	return nil
 }
 
-// Stop stops the daemon server
+// dispatch runs the handler of the action, the socket and the admin API share it so that
+// the actions are waited for the same way when the daemon stops.
+func (s *Server) dispatch(ctx context.Context, reqID string, actionType mogoly_sync.Action_Type, payload json.RawMessage) *mogoly_sync.Message {
+	s.mu.RLock()
+	if !s.running {
+		s.mu.RUnlock()
+		msg := mogoly_sync.NewErrorMessageWithCode("daemon is shutting down", "", mogoly_sync.ErrorCodeUnavailable)
+		msg.RequestID = reqID
+		return msg
+	}
+	s.actions.Add(1)
+	s.mu.RUnlock()
+	defer s.actions.Done()
+
+	handler, ok := actions.GetHandler(actionType)
+	if !ok {
+		msg := mogoly_sync.NewErrorMessageWithCode(fmt.Sprintf("unknown action: %d", actionType), "", mogoly_sync.ErrorCodeInvalid)
+		msg.RequestID = reqID
+		return msg
+	}
+	return handler(ctx, reqID, payload)
+}
+
+// Stop stops the daemon server. The entrypoints stop accepting and are marked not
+// ready, their in-flight requests, upgraded connections included, and the running
+// actions get the grace period to complete, then the remaining connections are closed.
 func (s *Server) Stop() error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	if !s.running {
+		s.mu.Unlock()
 		return fmt.Errorf("server is not running")
 	}
+	s.running = false
+	entryPoints, tcpEntryPoints, udpEntryPoints := s.entryPoints, s.tcpEntryPoints, s.udpEntryPoints
+	grace, handoff, admin, stopWatch := s.gracePeriod, s.upgrading, s.adminServer, s.stopWatch
+	s.mu.Unlock()
 
-	s.log("Stopping daemon...")
+	// The edits of the config file are not applied to a stopping router
+	if stopWatch != nil {
+		stopWatch()
+	}
 
-	s.running = false
-	close(s.shutdownChan)
+	s.log("Stopping daemon, grace period %s...", grace)
 
-	// Stop HTTP server
-	if s.httpServer != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		s.httpServer.Shutdown(ctx)
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	// The tcp connections and udp sessions drain alongside the requests
+	reports := make(chan *router.ShutdownReport, 2)
+	go func() {
+		reports <- router.ShutdownTCPEntryPoints(ctx, tcpEntryPoints)
+	}()
+	go func() {
+		reports <- router.ShutdownUDPEntryPoints(ctx, udpEntryPoints)
+	}()
+	report := router.ShutdownEntryPoints(ctx, entryPoints)
+	for range 2 {
+		r := <-reports
+		report.InFlight += r.InFlight
+		report.Aborted += r.Aborted
+		report.Duration = max(report.Duration, r.Duration)
 	}
 
-	// Stop TLS server
-	if s.mogolyTlsServer != nil {
+	// The actions may be running Docker operations, they get what is left of the grace period
+	if err := waitWithContext(ctx, &s.actions); err != nil {
+		s.log("Warning: actions still running at the end of the grace period")
+	}
+
+	// The admin requests are actions, they are done by now
+	if admin != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		s.mogolyTlsServer.Shutdown(ctx)
+		admin.Shutdown(ctx)
 	}
 
-	// Stop HTTPS server
-	if s.mogolyHttpServer != nil {
+	// Stop HTTP server
+	if s.httpServer != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		s.mogolyHttpServer.Shutdown(ctx)
+		s.httpServer.Shutdown(ctx)
 	}
 
 	// Close listener if not already closed by Shutdown
@@ -195,28 +411,95 @@ func (s *Server) Stop() error {
 		s.listener.Close()
 	}
 
-	// Remove socket file
-	os.RemoveAll(s.socketPath)
+	// The socket and PID files belong to the upgraded daemon after a handoff
+	if !handoff {
+		// Remove socket file
+		os.RemoveAll(s.socketPath)
 
-	// Remove PID file
-	pidPath, _ := GetPIDFilePath()
-	os.RemoveAll(pidPath)
+		// Remove PID file
+		pidPath, _ := GetPIDFilePath()
+		os.RemoveAll(pidPath)
+	}
+
+	if err := writeShutdownReport(report); err != nil {
+		s.log("Warning: failed to write the shutdown report: %v", err)
+	}
 
 	s.log("Daemon stopped")
+	close(s.shutdownChan)
 	return nil
 }
 
-// handleSignals handles OS signals for graceful shutdown
+// waitWithContext waits for wg until ctx is done.
+func waitWithContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// writeShutdownReport saves the outcome of the shutdown for `mogoly daemon stop`.
+func writeShutdownReport(report *router.ShutdownReport) error {
+	path, err := GetShutdownReportPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// ReadShutdownReport returns the report written by the last daemon shutdown.
+func ReadShutdownReport() (*router.ShutdownReport, error) {
+	path, err := GetShutdownReportPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report router.ShutdownReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// handleSignals handles OS signals for graceful shutdown and upgrades
 func (s *Server) handleSignals() {
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	select {
-	case sig := <-sigChan:
-		s.log("Received signal: %v", sig)
-		s.Stop()
-	case <-s.shutdownChan:
-		return
+	signals := []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	if UpgradeSignal != nil {
+		signals = append(signals, UpgradeSignal)
+	}
+	signal.Notify(sigChan, signals...)
+	defer signal.Stop(sigChan)
+
+	for {
+		select {
+		case sig := <-sigChan:
+			s.log("Received signal: %v", sig)
+			if UpgradeSignal != nil && sig == UpgradeSignal {
+				if err := s.Upgrade(); err != nil {
+					s.log("Upgrade failed, the daemon keeps serving: %v", err)
+				}
+				continue
+			}
+			s.Stop()
+			return
+		case <-s.shutdownChan:
+			return
+		}
 	}
 }
 
@@ -0,0 +1,61 @@
+package daemon
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/DoniLite/Mogoly/cli/actions"
+	"github.com/DoniLite/Mogoly/core/router"
+	mogoly_sync "github.com/DoniLite/Mogoly/sync"
+)
+
+// Status is the payload of the daemon status action.
+type Status struct {
+	PID         int               `json:"pid"`
+	Socket      string            `json:"socket"`
+	StartedAt   time.Time         `json:"started_at"`
+	Ready       bool              `json:"ready"`                 // False once the daemon drains its entrypoints
+	EntryPoints map[string]string `json:"entrypoints,omitempty"` // Listen address of the entrypoints by name
+	AdminAddr   string            `json:"admin_addr,omitempty"`
+}
+
+// Ready reports whether the daemon is running and its router is not draining.
+func (s *Server) Ready() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.running && router.Ready()
+}
+
+// Status returns the state of the daemon.
+func (s *Server) Status() *Status {
+	s.mu.RLock()
+	startedAt := s.startedAt
+	s.mu.RUnlock()
+	return &Status{
+		PID:         os.Getpid(),
+		Socket:      s.socketPath,
+		StartedAt:   startedAt,
+		Ready:       s.Ready(),
+		EntryPoints: s.EntryPoints(),
+		AdminAddr:   s.AdminAddr(),
+	}
+}
+
+// registerHandlers registers the actions answered by the daemon itself.
+func (s *Server) registerHandlers() {
+	actions.RegisterHandler(actions.ActionDaemonPing, func(ctx context.Context, reqID string, payload any) *mogoly_sync.Message {
+		msg, err := NewSuccessMessage(reqID, actions.ActionDaemonPing, map[string]any{"pong": true})
+		if err != nil {
+			return NewErrorMessage(reqID, actions.ActionDaemonPing, err.Error())
+		}
+		return msg
+	})
+	actions.RegisterHandler(actions.ActionDaemonStatus, func(ctx context.Context, reqID string, payload any) *mogoly_sync.Message {
+		msg, err := NewSuccessMessage(reqID, actions.ActionDaemonStatus, s.Status())
+		if err != nil {
+			return NewErrorMessage(reqID, actions.ActionDaemonStatus, err.Error())
+		}
+		return msg
+	})
+}
@@ -0,0 +1,102 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/router"
+)
+
+const (
+	// Names of the listeners passed to an upgraded daemon, in file descriptor order from 3
+	envUpgradeListeners = "MOGOLY_UPGRADE_LISTENERS"
+	// File descriptor the upgraded daemon writes to once ready
+	envUpgradeReadyFD = "MOGOLY_UPGRADE_READY_FD"
+
+	// Inherited listener of the daemon socket, the others are entrypoints
+	socketListenerName = "socket"
+	// Inherited listener of the admin API, the colon keeps it apart from the entrypoint names
+	adminListenerName = "mogoly:admin"
+
+	// How long the running daemon waits for the upgraded one to be ready
+	upgradeReadyTimeout = 30 * time.Second
+)
+
+// IsUpgrade reports whether the process was started by the upgrade of a running daemon.
+func IsUpgrade() bool {
+	return os.Getenv(envUpgradeListeners) != ""
+}
+
+// Upgrade replaces the running daemon with the binary found at its executable path
+// without dropping connections. The new process inherits the listening sockets and
+// serves alongside this one, which drains and exits once the new one reports ready.
+// The daemon keeps serving if the new process fails to start.
+func (s *Server) Upgrade() error {
+	s.mu.Lock()
+	if !s.running || s.upgrading {
+		s.mu.Unlock()
+		return fmt.Errorf("daemon is stopping or already upgrading")
+	}
+	s.upgrading = true
+	listener := s.listener
+	entryPoints, err := s.entryPointListeners()
+	args := s.upgradeArgs()
+	s.mu.Unlock()
+
+	var child *os.Process
+	if err == nil {
+		child, err = s.startUpgraded(listener, entryPoints, args)
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.upgrading = false
+		s.mu.Unlock()
+		return err
+	}
+
+	s.log("Upgraded daemon ready (pid %d), draining this one", child.Pid)
+	child.Release()
+	// The socket file now belongs to the new process.
+	if ul, ok := listener.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	return s.Stop()
+}
+
+// entryPointListeners returns the TCP listeners and UDP sockets of the entrypoints by
+// name, along with the admin API listener, s.mu must be held.
+func (s *Server) entryPointListeners() (map[string]any, error) {
+	listeners := make(map[string]any, len(s.entryPoints)+len(s.tcpEntryPoints)+len(s.udpEntryPoints))
+	for name, hs := range s.entryPoints {
+		ln, err := router.EntryPointListener(hs)
+		if err != nil {
+			return nil, err
+		}
+		listeners[name] = ln
+	}
+	for name, ts := range s.tcpEntryPoints {
+		listeners[name] = ts.Listener()
+	}
+	for name, us := range s.udpEntryPoints {
+		listeners[name] = us.PacketConn()
+	}
+	if s.adminListener != nil {
+		listeners[adminListenerName] = s.adminListener
+	}
+	return listeners, nil
+}
+
+// upgradeArgs returns the command line starting a daemon with the same settings,
+// s.mu must be held.
+func (s *Server) upgradeArgs() []string {
+	args := []string{"daemon", "start", "--grace-period", s.gracePeriod.String()}
+	if s.configPath != "" {
+		args = append(args, "--config", s.configPath)
+	}
+	if s.adminAddr != "" {
+		args = append(args, "--admin-addr", s.adminAddr)
+	}
+	return args
+}
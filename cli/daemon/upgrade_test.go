@@ -0,0 +1,85 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/router"
+)
+
+// Started by startUpgraded, the test binary plays the upgraded daemon: it answers
+// one connection on the inherited http listener once ready, the udp socket of the dns
+// entrypoint being inherited as well.
+func TestMain(m *testing.M) {
+	if IsUpgrade() {
+		os.Exit(upgradedDaemon())
+	}
+	os.Exit(m.Run())
+}
+
+func upgradedDaemon() int {
+	listeners, packets, err := inheritedListeners()
+	if err != nil || listeners[socketListenerName] == nil || listeners[router.ENTRYPOINT_HTTP] == nil || packets["dns"] == nil {
+		fmt.Fprintln(os.Stderr, "missing inherited listeners:", listeners, packets, err)
+		return 1
+	}
+	notifyReady()
+	conn, err := listeners[router.ENTRYPOINT_HTTP].Accept()
+	if err != nil {
+		return 1
+	}
+	defer conn.Close()
+	fmt.Fprintln(conn, "upgraded")
+	return 0
+}
+
+func TestUpgradeHandsListenersOver(t *testing.T) {
+	socket, err := net.Listen("unix", filepath.Join(t.TempDir(), "mogoly_test.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+	hs, err := router.ServeHTTP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{gracePeriod: time.Second, entryPoints: map[string]*http.Server{router.ENTRYPOINT_HTTP: hs}}
+	listeners, err := s.entryPointListeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dns, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dns.Close()
+	listeners["dns"] = dns
+	child, err := s.startUpgraded(socket, listeners, s.upgradeArgs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Kill()
+
+	// Once the previous process stops accepting, the upgraded one serves the same port.
+	hs.Close()
+	conn, err := net.DialTimeout("tcp", hs.Addr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "upgraded\n" {
+		t.Fatalf("expected the upgraded daemon to answer, got %q %v", line, err)
+	}
+}
@@ -0,0 +1,178 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// UpgradeSignal makes the daemon upgrade itself, see Server.Upgrade
+var UpgradeSignal os.Signal = syscall.SIGUSR2
+
+// startUpgraded starts a daemon inheriting the listeners and waits for it to be ready.
+// The process is spawned with syscall.ForkExec: os/exec would put the passed
+// descriptors in blocking mode, which the listeners of this process share.
+func (s *Server) startUpgraded(listener net.Listener, entryPoints map[string]any, args []string) (*os.Process, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get executable path: %v", err)
+	}
+
+	// Duplicated descriptors of the listeners, closed once the child has its own copies
+	var names []string
+	var fds []int
+	defer func() {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+	}()
+	addFD := func(name string, conn any) error {
+		fd, err := duplicateFD(conn)
+		if err != nil {
+			return fmt.Errorf("listener %s: %v", name, err)
+		}
+		names = append(names, name)
+		fds = append(fds, fd)
+		return nil
+	}
+	if err := addFD(socketListenerName, listener); err != nil {
+		return nil, err
+	}
+	for _, name := range slices.Sorted(maps.Keys(entryPoints)) {
+		if err := addFD(name, entryPoints[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer ready.Close()
+	readyFD, err := duplicateFD(readyW)
+	// Only the child keeps the write end, its exit ends the read below.
+	readyW.Close()
+	if err != nil {
+		return nil, err
+	}
+	fds = append(fds, readyFD)
+
+	env := append(os.Environ(),
+		envUpgradeListeners+"="+strings.Join(names, ","),
+		envUpgradeReadyFD+"="+strconv.Itoa(3+len(names)),
+	)
+	files := []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()}
+	for _, fd := range fds {
+		files = append(files, uintptr(fd))
+	}
+	pid, err := syscall.ForkExec(executable, append([]string{executable}, args...), &syscall.ProcAttr{Env: env, Files: files})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start %s: %v", executable, err)
+	}
+	child, err := os.FindProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+	s.log("Started upgraded daemon %s (pid %d), waiting for it to be ready", executable, pid)
+	for _, fd := range fds {
+		syscall.Close(fd)
+	}
+	fds = nil
+
+	result := make(chan error, 1)
+	go func() {
+		line, err := bufio.NewReader(ready).ReadString('\n')
+		if err == nil && strings.TrimSpace(line) != "ready" {
+			err = fmt.Errorf("unexpected message %q", line)
+		}
+		result <- err
+	}()
+	select {
+	case err = <-result:
+	case <-time.After(upgradeReadyTimeout):
+		err = fmt.Errorf("not ready after %s", upgradeReadyTimeout)
+	}
+	if err != nil {
+		child.Kill()
+		child.Wait()
+		return nil, fmt.Errorf("upgraded daemon failed to start: %v", err)
+	}
+	return child, nil
+}
+
+// duplicateFD returns a close-on-exec copy of the descriptor of conn.
+func duplicateFD(conn any) (int, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return -1, fmt.Errorf("cannot be passed to another process")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	dup, dupErr := -1, error(nil)
+	err = raw.Control(func(fd uintptr) {
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		if dup, dupErr = syscall.Dup(int(fd)); dupErr == nil {
+			syscall.CloseOnExec(dup)
+		}
+	})
+	if err != nil {
+		return -1, err
+	}
+	return dup, dupErr
+}
+
+// inheritedListeners returns the listeners and the UDP sockets passed by the daemon
+// being upgraded, by name.
+func inheritedListeners() (map[string]net.Listener, map[string]net.PacketConn, error) {
+	value := os.Getenv(envUpgradeListeners)
+	os.Unsetenv(envUpgradeListeners)
+	if value == "" {
+		return nil, nil, nil
+	}
+	listeners := make(map[string]net.Listener)
+	packets := make(map[string]net.PacketConn)
+	for i, name := range strings.Split(value, ",") {
+		f := os.NewFile(uintptr(3+i), name)
+		ln, err := net.FileListener(f)
+		if err != nil {
+			// Not a stream socket, the socket of a udp entrypoint
+			var pc net.PacketConn
+			if pc, err = net.FilePacketConn(f); err == nil {
+				packets[name] = pc
+			}
+		} else {
+			listeners[name] = ln
+		}
+		f.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("inherited listener %s: %v", name, err)
+		}
+	}
+	return listeners, packets, nil
+}
+
+// notifyReady tells the daemon being upgraded that this one serves, it then drains.
+func notifyReady() {
+	value := os.Getenv(envUpgradeReadyFD)
+	os.Unsetenv(envUpgradeReadyFD)
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+	fmt.Fprintln(f, "ready")
+}
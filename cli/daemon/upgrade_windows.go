@@ -0,0 +1,23 @@
+//go:build windows
+// +build windows
+
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// UpgradeSignal is nil on Windows, where the daemon cannot be upgraded in place
+var UpgradeSignal os.Signal
+
+func (s *Server) startUpgraded(listener net.Listener, entryPoints map[string]any, args []string) (*os.Process, error) {
+	return nil, fmt.Errorf("upgrade is not supported on windows")
+}
+
+func inheritedListeners() (map[string]net.Listener, map[string]net.PacketConn, error) {
+	return nil, nil, nil
+}
+
+func notifyReady() {}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/DoniLite/Mogoly/cli/actions"
+	"github.com/DoniLite/Mogoly/core/server"
+	"github.com/DoniLite/Mogoly/sync"
+)
+
+// decodePayload unmarshals the raw JSON payload forwarded by the daemon into target.
+func decodePayload(payload any, target any) error {
+	var raw []byte
+	switch p := payload.(type) {
+	case nil:
+		return nil
+	case json.RawMessage:
+		raw = p
+	case []byte:
+		raw = p
+	default:
+		var err error
+		if raw, err = json.Marshal(p); err != nil {
+			return err
+		}
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, target); err != nil {
+		return actions.Invalid(err)
+	}
+	return nil
+}
+
+func PurgeCache(ctx context.Context, reqID string, payload any) *sync.Message {
+	parsedPayload := actions.CachePurgePayload{}
+	if err := decodePayload(payload, &parsedPayload); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	purged := server.PurgeCache(parsedPayload.Host, parsedPayload.Prefix)
+
+	msg, err := sync.NewMessage(actions.ActionCachePurge, map[string]any{"purged": purged}, map[string]any{
+		"host":   parsedPayload.Host,
+		"prefix": parsedPayload.Prefix,
+	})
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	return msg
+}
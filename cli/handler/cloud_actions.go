@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/DoniLite/Mogoly/cli/actions"
+	"github.com/DoniLite/Mogoly/cli/daemon"
+	"github.com/DoniLite/Mogoly/cloud"
+	"github.com/DoniLite/Mogoly/sync"
+)
+
+// Lines of logs returned when the payload asks for none.
+const defaultLogLines = 100
+
+func CreateInstance(ctx context.Context, reqID string, payload any) *sync.Message {
+	serviceConfig := &actions.ServiceConfigPayload{}
+	if err := decodePayload(payload, serviceConfig); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+	if serviceConfig.Name == "" {
+		return actions.NewErrorMessage(actions.Invalid(errors.New("service name is required")), reqID)
+	}
+
+	router := daemon.GetServerRouter()
+
+	if _, err := router.GetService(serviceConfig.Name); err == nil {
+		return actions.NewErrorMessage(fmt.Errorf("service %s %w", serviceConfig.Name, actions.ErrAlreadyExists), reqID)
+	}
+	router.AddService(serviceConfig)
+	instance, err := router.GetServiceInstance(serviceConfig.Name)
+	if err != nil {
+		return sync.NewErrorMessage(fmt.Sprintf("failed to create the %s service, see the daemon logs", serviceConfig.Name), fmt.Sprintf("request id: %s", reqID))
+	}
+
+	msg, err := sync.NewMessage(actions.ActionCloudCreate, instance, nil)
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	return msg
+}
+
+func ListInstances(ctx context.Context, reqID string, payload any) *sync.Message {
+	instances := daemon.GetServerRouter().ListServiceInstances()
+	slices.SortFunc(instances, func(a, b *cloud.ServiceInstance) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	msg, err := sync.NewMessage(actions.ActionCloudList, instances, map[string]any{
+		"count": len(instances),
+	})
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	return msg
+}
+
+func StartInstance(ctx context.Context, reqID string, payload any) *sync.Message {
+	return instanceAction(reqID, payload, actions.ActionCloudStart, daemon.GetServerRouter().StartInstance)
+}
+
+func StopInstance(ctx context.Context, reqID string, payload any) *sync.Message {
+	return instanceAction(reqID, payload, actions.ActionCloudStop, daemon.GetServerRouter().StopInstance)
+}
+
+func RestartInstance(ctx context.Context, reqID string, payload any) *sync.Message {
+	return instanceAction(reqID, payload, actions.ActionCloudRestart, daemon.GetServerRouter().RestartInstance)
+}
+
+func InspectInstance(ctx context.Context, reqID string, payload any) *sync.Message {
+	return instanceAction(reqID, payload, actions.ActionCloudInspect, daemon.GetServerRouter().InspectInstance)
+}
+
+func DeleteInstance(ctx context.Context, reqID string, payload any) *sync.Message {
+	parsedPayload := actions.CloudInstancePayload{}
+	if err := decodePayload(payload, &parsedPayload); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	router := daemon.GetServerRouter()
+
+	instance, err := findInstance(parsedPayload.ID)
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+	// The instances of the config are removed from it too
+	if service, err := router.GetService(instance.Name); err == nil {
+		router.RemoveService(service)
+		if _, err := router.GetService(instance.Name); err == nil {
+			return sync.NewErrorMessage(fmt.Sprintf("failed to delete the %s service, see the daemon logs", instance.Name), fmt.Sprintf("request id: %s", reqID))
+		}
+	} else if err := router.DeleteInstance(instance.ID); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	msg, err := sync.NewMessage(actions.ActionCloudDelete, instance, nil)
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	return msg
+}
+
+func GetInstanceLogs(ctx context.Context, reqID string, payload any) *sync.Message {
+	parsedPayload := actions.CloudLogsPayload{}
+	if err := decodePayload(payload, &parsedPayload); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+	if parsedPayload.Tail <= 0 {
+		parsedPayload.Tail = defaultLogLines
+	}
+
+	instance, err := findInstance(parsedPayload.ID)
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+	logs, err := daemon.GetServerRouter().GetInstanceLogs(instance.ID, parsedPayload.Tail)
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	msg, err := sync.NewMessage(actions.ActionCloudLogs, map[string]string{"logs": logs}, map[string]any{
+		"id":   instance.ID,
+		"tail": parsedPayload.Tail,
+	})
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	return msg
+}
+
+func SetInstanceDomain(ctx context.Context, reqID string, payload any) *sync.Message {
+	parsedPayload := actions.CloudDomainPayload{}
+	if err := decodePayload(payload, &parsedPayload); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+	if parsedPayload.Domain == nil || parsedPayload.Domain.Domain == "" {
+		return actions.NewErrorMessage(actions.Invalid(errors.New("domain is required")), reqID)
+	}
+
+	instance, err := findInstance(parsedPayload.ID)
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+	router := daemon.GetServerRouter()
+	if err := router.RecreateInstanceWithDomain(instance.ID, parsedPayload.Domain); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+	// The instance is recreated under a new ID
+	if instance, err = router.GetServiceInstance(instance.Name); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	msg, err := sync.NewMessage(actions.ActionCloudDomain, instance, nil)
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	return msg
+}
+
+// instanceAction runs apply on the instance of the payload and answers with the instance.
+func instanceAction(reqID string, payload any, actionType sync.Action_Type, apply func(id string) error) *sync.Message {
+	parsedPayload := actions.CloudInstancePayload{}
+	if err := decodePayload(payload, &parsedPayload); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	instance, err := findInstance(parsedPayload.ID)
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+	if err := apply(instance.ID); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	msg, err := sync.NewMessage(actionType, instance, nil)
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	return msg
+}
+
+// findInstance returns the service instance with the given ID, or name as the CLI passes.
+func findInstance(id string) (*cloud.ServiceInstance, error) {
+	if id == "" {
+		return nil, actions.Invalid(errors.New("instance id is required"))
+	}
+	for _, instance := range daemon.GetServerRouter().ListServiceInstances() {
+		if instance.ID == id || strings.EqualFold(instance.Name, id) {
+			return instance, nil
+		}
+	}
+	return nil, fmt.Errorf("service instance %s %w", id, actions.ErrNotFound)
+}
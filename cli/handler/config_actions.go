@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/DoniLite/Mogoly/cli/actions"
+	"github.com/DoniLite/Mogoly/core/router"
+	"github.com/DoniLite/Mogoly/sync"
+)
+
+func GetConfig(ctx context.Context, reqID string, payload any) *sync.Message {
+	config, err := snapshotConfig()
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	msg, err := sync.NewMessage(actions.ActionConfigGet, config, nil)
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	return msg
+}
+
+// ApplyConfig replaces the running config, the servers and services missing from the
+// payload are removed. The config is validated first, an invalid one changes nothing.
+func ApplyConfig(ctx context.Context, reqID string, payload any) *sync.Message {
+	desired := &actions.ConfigApplyPayload{}
+	if err := decodePayload(payload, desired); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	summary, err := router.ReconcileConfig(desired)
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	msg, err := sync.NewMessage(actions.ActionConfigApply, summary, map[string]any{
+		"summary": summary.String(),
+	})
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	return msg
+}
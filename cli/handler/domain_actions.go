@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/DoniLite/Mogoly/cli/actions"
+	"github.com/DoniLite/Mogoly/cli/daemon"
+	"github.com/DoniLite/Mogoly/core/domain"
+	"github.com/DoniLite/Mogoly/core/router"
+	"github.com/DoniLite/Mogoly/core/server"
+	"github.com/DoniLite/Mogoly/sync"
+)
+
+// domainInfo is a domain of the manager along with the server answering it, if any.
+type domainInfo struct {
+	*domain.Config
+	Server string `json:"server,omitempty"`
+}
+
+func AddDomain(ctx context.Context, reqID string, payload any) *sync.Message {
+	parsedPayload := actions.DomainAddPayload{}
+	if err := decodePayload(payload, &parsedPayload); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+	if parsedPayload.Domain == "" {
+		return actions.NewErrorMessage(actions.Invalid(errors.New("domain is required")), reqID)
+	}
+
+	domains := daemon.GetDomainManager()
+	if domains == nil {
+		return actions.NewErrorMessage(fmt.Errorf("domain manager %w", actions.ErrUnavailable), reqID)
+	}
+	if parsedPayload.LBName != "" {
+		if _, err := daemon.GetServerRouter().GetServer(parsedPayload.LBName); err != nil {
+			return actions.NewErrorMessage(err, reqID)
+		}
+	}
+
+	if err := domains.Add(parsedPayload.Domain, parsedPayload.IsLocal); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+	// The server answers the domain once it is one of its hosts
+	if parsedPayload.LBName != "" {
+		_, err := updateConfig(func(cf *router.Config) error {
+			for _, s := range cf.Servers {
+				if s != nil && strings.EqualFold(s.Name, parsedPayload.LBName) {
+					if !servesDomain(s, parsedPayload.Domain) {
+						s.Hosts = append(s.Hosts, parsedPayload.Domain)
+					}
+					return nil
+				}
+			}
+			return fmt.Errorf("server %s %w", parsedPayload.LBName, actions.ErrNotFound)
+		})
+		if err != nil {
+			domains.Remove(parsedPayload.Domain)
+			return actions.NewErrorMessage(err, reqID)
+		}
+	}
+
+	config, _ := domains.Get(parsedPayload.Domain)
+	msg, err := sync.NewMessage(actions.ActionDomainAdd, domainInfo{Config: config, Server: parsedPayload.LBName}, nil)
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	return msg
+}
+
+func ListDomains(ctx context.Context, reqID string, payload any) *sync.Message {
+	domains := daemon.GetDomainManager()
+	if domains == nil {
+		return actions.NewErrorMessage(fmt.Errorf("domain manager %w", actions.ErrUnavailable), reqID)
+	}
+
+	servers := daemon.GetServerRouter().ListServers()
+	list := make([]domainInfo, 0)
+	for _, config := range domains.List() {
+		info := domainInfo{Config: config}
+		for _, s := range servers {
+			if servesDomain(s, config.Domain) {
+				info.Server = s.Name
+				break
+			}
+		}
+		list = append(list, info)
+	}
+	slices.SortFunc(list, func(a, b domainInfo) int {
+		return strings.Compare(a.Domain, b.Domain)
+	})
+
+	msg, err := sync.NewMessage(actions.ActionDomainList, list, map[string]any{
+		"count": len(list),
+	})
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	return msg
+}
+
+func RemoveDomain(ctx context.Context, reqID string, payload any) *sync.Message {
+	parsedPayload := actions.DomainRemovePayload{}
+	if err := decodePayload(payload, &parsedPayload); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	domains := daemon.GetDomainManager()
+	if domains == nil {
+		return actions.NewErrorMessage(fmt.Errorf("domain manager %w", actions.ErrUnavailable), reqID)
+	}
+
+	// The servers stop answering the domain, it stays routed when it is their name
+	detached := false
+	for _, s := range daemon.GetServerRouter().ListServers() {
+		if slices.ContainsFunc(s.Hosts, func(host string) bool { return strings.EqualFold(host, parsedPayload.Domain) }) {
+			detached = true
+		}
+	}
+	if detached {
+		_, err := updateConfig(func(cf *router.Config) error {
+			for _, s := range cf.Servers {
+				if s != nil {
+					s.Hosts = slices.DeleteFunc(s.Hosts, func(host string) bool { return strings.EqualFold(host, parsedPayload.Domain) })
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return actions.NewErrorMessage(err, reqID)
+		}
+	}
+	if err := domains.Remove(parsedPayload.Domain); err != nil && !detached {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	msg, err := sync.NewMessage(actions.ActionDomainRemove, map[string]any{"domain": parsedPayload.Domain}, nil)
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	return msg
+}
+
+// servesDomain reports whether the domain is the name or one of the hosts of s.
+func servesDomain(s *server.Server, name string) bool {
+	return strings.EqualFold(s.Name, name) || slices.ContainsFunc(s.Hosts, func(host string) bool {
+		return strings.EqualFold(host, name)
+	})
+}
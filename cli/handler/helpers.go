@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/DoniLite/Mogoly/cli/actions"
+	"github.com/DoniLite/Mogoly/cli/daemon"
+	"github.com/DoniLite/Mogoly/core/router"
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+// validateServer checks a server to add against the entrypoints of the running config.
+func validateServer(s *server.Server) error {
+	if s.Name == "" {
+		return actions.Invalid(errors.New("server name is required"))
+	}
+	cf := &router.Config{Servers: []*server.Server{s}}
+	if current := daemon.GetServerRouter().GetConfig(); current != nil {
+		cf.EntryPoints = current.EntryPoints
+	}
+	return cf.Validate()
+}
+
+// updateConfig applies edit to a copy of the running config and reconciles the router
+// with it, the config is persisted and the servers changed are rebuilt.
+func updateConfig(edit func(cf *router.Config) error) (*router.ReconcileSummary, error) {
+	desired, err := snapshotConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := edit(desired); err != nil {
+		return nil, err
+	}
+	return router.ReconcileConfig(desired)
+}
+
+// snapshotConfig returns a copy of the running config, taken under the server locks
+// so that the health checks updating the servers do not race with its encoding.
+func snapshotConfig() (*router.Config, error) {
+	current := daemon.GetServerRouter().GetConfig()
+	if current == nil {
+		return &router.Config{}, nil
+	}
+	raw, err := router.MarshalConfig(current)
+	if err != nil {
+		return nil, err
+	}
+	return router.UnmarshalConfig(raw)
+}
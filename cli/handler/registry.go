@@ -5,7 +5,24 @@ import "github.com/DoniLite/Mogoly/cli/actions"
 func init() {
 	actions.RegisterHandler(actions.ActionServerCreate, BootStrapServer)
 	actions.RegisterHandler(actions.ActionServerList, ListServers)
+	actions.RegisterHandler(actions.ActionServerRemove, RemoveServer)
 	actions.RegisterHandler(actions.ActionServerAddBackend, AddBackend)
 	actions.RegisterHandler(actions.ActionServerRemoveBackend, RemoveBackend)
 	actions.RegisterHandler(actions.ActionServerHealth, CheckServerHealth)
-}
\ No newline at end of file
+	actions.RegisterHandler(actions.ActionCachePurge, PurgeCache)
+	actions.RegisterHandler(actions.ActionServerMaintenance, SetServerMaintenance)
+	actions.RegisterHandler(actions.ActionDomainAdd, AddDomain)
+	actions.RegisterHandler(actions.ActionDomainList, ListDomains)
+	actions.RegisterHandler(actions.ActionDomainRemove, RemoveDomain)
+	actions.RegisterHandler(actions.ActionCloudCreate, CreateInstance)
+	actions.RegisterHandler(actions.ActionCloudList, ListInstances)
+	actions.RegisterHandler(actions.ActionCloudStart, StartInstance)
+	actions.RegisterHandler(actions.ActionCloudStop, StopInstance)
+	actions.RegisterHandler(actions.ActionCloudRestart, RestartInstance)
+	actions.RegisterHandler(actions.ActionCloudDelete, DeleteInstance)
+	actions.RegisterHandler(actions.ActionCloudLogs, GetInstanceLogs)
+	actions.RegisterHandler(actions.ActionCloudInspect, InspectInstance)
+	actions.RegisterHandler(actions.ActionCloudDomain, SetInstanceDomain)
+	actions.RegisterHandler(actions.ActionConfigGet, GetConfig)
+	actions.RegisterHandler(actions.ActionConfigApply, ApplyConfig)
+}
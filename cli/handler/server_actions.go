@@ -11,21 +11,30 @@ import (
 )
 
 func BootStrapServer(ctx context.Context, reqID string, payload any) *sync.Message {
-	serverConfig := payload.(*actions.ServerCreatePayload)
+	serverConfig := &actions.ServerCreatePayload{}
+	if err := decodePayload(payload, serverConfig); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+	if err := validateServer(serverConfig); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
 
 	router := daemon.GetServerRouter()
 
+	if _, err := router.GetServer(serverConfig.Name); err == nil {
+		return actions.NewErrorMessage(fmt.Errorf("server %s %w", serverConfig.Name, actions.ErrAlreadyExists), reqID)
+	}
 	router.AddServer(serverConfig)
 	svr, err := router.GetServer(serverConfig.Name)
 	if err != nil {
-		return sync.NewErrorMessage(err.Error(), fmt.Sprintf("request id: %s", reqID))
+		return actions.NewErrorMessage(err, reqID)
 	}
 
 	msg, err := sync.NewMessage(actions.ActionServerCreate, svr, map[string]any{
 		"URL": svr.URL,
 	})
 	if err != nil {
-		return sync.NewErrorMessage(err.Error(), fmt.Sprintf("request id: %s", reqID))
+		return actions.NewErrorMessage(err, reqID)
 	}
 
 	return msg
@@ -40,20 +49,48 @@ func ListServers(ctx context.Context, reqID string, payload any) *sync.Message {
 		"count": len(servers),
 	})
 	if err != nil {
-		return sync.NewErrorMessage(err.Error(), fmt.Sprintf("request id: %s", reqID))
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	return msg
+}
+
+func RemoveServer(ctx context.Context, reqID string, payload any) *sync.Message {
+	parsedPayload := actions.ServerRemovePayload{}
+	if err := decodePayload(payload, &parsedPayload); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	router := daemon.GetServerRouter()
+
+	svr, err := router.GetServer(parsedPayload.Name)
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	router.RemoveServer(svr)
+
+	msg, err := sync.NewMessage(actions.ActionServerRemove, svr, map[string]any{
+		"URL": svr.URL,
+	})
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
 	}
 
 	return msg
 }
 
 func AddBackend(ctx context.Context, reqID string, payload any) *sync.Message {
-	parsedPayload := payload.(*actions.ServerAddBackendPayload)
+	parsedPayload := actions.ServerAddBackendPayload{}
+	if err := decodePayload(payload, &parsedPayload); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
 
 	router := daemon.GetServerRouter()
 
 	svr, err := router.GetServer(parsedPayload.Name)
 	if err != nil {
-		return sync.NewErrorMessage(err.Error(), fmt.Sprintf("request id: %s", reqID))
+		return actions.NewErrorMessage(err, reqID)
 	}
 
 	svr.AddNewBalancingServer(parsedPayload.Server)
@@ -62,20 +99,23 @@ func AddBackend(ctx context.Context, reqID string, payload any) *sync.Message {
 		"URL": svr.URL,
 	})
 	if err != nil {
-		return sync.NewErrorMessage(err.Error(), fmt.Sprintf("request id: %s", reqID))
+		return actions.NewErrorMessage(err, reqID)
 	}
 
 	return msg
 }
 
 func RemoveBackend(ctx context.Context, reqID string, payload any) *sync.Message {
-	parsedPayload := payload.(*actions.ServerRemoveBackendPayload)
+	parsedPayload := actions.ServerRemoveBackendPayload{}
+	if err := decodePayload(payload, &parsedPayload); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
 
 	router := daemon.GetServerRouter()
 
 	svr, err := router.GetServer(parsedPayload.BaseServerName)
 	if err != nil {
-		return sync.NewErrorMessage(err.Error(), fmt.Sprintf("request id: %s", reqID))
+		return actions.NewErrorMessage(err, reqID)
 	}
 
 	svr.DelBalancingServer(parsedPayload.BackendName)
@@ -84,7 +124,7 @@ func RemoveBackend(ctx context.Context, reqID string, payload any) *sync.Message
 		"URL": svr.URL,
 	})
 	if err != nil {
-		return sync.NewErrorMessage(err.Error(), fmt.Sprintf("request id: %s", reqID))
+		return actions.NewErrorMessage(err, reqID)
 	}
 
 	return msg
@@ -95,24 +135,27 @@ func CheckServerHealth(ctx context.Context, reqID string, payload any) *sync.Mes
 	var selfStatus *server.ServerStatus
 	var allServersStatus *server.HealthCheckStatus
 
-	parsedPayload := payload.(*actions.CheckServerHealthPayload)
+	parsedPayload := actions.CheckServerHealthPayload{}
+	if err := decodePayload(payload, &parsedPayload); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
 
 	router := daemon.GetServerRouter()
 
 	svr, err := router.GetServer(parsedPayload.Name)
 	if err != nil {
-		return sync.NewErrorMessage(err.Error(), fmt.Sprintf("request id: %s", reqID))
+		return actions.NewErrorMessage(err, reqID)
 	}
 
 	selfStatus, err = svr.CheckHealthSelf()
 	if err != nil {
-		return sync.NewErrorMessage(err.Error(), fmt.Sprintf("request id: %s", reqID))
+		return actions.NewErrorMessage(err, reqID)
 	}
 
 	if !parsedPayload.SelfOnly {
 		allServersStatus, err = svr.CheckHealthAll()
 		if err != nil {
-			return sync.NewErrorMessage(err.Error(), fmt.Sprintf("request id: %s", reqID))
+			return actions.NewErrorMessage(err, reqID)
 		}
 	}
 
@@ -126,7 +169,41 @@ func CheckServerHealth(ctx context.Context, reqID string, payload any) *sync.Mes
 		"URL": svr.URL,
 	})
 	if err != nil {
-		return sync.NewErrorMessage(err.Error(), fmt.Sprintf("request id: %s", reqID))
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	return msg
+}
+
+func SetServerMaintenance(ctx context.Context, reqID string, payload any) *sync.Message {
+	parsedPayload := actions.ServerMaintenancePayload{}
+	if err := decodePayload(payload, &parsedPayload); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	router := daemon.GetServerRouter()
+
+	svr, err := router.GetServer(parsedPayload.Name)
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	maintenance := &server.MaintenanceConfig{
+		Enabled:    parsedPayload.Enabled,
+		RetryAfter: parsedPayload.RetryAfter,
+		AllowIPs:   parsedPayload.AllowIPs,
+		Message:    parsedPayload.Message,
+	}
+	svr.SetMaintenance(maintenance)
+	if err := router.PersistConfig(); err != nil {
+		return actions.NewErrorMessage(err, reqID)
+	}
+
+	msg, err := sync.NewMessage(actions.ActionServerMaintenance, maintenance, map[string]any{
+		"URL": svr.URL,
+	})
+	if err != nil {
+		return actions.NewErrorMessage(err, reqID)
 	}
 
 	return msg
@@ -185,7 +185,7 @@ func (m *CloudManager) CreateInstance(config ServiceConfig) (*ServiceInstance, e
 	}
 
 	labels := map[string]string{}
-	publishToHost := true
+	hostIP := "0.0.0.0"
 
 	// find first internal port used by the service (e.g., 5432/tcp)
 	internalPort := ""
@@ -225,7 +225,9 @@ func (m *CloudManager) CreateInstance(config ServiceConfig) (*ServiceInstance, e
 		}
 
 		instance.Domain = config.Domain.Domain
-		publishToHost = false // rely on Traefik by default when domain set
+		// Traefik reaches the container over the network, the Mogoly tcp entrypoints
+		// through the port published on the loopback only.
+		hostIP = "127.0.0.1"
 	}
 
 	containerConfig := &container.Config{
@@ -245,10 +247,8 @@ func (m *CloudManager) CreateInstance(config ServiceConfig) (*ServiceInstance, e
 	for _, port := range exposedPorts {
 		natPort, _ := nat.NewPort("tcp", strings.Split(port, "/")[0])
 		exposedPortsMap[natPort] = struct{}{}
-		if publishToHost {
-			portBindings[natPort] = []nat.PortBinding{
-				{HostIP: "0.0.0.0", HostPort: strconv.Itoa(instance.ExternalPort)},
-			}
+		portBindings[natPort] = []nat.PortBinding{
+			{HostIP: hostIP, HostPort: strconv.Itoa(instance.ExternalPort)},
 		}
 	}
 
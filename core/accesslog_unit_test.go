@@ -0,0 +1,142 @@
+package core
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/events"
+	"github.com/DoniLite/Mogoly/core/router"
+	"github.com/DoniLite/Mogoly/core/server"
+	goevents "github.com/DoniLite/go-events"
+)
+
+func TestAccessLogMiddleware_JSONWithBackend(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, "created")
+	}))
+	defer backend.Close()
+
+	logPath := filepath.Join(t.TempDir(), "access.log")
+	h := router.CreateSingleHttpServer(&server.Server{
+		Name:             "accesslog.test",
+		URL:              backend.URL,
+		BalancingServers: []*server.Server{{Name: "node-1", URL: backend.URL}},
+		Middlewares: []server.Middleware{{Name: string(server.MogolyAccessLog), Config: map[string]any{
+			"format":         "json",
+			"path":           logPath,
+			"exclude_fields": []any{"user_agent", "referer"},
+		}}},
+	})
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "http://accesslog.test/items?page=2", nil))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("unexpected status %d", rr.Code)
+	}
+
+	raw, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry map[string]any
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		t.Fatalf("invalid json line %q: %v", raw, err)
+	}
+	if entry["status"] != float64(201) || entry["bytes"] != float64(7) || entry["uri"] != "/items?page=2" || entry["backend"] != "node-1" {
+		t.Fatalf("unexpected entry %v", entry)
+	}
+	if upstream, _ := entry["upstream_ms"].(float64); upstream < 5 {
+		t.Fatalf("upstream latency not captured: %v", entry["upstream_ms"])
+	}
+	if _, ok := entry["user_agent"]; ok {
+		t.Fatalf("excluded field was logged: %v", entry)
+	}
+}
+
+func TestAccessLogMiddleware_FormatsAndEvents(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello")
+	})
+	dir := t.TempDir()
+	req := func() *http.Request {
+		r := httptest.NewRequest("GET", "http://logs.test/a", nil)
+		r.Header.Set("Referer", "http://ref.test/")
+		r.Header.Set("User-Agent", "curl/8")
+		return r
+	}
+
+	formats := map[string]*regexp.Regexp{
+		"common":   regexp.MustCompile(`^192\.0\.2\.1 - - \[[^\]]+\] "GET /a HTTP/1\.1" 200 5\n$`),
+		"combined": regexp.MustCompile(`^192\.0\.2\.1 - - \[[^\]]+\] "GET /a HTTP/1\.1" 200 5 "http://ref.test/" "curl/8"\n$`),
+	}
+	for format, want := range formats {
+		path := filepath.Join(dir, format+".log")
+		server.AccessLogMiddleware(server.AccessLogMiddlewareConfig{Format: format, Path: path})(next).ServeHTTP(httptest.NewRecorder(), req())
+		raw, _ := os.ReadFile(path)
+		if !want.Match(raw) {
+			t.Fatalf("%s: unexpected line %q", format, raw)
+		}
+	}
+
+	path := filepath.Join(dir, "template.log")
+	server.AccessLogMiddleware(server.AccessLogMiddlewareConfig{Template: "{method} {path} -> {status} {unknown}", Path: path})(next).ServeHTTP(httptest.NewRecorder(), req())
+	if raw, _ := os.ReadFile(path); string(raw) != "GET /a -> 200 {unknown}\n" {
+		t.Fatalf("template: unexpected line %q", raw)
+	}
+
+	received := make(chan *goevents.EventData, 1)
+	events.AddEventHandler(events.AccessLogEvent, func(data *goevents.EventData, args ...string) {
+		received <- data
+	})
+	server.AccessLogMiddleware(server.AccessLogMiddlewareConfig{Output: "events", Format: "json", Fields: []string{"status", "path"}})(next).ServeHTTP(httptest.NewRecorder(), req())
+	select {
+	case data := <-received:
+		fields, ok := data.Payload.(map[string]any)
+		if !ok || len(fields) != 2 || fields["status"] != 200 || !strings.Contains(data.Message, `"path":"/a"`) {
+			t.Fatalf("unexpected event %+v", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no access log event emitted")
+	}
+}
+
+func TestAccessLogMiddleware_EscapesClientValues(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	dir := t.TempDir()
+	req := func() *http.Request {
+		r := httptest.NewRequest("GET", "http://logs.test/a", nil)
+		// A username forging a second line
+		r.SetBasicAuth("x\" 200 5\n10.0.0.1 - admin [-] \"GET /\\", "pw")
+		r.Header.Set("User-Agent", "curl\"\t8")
+		return r
+	}
+
+	wants := map[string]string{
+		"common":   ` - x\" 200 5\x0a10.0.0.1 - admin [-] \"GET /\\ [`,
+		"combined": ` "" "curl\"\x098"`,
+	}
+	for format, want := range wants {
+		path := filepath.Join(dir, format+".log")
+		server.AccessLogMiddleware(server.AccessLogMiddlewareConfig{Format: format, Path: path})(next).ServeHTTP(httptest.NewRecorder(), req())
+		raw, _ := os.ReadFile(path)
+		if strings.Count(string(raw), "\n") != 1 || !strings.Contains(string(raw), want) {
+			t.Fatalf("%s: unexpected line %q", format, raw)
+		}
+	}
+
+	path := filepath.Join(dir, "template.log")
+	server.AccessLogMiddleware(server.AccessLogMiddlewareConfig{Template: "{user} {status}", Path: path})(next).ServeHTTP(httptest.NewRecorder(), req())
+	if raw, _ := os.ReadFile(path); strings.Count(string(raw), "\n") != 1 || !strings.HasPrefix(string(raw), `x\" 200 5\x0a10.0.0.1`) {
+		t.Fatalf("template: unexpected line %q", raw)
+	}
+}
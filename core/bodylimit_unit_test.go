@@ -0,0 +1,53 @@
+package core
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DoniLite/Mogoly/core/router"
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	var received int
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = len(b)
+	}))
+	defer backend.Close()
+
+	h := router.CreateSingleHttpServer(&server.Server{
+		Name:        "bodylimit.test",
+		URL:         backend.URL,
+		Middlewares: []server.Middleware{{Name: string(server.MogolyBodyLimit), Config: map[string]any{"max_bytes": 16}}},
+	})
+
+	cases := []struct {
+		name    string
+		body    string
+		chunked bool
+		code    int
+	}{
+		{"small", "hello", false, http.StatusOK},
+		{"declared too big", strings.Repeat("x", 32), false, http.StatusRequestEntityTooLarge},
+		{"chunked too big", strings.Repeat("x", 32), true, http.StatusRequestEntityTooLarge},
+	}
+	for _, c := range cases {
+		received = 0
+		req := httptest.NewRequest("POST", "http://bodylimit.test/upload", strings.NewReader(c.body))
+		if c.chunked {
+			req.ContentLength = -1
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != c.code {
+			t.Fatalf("%s: got %d want %d", c.name, rr.Code, c.code)
+		}
+		if c.code == http.StatusOK && received != len(c.body) {
+			t.Fatalf("%s: backend received %d bytes", c.name, received)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package core
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+func cacheGet(h http.Handler, url string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", url, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCacheMiddleware_HitMissAndBypass(t *testing.T) {
+	var hits atomic.Int32
+	h := server.CacheMiddleware(server.CacheMiddlewareConfig{MaxSize: 1 << 20})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path != "/private" {
+			w.Header().Set("Cache-Control", "max-age=60")
+		} else {
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		}
+		_, _ = io.WriteString(w, "hello")
+	}))
+
+	rr := cacheGet(h, "http://cache.test/a", nil)
+	if rr.Header().Get(server.CacheStatusHeader) != server.CacheStatusMiss || rr.Body.String() != "hello" {
+		t.Fatalf("first request: got %q %q", rr.Header().Get(server.CacheStatusHeader), rr.Body.String())
+	}
+	rr = cacheGet(h, "http://cache.test/a", nil)
+	if rr.Header().Get(server.CacheStatusHeader) != server.CacheStatusHit || rr.Body.String() != "hello" || hits.Load() != 1 {
+		t.Fatalf("second request: got %q %q after %d upstream calls", rr.Header().Get(server.CacheStatusHeader), rr.Body.String(), hits.Load())
+	}
+
+	cacheGet(h, "http://cache.test/a", map[string]string{"Cache-Control": "no-store"})
+	cacheGet(h, "http://cache.test/private", nil)
+	cacheGet(h, "http://cache.test/private", nil)
+	if hits.Load() != 4 {
+		t.Fatalf("no-store and private responses must reach the backend, got %d upstream calls", hits.Load())
+	}
+
+	if n := server.PurgeCache("cache.test", "/a"); n != 1 {
+		t.Fatalf("PurgeCache: purged %d URLs, want 1", n)
+	}
+	rr = cacheGet(h, "http://cache.test/a", nil)
+	if rr.Header().Get(server.CacheStatusHeader) != server.CacheStatusMiss {
+		t.Fatalf("purged entry must miss, got %q", rr.Header().Get(server.CacheStatusHeader))
+	}
+}
+
+func TestCacheMiddleware_ETagRevalidation(t *testing.T) {
+	var full, notModified atomic.Int32
+	h := server.CacheMiddleware(server.CacheMiddlewareConfig{MaxSize: 2 << 20})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "no-cache")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		_, _ = io.WriteString(w, "body")
+	}))
+
+	cacheGet(h, "http://etag.test/", nil)
+	rr := cacheGet(h, "http://etag.test/", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "body" || rr.Header().Get(server.CacheStatusHeader) != server.CacheStatusRevalidated {
+		t.Fatalf("revalidated response: got %d %q %q", rr.Code, rr.Body.String(), rr.Header().Get(server.CacheStatusHeader))
+	}
+	if full.Load() != 1 || notModified.Load() != 1 {
+		t.Fatalf("want 1 full and 1 conditional upstream request, got %d and %d", full.Load(), notModified.Load())
+	}
+
+	rr = cacheGet(h, "http://etag.test/", map[string]string{"If-None-Match": `"v1"`})
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Fatalf("client validator: got %d with %d bytes", rr.Code, rr.Body.Len())
+	}
+}
+
+func TestCacheMiddleware_Vary(t *testing.T) {
+	var hits atomic.Int32
+	h := server.CacheMiddleware(server.CacheMiddlewareConfig{MaxSize: 3 << 20})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = io.WriteString(w, r.Header.Get("Accept-Language"))
+	}))
+
+	for _, lang := range []string{"fr", "en", "fr", "en"} {
+		rr := cacheGet(h, "http://vary.test/", map[string]string{"Accept-Language": lang})
+		if rr.Body.String() != lang {
+			t.Fatalf("Accept-Language %s: got body %q", lang, rr.Body.String())
+		}
+	}
+	if hits.Load() != 2 {
+		t.Fatalf("want one upstream request per variant, got %d", hits.Load())
+	}
+}
+
+func TestCacheMiddleware_CollapsesConcurrentMisses(t *testing.T) {
+	var hits atomic.Int32
+	release := make(chan struct{})
+	h := server.CacheMiddleware(server.CacheMiddlewareConfig{MaxSize: 4 << 20})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "shared")
+	}))
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rr := cacheGet(h, "http://collapse.test/", nil); rr.Body.String() != "shared" {
+				t.Errorf("unexpected body %q", rr.Body.String())
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if hits.Load() != 1 {
+		t.Fatalf("concurrent misses must be collapsed, got %d upstream requests", hits.Load())
+	}
+}
+
+func TestCacheMiddleware_StaleRevalidationTruncated(t *testing.T) {
+	var calls atomic.Int32
+	broken := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Already stale when stored, the next request revalidates it in the background
+		w.Header().Set("Cache-Control", "max-age=5, stale-while-revalidate=60")
+		w.Header().Set("Age", "10")
+		if calls.Add(1) == 1 {
+			_, _ = io.WriteString(w, "complete")
+			return
+		}
+		defer close(broken)
+		w.Header().Set("Content-Length", "100")
+		_, _ = io.WriteString(w, "partial")
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+	front := httptest.NewServer(server.CacheMiddleware(server.CacheMiddlewareConfig{MaxSize: 1 << 20})(httputil.NewSingleHostReverseProxy(target)))
+	defer front.Close()
+
+	get := func() (string, string) {
+		resp, err := http.Get(front.URL + "/asset")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), resp.Header.Get(server.CacheStatusHeader)
+	}
+	get()
+	if body, status := get(); body != "complete" || status != server.CacheStatusStale {
+		t.Fatalf("stale entry: got %q %q", body, status)
+	}
+	select {
+	case <-broken:
+	case <-time.After(2 * time.Second):
+		t.Fatal("background revalidation not sent")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if body, _ := get(); body != "complete" {
+		t.Fatalf("truncated revalidation stored, got %q", body)
+	}
+}
@@ -54,6 +54,9 @@ func ParseConfig(content []byte, format string) (*router.Config, error) {
 	default:
 		return nil, fmt.Errorf("unknown format: %s", format)
 	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 	return &config, nil
 }
 
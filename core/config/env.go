@@ -20,3 +20,7 @@ func SetEnv(key, value string) error {
 	}
 	return nil
 }
+
+func UnsetEnv(key string) error {
+	return os.Unsetenv(fmt.Sprintf("%s%s", ENV_PREFIX, key))
+}
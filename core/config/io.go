@@ -19,6 +19,11 @@ func buildPathFromHome(path ...string) (string, error) {
 	return filepath.Join(parts...), nil
 }
 
+// ConfigFilePath returns the absolute path of a file of the config dir.
+func ConfigFilePath(configPath string) (string, error) {
+	return buildPathFromHome(configPath)
+}
+
 func CreateConfigFile(configPath string) (string, error) {
 	path, err := buildPathFromHome(configPath)
 	if err != nil {
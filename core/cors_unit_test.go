@@ -0,0 +1,115 @@
+package core
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	var forwarded int
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded++
+		w.Header().Set("Access-Control-Allow-Origin", "https://backend.example")
+		w.WriteHeader(http.StatusOK)
+	})
+	h := server.CORSMiddleware(server.CORSMiddlewareConfig{
+		AllowedOrigins:   []string{"https://app.example", "http://*.test"},
+		AllowedMethods:   []string{"get", "post", "delete"},
+		AllowedHeaders:   []string{"Content-Type", "X-Token"},
+		ExposedHeaders:   []string{"X-Total-Count"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	})(next)
+
+	// Preflight is answered locally.
+	req := httptest.NewRequest("OPTIONS", "http://api.test/items", nil)
+	req.Header.Set("Origin", "http://front.test")
+	req.Header.Set("Access-Control-Request-Method", "DELETE")
+	req.Header.Set("Access-Control-Request-Headers", "x-token, x-unknown")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if forwarded != 0 || rr.Code != http.StatusNoContent {
+		t.Fatalf("preflight must not be forwarded: got %d after %d calls", rr.Code, forwarded)
+	}
+	want := map[string]string{
+		"Access-Control-Allow-Origin":      "http://front.test",
+		"Access-Control-Allow-Methods":     "GET, POST, DELETE",
+		"Access-Control-Allow-Headers":     "x-token",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Max-Age":           "600",
+	}
+	for k, v := range want {
+		if got := rr.Header().Get(k); got != v {
+			t.Fatalf("preflight %s: got %q want %q", k, got, v)
+		}
+	}
+
+	// Actual requests reach the backend with the CORS headers replacing its own.
+	req = httptest.NewRequest("GET", "http://api.test/items", nil)
+	req.Header.Set("Origin", "https://app.example")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if forwarded != 1 || len(rr.Header().Values("Access-Control-Allow-Origin")) != 1 ||
+		rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example" ||
+		rr.Header().Get("Access-Control-Expose-Headers") != "X-Total-Count" {
+		t.Fatalf("actual request: unexpected headers %v", rr.Header())
+	}
+
+	// Disallowed origins get no CORS headers.
+	req = httptest.NewRequest("OPTIONS", "http://api.test/items", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Header().Get("Access-Control-Allow-Origin") != "" || forwarded != 1 {
+		t.Fatalf("disallowed origin: unexpected headers %v", rr.Header())
+	}
+}
+
+func TestCORSMiddleware_AnyOrigin(t *testing.T) {
+	h := server.CORSMiddleware(server.CORSMiddlewareConfig{AllowedOrigins: []string{"*"}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest("GET", "http://api.test/", nil)
+	req.Header.Set("Origin", "https://anything.example")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("wildcard origin: got %q", rr.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+// A websocket upgrade through CORS leaves the hijacked connection alone.
+func TestCORSMiddleware_Hijack(t *testing.T) {
+	h := server.CORSMiddleware(server.CORSMiddlewareConfig{AllowedOrigins: []string{"*"}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+	}))
+	ts := httptest.NewUnstartedServer(h)
+	serverLogs := &lockedBuffer{}
+	ts.Config.ErrorLog = log.New(serverLogs, "", 0)
+	ts.Start()
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Origin", "https://app.example")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	ts.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || serverLogs.String() != "" {
+		t.Fatalf("got %d, server logged %q", resp.StatusCode, serverLogs.String())
+	}
+}
@@ -58,7 +58,7 @@ Create a configuration file (config.yaml):
 	      - name: backend-2
 	        url: http://localhost:8082
 	    middlewares:
-	      - name: ratelimit
+	      - name: mogoly:ratelimiter
 	        config:
 	          request_per_minute: 100
 	          limit_window: 60s
@@ -169,6 +169,215 @@ Limits the number of requests per IP address:
 
 	middleware := core.RateLimiterMiddleware(config)
 
+### Forward Auth
+
+Delegates the authentication decision to an external service (mogoly:forwardauth).
+A 2xx answer lets the request through, anything else is returned to the client.
+The allow decisions are cached for cache_ttl per token, method, host and URI, or
+per token with cache_key: token, denials are never cached:
+
+	middlewares:
+	  - name: mogoly:forwardauth
+	    config:
+	      address: http://localhost:9091/verify
+	      timeout: 2s
+	      cache_ttl: 30s
+	      cache_size: 4096 # 1024 by default
+	      auth_response_headers: [X-User, X-Groups]
+
+### Headers
+
+Adds, sets or removes request and response headers (mogoly:headers). Values accept
+the {client_ip}, {request_id}, {host}, {method}, {path} and {scheme} placeholders,
+and the security preset adds HSTS, X-Content-Type-Options, X-Frame-Options, CSP
+and Referrer-Policy when the backend did not set them:
+
+	middlewares:
+	  - name: mogoly:headers
+	    config:
+	      preset: security
+	      request:
+	        set: {X-Real-IP: "{client_ip}"}
+	        remove: [X-Debug]
+	      response:
+	        add: {X-Served-By: "mogoly"}
+
+### Path Rewriting
+
+mogoly:stripprefix, mogoly:addprefix and mogoly:rewrite change the request path
+before the backend URL is built. Stripped prefixes are reported to the backend
+through X-Forwarded-Prefix so applications can be mounted under a sub-path:
+
+	middlewares:
+	  - name: mogoly:stripprefix
+	    config:
+	      prefixes: [/grafana]
+	  - name: mogoly:rewrite
+	    config:
+	      regex: ^/blog/(\d+)/(.*)$
+	      replacement: /posts/$1/$2
+
+### Redirects
+
+mogoly:redirect answers matching requests with a redirect. Rules are matched
+against the full request URL, and the host can be canonicalised to its www or
+apex form. Non GET/HEAD requests get 307/308 so the method is kept:
+
+	middlewares:
+	  - name: mogoly:redirect
+	    config:
+	      canonical: apex
+	      canonical_permanent: true
+	      rules:
+	        - regex: ^https?://([^/]+)/docs/(.*)$
+	          replacement: https://docs.$1/$2
+	          permanent: true
+
+The HTTP -> HTTPS redirect of servers with ForceTLS can be tuned per server:
+
+	tls_redirect:
+	  status_code: 308
+	  port: 8443
+
+### Compression
+
+mogoly:compress encodes responses with zstd, brotli or gzip depending on the
+client Accept-Encoding. Bodies are streamed through the encoder, responses that
+are small, already encoded or of an excluded type are sent untouched:
+
+	middlewares:
+	  - name: mogoly:compress
+	    config:
+	      encodings: [br, gzip]
+	      min_size: 1024
+	      exclude_types: [text/event-stream]
+
+Run `go test ./server -bench Proxy_` to compare against the uncompressed path.
+
+### Cache
+
+mogoly:cache stores GET/HEAD responses following their Cache-Control, Expires
+and Vary headers. Stale entries carrying an ETag or Last-Modified are
+revalidated with a conditional request, stale-while-revalidate is served from
+the cache while the entry is refreshed in the background, and concurrent misses
+on the same URL share a single upstream request. The X-Cache response header
+reports HIT, MISS, STALE, REVALIDATED or BYPASS:
+
+	middlewares:
+	  - name: mogoly:cache
+	    config:
+	      max_size: 67108864      # memory budget in bytes
+	      max_entry_size: 8388608 # bigger responses are never stored
+	      disk: true              # second tier under ~/.mogoly/cache
+	      max_disk_size: 1073741824
+
+Entries are purged with server.PurgeCache(host, prefix) or from the CLI with
+`mogoly cache purge --host example.com --prefix /assets`.
+
+### CORS
+
+mogoly:cors answers preflight OPTIONS requests itself and sets the
+Access-Control-* headers on the responses of allowed origins. Origins are exact
+values or globs, `*` allows any origin:
+
+	middlewares:
+	  - name: mogoly:cors
+	    config:
+	      allowed_origins: ["https://app.example.com", "http://*.test"]
+	      allowed_methods: [GET, POST, DELETE] # GET, HEAD, POST, PUT, PATCH and DELETE by default
+	      allowed_headers: [Content-Type, Authorization]
+	      exposed_headers: [X-Total-Count]
+	      allow_credentials: true
+	      max_age: 600
+
+### Body Limit
+
+mogoly:bodylimit answers 413 Request Entity Too Large when the request body is
+bigger than max_bytes (10 MiB by default). Declared lengths are rejected before
+reaching the backend, chunked bodies are cut once the limit is crossed:
+
+	middlewares:
+	  - name: mogoly:bodylimit
+	    config:
+	      max_bytes: 1048576
+
+### Request ID
+
+mogoly:requestid keeps the incoming X-Request-ID (or the configured header) or
+generates one. The ID is forwarded to the backend, echoed in the response,
+stored in the request context (server.RequestIDFromContext) and appended to the
+proxy log lines of the request:
+
+	middlewares:
+	  - name: mogoly:requestid
+	    config:
+	      header: X-Trace-ID
+
+### Access Log
+
+mogoly:accesslog writes one line per request in the Common or Combined Log
+Format, as JSON, or following a custom template using the json field names as
+{placeholders}. Entries carry the status, the response size, the latency, the
+backend picked by the load balancer and its time to first byte (upstream_ms).
+Lines go to a file (~/.mogoly/access.log by default), to stdout or to the
+events.AccessLogEvent of the event bus:
+
+	middlewares:
+	  - name: mogoly:accesslog
+	    config:
+	      format: json
+	      path: /var/log/mogoly/access.log
+	      sample_rate: 0.1        # 5xx answers are always logged
+	      exclude_fields: [user_agent, referer]
+
+### WAF
+
+mogoly:waf matches the method, path, query, headers or the body prefix of the
+requests against regex or literal rules. A block rule rejects the request with
+403, a score rule adds to the request score which rejects it once the threshold
+(5 by default) is reached, and a log rule only logs the match. The starter
+ruleset bundles score rules for SQL injection, XSS, path traversal, command
+injection and JNDI lookups. Rejected requests are emitted as events.WAFBlockEvent,
+detect_only logs and emits them but lets them through:
+
+	middlewares:
+	  - name: mogoly:waf
+	    config:
+	      ruleset: starter
+	      detect_only: true
+	      rules:
+	        - id: scanners
+	          targets: [header:User-Agent]
+	          regex: (?i)sqlmap|nikto
+	        - id: admin
+	          targets: [path]
+	          literal: /admin
+	          action: score
+	          score: 3
+
+## Error Pages and Maintenance
+
+Each server can replace the plain text errors with templates for 404, 502, 503
+and 504. The JSON template is sent to clients preferring application/json, the
+HTML one otherwise. Placeholders ({status}, {status_text}, {message}, {host},
+{path}, {request_id}, {retry_after}) are escaped for the output format:
+
+	error_pages:
+	  intercept_backend: true   # also replace the backend 404/502/... answers
+	  pages:
+	    502:
+	      html_file: /etc/mogoly/502.html
+	      json: '{"error": "{message}", "request_id": "{request_id}"}'
+
+The maintenance mode answers 503 with Retry-After to every client but the
+allow-list. It is toggled at runtime with Server.SetMaintenance or from the CLI
+with `mogoly lb maintenance api-gateway on --retry-after 600 --allow-ip 10.0.0.0/8`:
+
+	maintenance:
+	  enabled: true
+	  retry_after: 600
+	  allow_ips: [10.0.0.0/8, 192.0.2.10]
+
 ## Chaining Middlewares
 
 	handler := core.ChainMiddleware(
@@ -180,32 +389,47 @@ Limits the number of requests per IP address:
 
 ## Creating Custom Middlewares
 
+Middlewares are registered by name with a typed config. The raw config of the
+YAML/JSON files is decoded into that type, unknown fields are rejected, and the
+Validate method is called when present. Invalid configs and unknown names are
+reported when the config is loaded:
+
 	type CustomConfig struct {
-	    Value string
+	    Value string `json:"value" yaml:"value"`
 	}
 
-	func CustomMiddleware(config any) func(next http.Handler) http.Handler {
-	    cfg := config.(CustomConfig)
-
-	    return func(next http.Handler) http.Handler {
-	        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-	            // Pre-processing
-	            log.Printf("Custom: %s", cfg.Value)
-
-	            // Call next handler
-	            next.ServeHTTP(w, r)
-
-	            // Post-processing
-	        })
+	func (c CustomConfig) Validate() error {
+	    if c.Value == "" {
+	        return errors.New("value is required")
 	    }
+	    return nil
 	}
 
-	// Register middleware
-	core.MiddlewaresList["custom"] = core.MiddlewareSets{
-	    Fn:   CustomMiddleware,
-	    Conf: CustomConfig{Value: "example"},
+	func init() {
+	    err := server.RegisterMiddleware("acme:custom", func(cfg CustomConfig) func(http.Handler) http.Handler {
+	        return func(next http.Handler) http.Handler {
+	            return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	                w.Header().Set("X-Custom", cfg.Value)
+	                next.ServeHTTP(w, r)
+	            })
+	        }
+	    })
+	    if err != nil {
+	        log.Fatal(err)
+	    }
 	}
 
+The middleware is then used like the built-in ones:
+
+	middlewares:
+	  - name: acme:custom
+	    config:
+	      value: example
+
+server.RegisteredMiddlewares lists the available names. The MiddlewaresList map
+is kept for compatibility but new middlewares should not be added to it directly,
+server.LookupMiddlewareFunc reads it under the registry lock.
+
 # Configuration Management
 
 ## Configuration File Formats
@@ -227,7 +451,7 @@ Supports both YAML and JSON formats:
 	      - name: backend-2
 	        url: http://localhost:8082
 	    middlewares:
-	      - name: ratelimit
+	      - name: mogoly:ratelimiter
 	        config:
 	          request_per_minute: 100
 	          limit_window: 60s
@@ -253,7 +477,7 @@ Supports both YAML and JSON formats:
 	      ],
 	      "middlewares": [
 	        {
-	          "name": "ratelimit",
+	          "name": "mogoly:ratelimiter",
 	          "config": {
 	            "request_per_minute": 100
 	          }
@@ -285,6 +509,26 @@ Supports both YAML and JSON formats:
 	    log.Fatal(err)
 	}
 
+## Reloading Configuration
+
+router.MergeRouterConfigs only adds the servers and services it does not know
+yet. To apply an edited config, router.ReconcileConfig diffs it against the live
+router: new servers and services are added, the ones missing from the config are
+removed, and the changed ones are rebuilt while the unchanged ones keep their
+handlers and health state. An invalid config is rejected without touching the
+running one:
+
+	summary, err := router.ReconcileConfig(config)
+	if err != nil {
+	    log.Printf("reload rejected: %v", err)
+	    return
+	}
+	log.Printf("reloaded: %s", summary)
+
+core.WatchRouterConfig does the same for every new version of a file and emits
+events.ConfigFileUpdateEvent with the path, success, and the error or the change
+summary. This is how the daemon applies the edits of ~/.mogoly/router.yaml.
+
 # TLS/SSL Support
 
 ## HTTPS Server with Certificate Manager
@@ -316,6 +560,172 @@ Supports both YAML and JSON formats:
 	    select {}
 	}
 
+## Entrypoints
+
+The listeners are declared in the entrypoints section of the router config.
+The http (:80) and https (:443) entrypoints always exist unless disabled, other
+ones need an address and a protocol. Their middlewares run before those of the
+servers:
+
+	entrypoints:
+	  http:
+	    address: ":8000"
+	  internal:
+	    address: "127.0.0.1:8080"
+	    protocol: http
+	    middlewares:
+	      - name: mogoly:headers
+	        config:
+	          request:
+	            set:
+	              X-Internal: "1"
+	  legacy:
+	    address: ":8443"
+	    protocol: https
+	    disabled: true
+
+	server:
+	  - name: admin.example.com
+	    url: http://localhost:9000
+	    entrypoints: [internal]
+
+A server listing entrypoints is only reachable from them, the others are served
+by all of them. router.ServeEntryPoints binds every enabled entrypoint and
+returns the bind errors, a port already in use makes the daemon start fail.
+The entrypoint settings apply once the listeners are restarted.
+
+## Entrypoint Limits
+
+The entrypoints default to a 10s header timeout, a 120s idle timeout and 1 MiB
+of headers. They are tuned from the router config:
+
+	entrypoints:
+	  https:
+	    read_header_timeout: 5s
+	    read_timeout: 1m
+	    write_timeout: 1m
+	    idle_timeout: 90s
+	    max_header_bytes: 65536
+
+## TCP Entrypoints
+
+Entrypoints with the tcp protocol route TLS connections by the SNI of their
+ClientHello. They serve the servers having a tcp config, which the http
+entrypoints never serve: the connections for their name or hosts are passed
+through to their backends, or TLS is terminated with the certificate manager
+and the plain stream forwarded. The backends are balanced and health checked
+(by a TCP dial) like the http ones:
+
+	entrypoints:
+	  databases:
+	    address: ":5443"
+	    protocol: tcp
+	    read_header_timeout: 5s # Deadline of the ClientHello
+
+	server:
+	  - name: db.example.com
+	    entrypoints: [databases]
+	    tcp:
+	      tls: terminate       # passthrough by default
+	      connect_timeout: 5s  # 10s by default
+	      idle_timeout: 30m    # 1h by default
+	      allowed_cidrs: ["10.0.0.0/8"]
+	    balance:
+	      - name: db-1
+	        host: 10.0.0.11
+	        port: 5432
+	      - name: db-2
+	        host: 10.0.0.12
+	        port: 5432
+
+The cloud services having a domain are routed the same way, TLS being
+terminated for the domain and the stream sent to the port of the instance.
+router.ServeTCPEntryPoints starts the tcp entrypoints and
+router.ShutdownTCPEntryPoints drains them. Clients must start with a TLS
+handshake, PostgreSQL ones with sslnegotiation=direct.
+
+## UDP Entrypoints
+
+Entrypoints with the udp protocol forward datagrams to the server having a udp
+config attached to them, one server per udp entrypoint. Each client address
+gets a session bound to the backend picked for it, the replies of the backend
+are sent back to the client and the session ends once no datagram crossed it
+for the session timeout. Each session holds a socket, their number is capped:
+
+	entrypoints:
+	  dns:
+	    address: "127.0.0.1:53"
+	    protocol: udp
+
+	server:
+	  - name: dns.local
+	    entrypoints: [dns]
+	    udp:
+	      session_timeout: 10s # 30s by default
+	      max_sessions: 1024 # Datagrams of new clients are dropped once reached, 4096 by default
+	    balance:
+	      - name: ns1
+	        host: 127.0.0.1
+	        port: 5353
+	      - name: ns2
+	        host: 127.0.0.1
+	        port: 5354
+
+The health check of the udp backends sends an empty datagram, a backend is
+unhealthy once its host reports the port unreachable. router.ServeUDPEntryPoints
+starts the udp entrypoints and router.ShutdownUDPEntryPoints drains their
+sessions.
+
+## PROXY Protocol
+
+Behind a TCP load balancer, Mogoly sees the addresses of the load balancer
+instead of those of the clients. The http, https and tcp entrypoints with a
+proxy_protocol config read the PROXY protocol v1 or v2 header starting the
+connections of the trusted sources, the client address it carries is then the
+one seen by the middlewares, the allow-lists and the X-Forwarded-For header.
+The connections of the other sources are served as they come, their headers are
+never honored:
+
+	entrypoints:
+	  https:
+	    proxy_protocol:
+	      trusted_cidrs: ["10.0.0.0/8"] # The load balancers
+
+The servers whose backends expect the header themselves send it with
+proxy_protocol set to the version they speak. The HTTP backends then get a new
+connection for each request, the header being that of a single client:
+
+	server:
+	  - name: db.example.com
+	    tcp: {}
+	    proxy_protocol: 2
+	    host: 127.0.0.1
+	    port: 5432
+
+## Graceful Shutdown
+
+router.ShutdownEntryPoints stops the entrypoint listeners and marks the router
+not ready (router.Ready). The in-flight requests, the connections upgraded to
+websockets included, get until the context is done to complete, the remaining
+ones are closed:
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	report := router.ShutdownEntryPoints(ctx, listeners)
+	fmt.Printf("%d in flight, %d aborted\n", report.InFlight, report.Aborted)
+
+An http entrypoint with a health_path answers it on every host, ahead of its
+middlewares, with 200 while the router is ready and 503 once it drains, so that
+the load balancers in front stop sending traffic before the listeners close:
+
+	entrypoints:
+	  http:
+	    health_path: /healthz
+
+For binary upgrades, router.EntryPointListener returns the TCP listener of an
+entrypoint to pass to the new process, which hands it to router.InheritListeners
+before serving its entrypoints: the connections queued on it are not lost.
+
 The certificate manager automatically:
   - Obtains certificates from Let's Encrypt
   - Renews certificates before expiration
@@ -344,13 +754,52 @@ The router maps hostnames to server handlers:
 	// Remove server
 	router.RemoveServer(newServer)
 
+Requests never take the router lock: every change builds a new immutable
+routing table which is published atomically. In-flight requests finish with the
+table they started with and the next ones use the new one.
+
 ## Host-Based Routing
 
-Requests are routed based on the Host header:
+Requests are routed based on the Host header, lowercased and without its port.
+A server answers for its name and for the extra hosts listed in hosts, where a
+leading `*.` matches any subdomain (but not the bare domain):
 
-	example.com     -> Server "example.com"
-	api.example.com -> Server "api.example.com"
-	*.example.com   -> Server "wildcard.example.com" (if configured)
+	server:
+	  - name: example.com
+	    hosts: [www.example.com, "*.example.com"]
+	    url: http://localhost:8080
+	  - name: api.example.com
+	    url: http://localhost:8081
+
+Exact hosts always win over wildcards, and the longest wildcard wins between
+wildcards, so api.example.com reaches the second server and anything else under
+example.com the first one. A host claimed by two servers goes to the first one
+in name order and the conflict is logged.
+
+## Routing Rules
+
+Routes split the requests of one host between several servers. A route matches
+on a path prefix (whole segments, /api does not match /apiary), a path regexp,
+methods, headers and query params, header and query values being regexps where
+an empty value only requires the key. Routes are tried by decreasing priority,
+in config order for equal priorities, and send the request to the handler of
+the named server with their own middlewares in front. Requests matching no route
+reach the backends of the host server:
+
+	server:
+	  - name: example.com
+	    url: http://localhost:8080        # web
+	    routes:
+	      - name: api
+	        path_prefix: /api
+	        server: api-lb
+	        middlewares:
+	          - name: mogoly:ratelimiter
+	      - name: api-canary
+	        path_prefix: /api
+	        headers: {X-Canary: "^1$"}
+	        priority: 10
+	        server: api-canary-lb
 
 # Best Practices
 
@@ -384,7 +833,9 @@ Requests are routed based on the Host header:
 	type Server struct {
 	    ID               string       // Server ID
 	    Name             string       // Server name (required)
-	    Protocol         string       // "http" or "https"
+	    Hosts            []string     // Extra hosts, `*.example.com` wildcards allowed
+	    Routes           []*Route     // Path/header rules sending requests to other servers
+	    Protocol         string       // "http", "https", "tcp" or "udp"
 	    Host             string       // Hostname or IP
 	    Port             int          // Port number
 	    URL              string       // Full URL (alternative to host+port)
@@ -392,6 +843,9 @@ Requests are routed based on the Host header:
 	    BalancingServers []*Server    // Backend pool for load balancing
 	    Middlewares      []Middleware // Applied middlewares
 	    LastHealthCheck  *time.Time   // Last health check timestamp
+	    TCP              *TCPConfig   // Routes the server on the tcp entrypoints
+	    UDP              *UDPConfig   // Makes the server the target of the udp entrypoints
+	    ProxyProtocol    int          // PROXY protocol version sent to the backends, none when 0
 	}
 
 ## Config
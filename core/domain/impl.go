@@ -21,6 +21,12 @@ import (
 	"github.com/caddyserver/certmagic"
 )
 
+// ErrNotFound and ErrAlreadyExists wrap the errors about unknown and duplicated domains.
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+)
+
 func getEndPointFromEnvConfig(envKey string) string {
 	env := config.GetEnv(envKey, "production")
 
@@ -60,7 +66,7 @@ func (m *Manager) Add(domain string, isLocal bool) error {
 
 	// Check if domain already exists
 	if _, exists := m.domains[domain]; exists {
-		return fmt.Errorf("domain %s already exists", domain)
+		return fmt.Errorf("domain %s %w", domain, ErrAlreadyExists)
 	}
 
 	config := &Config{
@@ -107,7 +113,7 @@ func (m *Manager) Remove(domain string) error {
 
 	config, exists := m.domains[domain]
 	if !exists {
-		return fmt.Errorf("domain %s not found", domain)
+		return fmt.Errorf("domain %s %w", domain, ErrNotFound)
 	}
 
 	if config.IsLocal {
@@ -162,7 +168,7 @@ func (m *Manager) LoadCertificate(domain string) (*tls.Certificate, error) {
 	m.mu.RUnlock()
 
 	if !exists {
-		return nil, fmt.Errorf("domain %s not found", domain)
+		return nil, fmt.Errorf("domain %s %w", domain, ErrNotFound)
 	}
 
 	if config.CertPath == "" || config.KeyPath == "" {
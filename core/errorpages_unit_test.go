@@ -0,0 +1,92 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DoniLite/Mogoly/core/router"
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+var testErrorPages = &server.ErrorPagesConfig{
+	InterceptBackend: true,
+	Pages: map[int]*server.ErrorPage{
+		http.StatusNotFound:           {HTML: "<h1>{status} {path}</h1>"},
+		http.StatusBadGateway:         {HTML: "<h1>{status_text}</h1>", JSON: `{"error":"{message}","status":{status}}`},
+		http.StatusServiceUnavailable: {HTML: "<p>{message} ({retry_after}s)</p>", JSON: `{"error":"{message}"}`},
+	},
+}
+
+func TestErrorPages(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "backend says no", http.StatusNotFound)
+	}))
+	defer backend.Close()
+
+	h := router.CreateSingleHttpServer(&server.Server{Name: "pages.test", URL: backend.URL, ErrorPages: testErrorPages})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://pages.test/<missing>", nil))
+	if rr.Code != http.StatusNotFound || rr.Body.String() != "<h1>404 /&lt;missing&gt;</h1>" || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("intercepted 404: got %d %q (%s)", rr.Code, rr.Body.String(), rr.Header().Get("Content-Type"))
+	}
+
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL := dead.URL
+	dead.Close()
+	h = router.CreateSingleHttpServer(&server.Server{Name: "pages.test", URL: deadURL, ErrorPages: testErrorPages})
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://pages.test/", nil))
+	if rr.Code != http.StatusBadGateway || rr.Body.String() != "<h1>Bad Gateway</h1>" {
+		t.Fatalf("html 502: got %d %q", rr.Code, rr.Body.String())
+	}
+
+	req := httptest.NewRequest("GET", "http://pages.test/", nil)
+	req.Header.Set("Accept", "text/html;q=0.8, application/json")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	var body map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || rr.Code != http.StatusBadGateway || body["status"] != float64(502) {
+		t.Fatalf("json 502: got %d %q (%v)", rr.Code, rr.Body.String(), err)
+	}
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	s := &server.Server{Name: "maintenance.test", URL: backend.URL, ErrorPages: testErrorPages}
+	h := router.CreateSingleHttpServer(s)
+	get := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://maintenance.test/", nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := get("203.0.113.7:1234"); rr.Code != http.StatusOK {
+		t.Fatalf("maintenance off: got %d", rr.Code)
+	}
+
+	s.SetMaintenance(&server.MaintenanceConfig{Enabled: true, RetryAfter: 120, AllowIPs: []string{"10.0.0.0/8", "192.0.2.1"}, Message: "Back soon"})
+	rr := get("203.0.113.7:1234")
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "120" || rr.Body.String() != "<p>Back soon (120s)</p>" {
+		t.Fatalf("maintenance on: got %d %q %q", rr.Code, rr.Header().Get("Retry-After"), rr.Body.String())
+	}
+	for _, addr := range []string{"10.1.2.3:5555", "192.0.2.1:80"} {
+		if rr := get(addr); rr.Code != http.StatusOK {
+			t.Fatalf("allowed ip %s: got %d", addr, rr.Code)
+		}
+	}
+
+	s.SetMaintenance(nil)
+	if rr := get("203.0.113.7:1234"); rr.Code != http.StatusOK {
+		t.Fatalf("maintenance disabled again: got %d", rr.Code)
+	}
+}
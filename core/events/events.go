@@ -11,6 +11,8 @@ var (
 	ErrorDroppedEvent      *goevents.Event
 	CertManagerActionEvent *goevents.Event
 	ConfigFileUpdateEvent  *goevents.Event
+	AccessLogEvent         *goevents.Event
+	WAFBlockEvent          *goevents.Event
 )
 
 func init() {
@@ -20,6 +22,8 @@ func init() {
 	ErrorDroppedEvent = eventBus.CreateEvent("error_dropped")
 	CertManagerActionEvent = eventBus.CreateEvent("cert_manager_action")
 	ConfigFileUpdateEvent = eventBus.CreateEvent("config_file_update")
+	AccessLogEvent = eventBus.CreateEvent("access_log")
+	WAFBlockEvent = eventBus.CreateEvent("waf_block")
 }
 
 func AddEventHandler(event *goevents.Event, handler goevents.EventHandler) {
@@ -67,6 +67,11 @@ func GetLogger() *Logger {
 	return logger
 }
 
+// NewLogger returns a logger writing to w, to be installed with SetLogger.
+func NewLogger(w io.Writer) *Logger {
+	return &Logger{writer: w}
+}
+
 func SetLogger(lgr *Logger) {
 	logger = lgr
 }
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+func TestForwardAuth_AllowDenyAndCache(t *testing.T) {
+	var calls atomic.Int32
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get("X-Forwarded-Uri") != "/private?x=1" || r.Header.Get("X-Forwarded-Method") != "GET" {
+			t.Errorf("missing forwarded metadata: %v", r.Header)
+		}
+		if r.Header.Get("Authorization") != "Bearer good" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "denied", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("X-User", "alice")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer auth.Close()
+
+	conf := server.ForwardAuthMiddlewareConfig{
+		Address:             auth.URL,
+		CacheTTL:            time.Minute,
+		AuthResponseHeaders: []string{"X-User"},
+	}
+	mw := server.ForwardAuthMiddleware(conf)
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-User")))
+	}))
+
+	// allowed + auth header copied upstream
+	for range 2 {
+		req := httptest.NewRequest("GET", "/private?x=1", nil)
+		req.Header.Set("Authorization", "Bearer good")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != 200 || rr.Body.String() != "alice" {
+			t.Fatalf("want 200 alice, got %d %q", rr.Code, rr.Body.String())
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("expected cached decision, auth called %d times", got)
+	}
+
+	// denied: status, headers and body returned as-is
+	req := httptest.NewRequest("GET", "/private?x=1", nil)
+	req.Header.Set("Authorization", "Bearer bad")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Fatalf("want 401 with challenge, got %d %v", rr.Code, rr.Header())
+	}
+}
+
+func TestForwardAuth_UnreachableService(t *testing.T) {
+	mw := server.ForwardAuthMiddleware(server.ForwardAuthMiddlewareConfig{Address: "http://127.0.0.1:1", Timeout: 500 * time.Millisecond})
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200) }))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d", rr.Code)
+	}
+}
+
+func TestForwardAuth_CacheKeys(t *testing.T) {
+	var calls atomic.Int32
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get("X-Forwarded-Method") == "DELETE" || r.Header.Get("Authorization") != "Bearer good" {
+			http.Error(w, "denied", http.StatusForbidden)
+		}
+	}))
+	defer auth.Close()
+
+	call := func(h http.Handler, method, uri, token string) int {
+		req := httptest.NewRequest(method, uri, nil)
+		req.Header.Set("Authorization", token)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := server.ForwardAuthMiddleware(server.ForwardAuthMiddlewareConfig{Address: auth.URL, CacheTTL: time.Minute, CacheSize: 2})(next)
+
+	// An allow decision is not reused for another method or URI of the token.
+	if call(h, "GET", "/public", "Bearer good") != 200 || call(h, "DELETE", "/admin", "Bearer good") != http.StatusForbidden {
+		t.Fatal("allow decision reused for another request")
+	}
+	// Denials reach the auth service every time.
+	calls.Store(0)
+	call(h, "GET", "/public", "Bearer bad")
+	call(h, "GET", "/public", "Bearer bad")
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("denial cached, auth called %d times", got)
+	}
+	// Past the cache size the decision expiring first is dropped.
+	calls.Store(0)
+	for _, uri := range []string{"/public", "/a", "/b", "/b", "/public"} {
+		call(h, "GET", uri, "Bearer good")
+	}
+	if got := calls.Load(); got != 3 {
+		t.Fatalf("want /a, /b and the evicted /public sent to the auth service, got %d calls", got)
+	}
+
+	// Keyed by token, a decision covers every request of the token.
+	h = server.ForwardAuthMiddleware(server.ForwardAuthMiddlewareConfig{Address: auth.URL, CacheTTL: time.Minute, CacheKey: server.ForwardAuthCacheKeyToken})(next)
+	calls.Store(0)
+	call(h, "GET", "/a", "Bearer good")
+	call(h, "GET", "/b", "Bearer good")
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("token keyed decision not reused, auth called %d times", got)
+	}
+	if err := (server.ForwardAuthMiddlewareConfig{Address: auth.URL, CacheKey: "user"}).Validate(); err == nil {
+		t.Fatal("unknown cache_key accepted")
+	}
+}
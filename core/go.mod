@@ -5,8 +5,10 @@ go 1.24.6
 require (
 	github.com/DoniLite/Mogoly/cloud v0.2.1
 	github.com/DoniLite/go-events v0.1.2
+	github.com/andybalholm/brotli v1.1.1
 	github.com/caddyserver/certmagic v0.25.2
 	github.com/fsnotify/fsnotify v1.9.0
+	github.com/klauspost/compress v1.18.0
 	github.com/miekg/dns v1.1.72
 	github.com/stretchr/testify v1.11.1
 	gopkg.in/yaml.v3 v3.0.1
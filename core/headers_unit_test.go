@@ -0,0 +1,97 @@
+package core
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+func TestHeadersMiddleware_RequestAndResponseRules(t *testing.T) {
+	conf := server.HeadersMiddlewareConfig{
+		Request: server.HeaderRules{
+			Set:    map[string]string{"X-Real-IP": "{client_ip}", "X-Origin-Host": "{host}"},
+			Remove: []string{"X-Debug"},
+		},
+		Response: server.HeaderRules{
+			Add:    map[string]string{"X-Served-By": "mogoly"},
+			Remove: []string{"Server"},
+		},
+	}
+	h := server.HeadersMiddleware(conf)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Real-IP") != "1.2.3.4" || r.Header.Get("X-Origin-Host") != "app.test" {
+			t.Errorf("request templates not expanded: %v", r.Header)
+		}
+		if r.Header.Get("X-Debug") != "" {
+			t.Errorf("X-Debug should be removed")
+		}
+		w.Header().Set("Server", "backend/1.0")
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	req := httptest.NewRequest("GET", "http://app.test/", nil)
+	req.RemoteAddr = "1.2.3.4:5678"
+	req.Header.Set("X-Debug", "1")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("unexpected code: %d", rr.Code)
+	}
+	if rr.Header().Get("X-Served-By") != "mogoly" || rr.Header().Get("Server") != "" {
+		t.Fatalf("response rules not applied: %v", rr.Header())
+	}
+}
+
+func TestHeadersMiddleware_SecurityPreset(t *testing.T) {
+	conf := server.HeadersMiddlewareConfig{
+		Preset:   server.HeadersPresetSecurity,
+		Security: server.SecurityHeadersConfig{FrameOptions: "SAMEORIGIN"},
+	}
+	h := server.HeadersMiddleware(conf)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src *")
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	req := httptest.NewRequest("GET", "https://app.test/", nil)
+	req.TLS = &tls.ConnectionState{}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	want := map[string]string{
+		"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "SAMEORIGIN",
+		"Content-Security-Policy":   "default-src *", // backend value wins
+	}
+	for k, v := range want {
+		if got := rr.Header().Get(k); got != v {
+			t.Errorf("%s: want %q, got %q", k, v, got)
+		}
+	}
+
+	// HSTS is not sent over plain HTTP
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://app.test/", nil))
+	if rr.Header().Get("Strict-Transport-Security") != "" {
+		t.Fatalf("unexpected HSTS header over http")
+	}
+}
+
+func TestHeadersMiddleware_EmptyResponse(t *testing.T) {
+	conf := server.HeadersMiddlewareConfig{
+		Preset:   server.HeadersPresetSecurity,
+		Response: server.HeaderRules{Set: map[string]string{"X-Served-By": "mogoly"}},
+	}
+	// The handler writes neither a status nor a body
+	h := server.HeadersMiddleware(conf)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://app.test/", nil))
+	res := rr.Result()
+	if res.StatusCode != http.StatusOK || res.Header.Get("X-Served-By") != "mogoly" || res.Header.Get("X-Content-Type-Options") == "" {
+		t.Fatalf("rules not applied to an empty answer: %d %v", res.StatusCode, res.Header)
+	}
+}
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DoniLite/Mogoly/core/router"
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+type stampConfig struct {
+	Value string `json:"value" yaml:"value"`
+}
+
+func (c stampConfig) Validate() error {
+	if c.Value == "" {
+		return errors.New("value is required")
+	}
+	return nil
+}
+
+func TestRegisterMiddleware_TypedConfig(t *testing.T) {
+	name := server.MiddleWareName("test:stamp")
+	stamp := func(c stampConfig) func(next http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Stamp", c.Value)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	if err := server.RegisterMiddleware(name, stamp); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterMiddleware(name, stamp); err == nil {
+		t.Fatal("duplicate registration accepted")
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	h := router.CreateSingleHttpServer(&server.Server{
+		Name:        "stamp.test",
+		URL:         backend.URL,
+		Middlewares: []server.Middleware{{Name: string(name), Config: map[string]any{"value": "ok"}}},
+	})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://stamp.test/", nil))
+	if rr.Header().Get("X-Stamp") != "ok" {
+		t.Fatalf("typed config not applied: %v", rr.Header())
+	}
+
+	var mErr *server.MiddlewareConfigError
+	if err := server.ValidateMiddleware(server.Middleware{Name: string(name), Config: map[string]any{"valeu": "typo"}}); !errors.As(err, &mErr) || !strings.Contains(err.Error(), "valeu") {
+		t.Fatalf("unknown field not rejected: %v", err)
+	}
+	if err := server.ValidateMiddleware(server.Middleware{Name: string(name)}); err == nil || !strings.Contains(err.Error(), "value is required") {
+		t.Fatalf("Validate not called: %v", err)
+	}
+}
+
+func TestParseConfig_RejectsInvalidMiddlewares(t *testing.T) {
+	yml := `
+server:
+  - name: api
+    url: http://127.0.0.1:9000
+    middlewares:
+      - name: mogoly:does-not-exist
+  - name: web
+    url: http://127.0.0.1:9001
+    middlewares:
+      - name: mogoly:rewrite
+        config:
+          regex: "("
+`
+	_, err := ParseConfig([]byte(yml), "yaml")
+	if !errors.Is(err, server.ErrUnknownMiddleware) {
+		t.Fatalf("unknown middleware not reported: %v", err)
+	}
+	if !strings.Contains(err.Error(), `server "web": middleware "mogoly:rewrite"`) {
+		t.Fatalf("invalid rewrite config not reported: %v", err)
+	}
+}
+
+func TestCreateSingleHttpServer_UnknownMiddlewareFailsClosed(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	h := router.CreateSingleHttpServer(&server.Server{
+		Name:        "closed.test",
+		URL:         backend.URL,
+		Middlewares: []server.Middleware{{Name: "mogoly:does-not-exist"}},
+	})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://closed.test/", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", rr.Code)
+	}
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -40,3 +41,42 @@ func TestRateLimiter(t *testing.T) {
 		t.Fatalf("after window, expected 200, got %d", rr.Code)
 	}
 }
+
+func TestRateLimiter_RawConfig(t *testing.T) {
+	// The raw configs keep working through the untyped constructor, limit_window in seconds.
+	mw := server.RateLimiterMiddleware(map[string]any{"request_per_minute": 1, "limit_window": 0.2})
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200) }))
+	codes := func() (int, int) {
+		var got [2]int
+		for i := range got {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "5.6.7.8:5678"
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			got[i] = rr.Code
+		}
+		return got[0], got[1]
+	}
+	if first, second := codes(); first != 200 || second != http.StatusTooManyRequests {
+		t.Fatalf("got %d then %d", first, second)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if first, _ := codes(); first != 200 {
+		t.Fatalf("after the 0.2s window, expected 200, got %d", first)
+	}
+
+	for _, tc := range []struct {
+		config map[string]any
+		want   string // Expected in the error, none when empty
+	}{
+		{map[string]any{"limit_window": "1m"}, ""},
+		{map[string]any{"limit_window": 30}, ""},
+		{map[string]any{"limit_window": "soon"}, "soon"},
+		{map[string]any{"request_per_minut": 10}, "request_per_minut"},
+	} {
+		err := server.ValidateMiddleware(server.Middleware{Name: string(server.MogolyRatelimiter), Config: tc.config})
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%v: got %v, want %q", tc.config, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DoniLite/Mogoly/core/router"
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+func TestRedirectMiddleware_RulesAndCanonical(t *testing.T) {
+	conf := server.RedirectMiddlewareConfig{
+		Rules: []server.RedirectRule{
+			{Regex: "^http://app.test/old/(.*)$", Replacement: "http://app.test/new/$1", Permanent: true},
+			{Regex: "^http://app.test/tmp$", Replacement: "/elsewhere"},
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	h := server.RedirectMiddleware(conf)(next)
+
+	cases := []struct {
+		method, url, location string
+		code                  int
+	}{
+		{"GET", "http://app.test/old/a?b=c", "http://app.test/new/a?b=c", http.StatusMovedPermanently},
+		{"POST", "http://app.test/old/a", "http://app.test/new/a", http.StatusPermanentRedirect},
+		{"GET", "http://app.test/tmp", "/elsewhere", http.StatusFound},
+		{"GET", "http://app.test/other", "", http.StatusTeapot},
+	}
+	for _, c := range cases {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(c.method, c.url, nil))
+		if rr.Code != c.code || rr.Header().Get("Location") != c.location {
+			t.Fatalf("%s %s: want %d %q, got %d %q", c.method, c.url, c.code, c.location, rr.Code, rr.Header().Get("Location"))
+		}
+	}
+
+	www := server.RedirectMiddleware(server.RedirectMiddlewareConfig{Canonical: server.RedirectCanonicalWWW, CanonicalPermanent: true})(next)
+	rr := httptest.NewRecorder()
+	www.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.test:8080/x", nil))
+	if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "http://www.example.test:8080/x" {
+		t.Fatalf("www canonical: got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	apex := server.RedirectMiddleware(server.RedirectMiddlewareConfig{Canonical: server.RedirectCanonicalApex})(next)
+	rr = httptest.NewRecorder()
+	apex.ServeHTTP(rr, httptest.NewRequest("GET", "http://www.example.test/x", nil))
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "http://example.test/x" {
+		t.Fatalf("apex canonical: got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+}
+
+func TestTLSRedirectTarget(t *testing.T) {
+	s := &server.Server{Name: "app.test", ForceTLS: true}
+	target, status := s.TLSRedirectTarget(httptest.NewRequest("GET", "http://app.test:80/a?b=1", nil))
+	if target != "https://app.test/a?b=1" || status != http.StatusMovedPermanently {
+		t.Fatalf("default redirect: got %q %d", target, status)
+	}
+
+	s.TLSRedirect = &server.TLSRedirectConfig{StatusCode: http.StatusPermanentRedirect, Port: 8443}
+	target, status = s.TLSRedirectTarget(httptest.NewRequest("POST", "http://app.test/a", nil))
+	if target != "https://app.test:8443/a" || status != http.StatusPermanentRedirect {
+		t.Fatalf("custom redirect: got %q %d", target, status)
+	}
+
+	for host, want := range map[string]string{
+		"[::1]":      "https://[::1]:8443/a",
+		"[::1]:8080": "https://[::1]:8443/a",
+	} {
+		req := httptest.NewRequest("GET", "http://app.test/a", nil)
+		req.Host = host
+		if target, _ := s.TLSRedirectTarget(req); target != want {
+			t.Fatalf("ipv6 host %s: got %q, want %q", host, target, want)
+		}
+	}
+	s.TLSRedirect.Port = 443
+	req := httptest.NewRequest("GET", "http://app.test/a", nil)
+	req.Host = "[::1]"
+	if target, _ := s.TLSRedirectTarget(req); target != "https://[::1]/a" {
+		t.Fatalf("ipv6 host on the default port: got %q", target)
+	}
+
+	cf := &router.Config{Servers: []*server.Server{{Name: "app.test", TLSRedirect: &server.TLSRedirectConfig{StatusCode: http.StatusOK, Port: 70000}}}}
+	if err := cf.Validate(); err == nil || !strings.Contains(err.Error(), "status_code must be one of") || !strings.Contains(err.Error(), "invalid port 70000") {
+		t.Fatalf("invalid tls_redirect accepted: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DoniLite/Mogoly/core/events"
+	"github.com/DoniLite/Mogoly/core/router"
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var seen string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get("X-Trace-ID")
+		w.Header().Set("X-Trace-ID", "backend-echo")
+	}))
+	defer backend.Close()
+
+	h := router.CreateSingleHttpServer(&server.Server{
+		Name:        "requestid.test",
+		URL:         backend.URL,
+		Middlewares: []server.Middleware{{Name: string(server.MogolyRequestID), Config: map[string]any{"header": "x-trace-id"}}},
+	})
+
+	prev := events.GetLogger()
+	logs := &lockedBuffer{}
+	events.SetLogger(events.NewLogger(logs))
+	defer events.SetLogger(prev)
+
+	req := httptest.NewRequest("GET", "http://requestid.test/", nil)
+	req.Header.Set("X-Trace-ID", "abc-123")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if seen != "abc-123" || rr.Header().Values("X-Trace-ID")[0] != "abc-123" || len(rr.Header().Values("X-Trace-ID")) != 1 {
+		t.Fatalf("incoming id must be kept: backend saw %q, response has %v", seen, rr.Header().Values("X-Trace-ID"))
+	}
+	if !strings.Contains(logs.String(), "(request id: abc-123)") {
+		t.Fatalf("proxy logs must carry the request id:\n%s", logs.String())
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://requestid.test/", nil))
+	if len(seen) != 32 || rr.Header().Get("X-Trace-ID") != seen {
+		t.Fatalf("generated id must be forwarded and echoed: backend saw %q, response has %q", seen, rr.Header().Get("X-Trace-ID"))
+	}
+}
+
+func TestRequestIDFromContext(t *testing.T) {
+	var fromCtx string
+	h := server.RequestIDMiddleware(server.RequestIDMiddlewareConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fromCtx = server.RequestIDFromContext(r.Context())
+	}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if fromCtx == "" || rr.Header().Get(server.RequestIDDefaultHeader) != fromCtx {
+		t.Fatalf("context id %q does not match the response %q", fromCtx, rr.Header().Get(server.RequestIDDefaultHeader))
+	}
+}
+
+// A hijacked connection does not get a status line written on top of it.
+func TestRequestIDMiddleware_Hijack(t *testing.T) {
+	h := server.RequestIDMiddleware(server.RequestIDMiddlewareConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+	}))
+	ts := httptest.NewUnstartedServer(h)
+	serverLogs := &lockedBuffer{}
+	ts.Config.ErrorLog = log.New(serverLogs, "", 0)
+	ts.Start()
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	ts.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || serverLogs.String() != "" {
+		t.Fatalf("got %d, server logged %q", resp.StatusCode, serverLogs.String())
+	}
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DoniLite/Mogoly/core/router"
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+func TestPathRewriteMiddlewares(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "%s|%s", r.URL.RequestURI(), r.Header.Get("X-Forwarded-Prefix"))
+	}))
+	defer backend.Close()
+
+	cases := []struct {
+		name        string
+		middlewares []server.Middleware
+		path        string
+		prefix      string // X-Forwarded-Prefix sent by the client
+		want        string
+	}{
+		{
+			name:        "strip longest prefix",
+			middlewares: []server.Middleware{{Name: string(server.MogolyStripPrefix), Config: map[string]any{"prefixes": []any{"/app", "/app/v1"}}}},
+			path:        "/app/v1/users?id=3",
+			want:        "/users?id=3|/app/v1",
+		},
+		{
+			name:        "strip only on segment boundary",
+			middlewares: []server.Middleware{{Name: string(server.MogolyStripPrefix), Config: map[string]any{"prefixes": []any{"/app"}}}},
+			path:        "/application",
+			want:        "/application|",
+		},
+		{
+			name:        "add prefix",
+			middlewares: []server.Middleware{{Name: string(server.MogolyAddPrefix), Config: map[string]any{"prefix": "/internal"}}},
+			path:        "/users",
+			want:        "/internal/users|",
+		},
+		{
+			name:        "spoofed prefix overwritten",
+			middlewares: []server.Middleware{{Name: string(server.MogolyStripPrefix), Config: map[string]any{"prefixes": []any{"/app"}}}},
+			path:        "/app/users",
+			prefix:      "/evil",
+			want:        "/users|/app",
+		},
+		{
+			name: "prefix of a trusted proxy kept",
+			middlewares: []server.Middleware{{Name: string(server.MogolyStripPrefix), Config: map[string]any{
+				"prefixes": []any{"/app"}, "trusted_proxies": []any{"192.0.2.0/24"},
+			}}},
+			path:   "/app/users",
+			prefix: "/edge",
+			want:   "/users|/edge/app",
+		},
+		{
+			name: "chained strips",
+			middlewares: []server.Middleware{
+				{Name: string(server.MogolyStripPrefix), Config: map[string]any{"prefixes": []any{"/a"}}},
+				{Name: string(server.MogolyStripPrefix), Config: map[string]any{"prefixes": []any{"/b"}}},
+			},
+			path:   "/a/b/c",
+			prefix: "/evil",
+			want:   "/c|/a/b",
+		},
+		{
+			name:        "add prefix drops a spoofed prefix",
+			middlewares: []server.Middleware{{Name: string(server.MogolyAddPrefix), Config: map[string]any{"prefix": "/internal"}}},
+			path:        "/users",
+			prefix:      "/evil",
+			want:        "/internal/users|",
+		},
+		{
+			name: "regex rewrite with capture groups",
+			middlewares: []server.Middleware{{Name: string(server.MogolyRewrite), Config: map[string]any{
+				"regex": "^/blog/(\\d+)/(.*)$", "replacement": "/posts/$2/$1",
+			}}},
+			path: "/blog/2024/hello",
+			want: "/posts/hello/2024|",
+		},
+		{
+			name: "regex rewrite dropping a leading part",
+			middlewares: []server.Middleware{{Name: string(server.MogolyRewrite), Config: map[string]any{
+				"regex": "^/tenant/[a-z]+(/.*)$", "replacement": "$1",
+			}}},
+			path: "/tenant/acme/dashboard",
+			want: "/dashboard|/tenant/acme",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &server.Server{Name: "app.test", URL: backend.URL, Middlewares: c.middlewares}
+			h := router.CreateSingleHttpServer(s)
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "http://app.test"+c.path, nil)
+			if c.prefix != "" {
+				req.Header.Set("X-Forwarded-Prefix", c.prefix)
+			}
+			h.ServeHTTP(rr, req)
+			if rr.Body.String() != c.want {
+				t.Fatalf("want %q, got %q", c.want, rr.Body.String())
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package router
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"slices"
@@ -15,34 +16,45 @@ var (
 	currentRouter *RouterState
 )
 
-func Startup(config *Config) {
+// Startup builds the router from the persisted config, then merges config into it.
+// An invalid persisted config is returned instead of being replaced by an empty one.
+func Startup(config *Config) error {
 	// First trying to load the config from the file
 	configFromFile, err := LoadConfig()
+	if errors.Is(err, ErrInvalidConfig) {
+		// Starting with an empty router would overwrite the user config on the next persist.
+		events.Logf(events.LOG_ERROR, "[ROUTER]: Invalid %s: %v", ROUTER_CONFIG_FILE, err)
+		return fmt.Errorf("invalid %s: %w", ROUTER_CONFIG_FILE, err)
+	}
 
 	if configFromFile != nil && err == nil {
-		buildRouter(configFromFile)
+		err = buildRouter(configFromFile)
 	} else {
-		buildRouter(&Config{
+		err = buildRouter(&Config{
 			Servers:   make([]*server.Server, 0),
 			Services:  make([]*cloud.ServiceConfig, 0),
 			Variables: make(map[string]string),
 		})
 	}
+	if err != nil {
+		return err
+	}
 
 	if config != nil {
 		MergeRouterConfigs(config)
 	}
+	return nil
 }
 
-func StartupWithDefault() {
-	Startup(nil)
+func StartupWithDefault() error {
+	return Startup(nil)
 }
 
-func buildRouter(initialConfig *Config) {
+func buildRouter(initialConfig *Config) error {
 	var err error
 	manager, err := cloud.NewCloudDBManager()
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to create the cloud service manager: %w", err)
 	}
 
 	events.Logf(events.LOG_INFO, "[ROUTER]: Building new router for the global server state")
@@ -74,6 +86,7 @@ func buildRouter(initialConfig *Config) {
 		}
 		rs.cloudServiceInstanceMap[strings.ToLower(service.Name)] = currentService
 	}
+	rs.publish()
 
 	initialConfig.BuildVars()
 	err = initialConfig.PersistConfig()
@@ -85,6 +98,7 @@ func buildRouter(initialConfig *Config) {
 	rs.globalConfig = initialConfig
 	currentRouter = rs
 	events.Logf(events.LOG_INFO, "[ROUTER]: New router builded and assigned correctly")
+	return nil
 }
 
 func GetRouter() (*RouterState, error) {
@@ -97,41 +111,66 @@ func GetRouter() (*RouterState, error) {
 func CreateSingleHttpServer(s *server.Server) http.Handler {
 	events.Logf(events.LOG_INFO, "[SERVER]: Creating new http handler for %s server", s.Name)
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", s.ServeHTTP)
+	if len(s.Routes) > 0 {
+		mux.Handle("/", newRouteTable(s, http.HandlerFunc(s.ServeHTTP)))
+	} else {
+		mux.HandleFunc("/", s.ServeHTTP)
+	}
 
 	var middlewares []func(http.Handler) http.Handler
 
 	events.Logf(events.LOG_INFO, "[SERVER]: Assigning middlewares for the %s server", s.Name)
 	for _, v := range s.Middlewares {
-		m := server.MiddlewaresList[server.MiddleWareName(v.Name)]
-
-		middlewares = append(middlewares, m.Fn(v.Config))
+		m, err := server.BuildMiddleware(v)
+		if err != nil {
+			// Fail closed: skipping an auth or limit middleware would silently open the server.
+			events.Logf(events.LOG_ERROR, "[SERVER]: Cannot build the %s middleware of the %s server: %v", v.Name, s.Name, err)
+			m = misconfiguredMiddleware
+		}
+		middlewares = append(middlewares, m)
 	}
 	events.Logf(events.LOG_INFO, "[SERVER]: %d assigned to the %s server", len(middlewares), s.Name)
 
-	return server.ChainMiddleware(mux, middlewares...)
+	return s.MaintenanceHandler(server.ChainMiddleware(mux, middlewares...))
 }
 
-func MergeRouterConfigs(newConfig *Config) *Config {
+func misconfiguredMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Misconfigured middleware", http.StatusInternalServerError)
+	})
+}
 
+// MergeRouterConfigs adds the servers, services, entrypoints and variables of newConfig
+// missing from the current config, existing entries are left untouched. Use
+// ReconcileConfig to apply the edits of a reloaded config.
+func MergeRouterConfigs(newConfig *Config) *Config {
 	if currentRouter == nil {
-		buildRouter(nil)
+		if err := buildRouter(&Config{}); err != nil {
+			events.Logf(events.LOG_ERROR, "[ROUTER]: Cannot merge the router config: %v", err)
+			return nil
+		}
 	}
 
-	conf := currentRouter.globalConfig
+	// The config is shared with the handlers and the health checks, it is only edited under the lock.
+	rs := currentRouter
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
 
-	if conf == nil {
-		conf = &Config{}
+	if rs.globalConfig == nil {
+		rs.globalConfig = &Config{}
 	}
+	conf := rs.globalConfig
 
+	added := false
 	for _, srv := range newConfig.Servers {
-		if !slices.ContainsFunc(conf.Servers, func(s *server.Server) bool {
-			return s.Name == srv.Name
-		}) {
-			conf.Servers = append(conf.Servers, srv)
-			currentRouter.AddServer(srv)
+		// addServer appends srv to the config.
+		if rs.addServer(srv) {
+			added = true
 		}
 	}
+	if added {
+		rs.publish()
+	}
 
 	for _, svc := range newConfig.Services {
 		if !slices.ContainsFunc(conf.Services, func(s *cloud.ServiceConfig) bool {
@@ -141,7 +180,19 @@ func MergeRouterConfigs(newConfig *Config) *Config {
 		}
 	}
 
+	for name, ep := range newConfig.EntryPoints {
+		if conf.EntryPoints == nil {
+			conf.EntryPoints = make(map[string]*EntryPoint)
+		}
+		if _, exists := conf.EntryPoints[name]; !exists {
+			conf.EntryPoints[name] = ep
+		}
+	}
+
 	for key, value := range newConfig.Variables {
+		if conf.Variables == nil {
+			conf.Variables = make(map[string]string)
+		}
 		if _, exists := conf.Variables[key]; !exists {
 			conf.Variables[key] = value
 		}
@@ -153,6 +204,5 @@ func MergeRouterConfigs(newConfig *Config) *Config {
 		events.Logf(events.LOG_ERROR, "[ROUTER]: Error while persisting router config: %v", err)
 	}
 
-	currentRouter.globalConfig = conf
 	return conf
 }
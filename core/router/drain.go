@@ -0,0 +1,215 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/events"
+)
+
+// ShutdownReport tells how the in-flight requests fared during ShutdownEntryPoints.
+type ShutdownReport struct {
+	InFlight int           `json:"in_flight"` // Requests in flight when the shutdown started, upgraded connections included
+	Aborted  int           `json:"aborted"`   // Requests still in flight at the end of the grace period, closed
+	Duration time.Duration `json:"duration"`
+}
+
+// draining is set once the entrypoints are shutting down.
+var draining atomic.Bool
+
+// Ready reports whether the router is built and its entrypoints are not shutting down.
+func Ready() bool {
+	return currentRouter != nil && !draining.Load()
+}
+
+// ReadinessHandler answers 200 while the router is Ready and 503 once it is not, so that
+// the load balancers in front of the entrypoints stop sending traffic to a draining daemon.
+func ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if !Ready() {
+			http.Error(w, "draining", http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ready")
+	})
+}
+
+// withHealthPath answers the requests for path with the readiness of the router, ahead
+// of the entrypoint middlewares so that the probes need no credentials.
+func withHealthPath(path string, next http.Handler) http.Handler {
+	if path == "" {
+		return next
+	}
+	ready := ReadinessHandler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path {
+			ready.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Trackers of the entrypoint listeners, by *http.Server.
+var trackers sync.Map
+
+// connTracker counts the in-flight requests of a listener and keeps the connections
+// hijacked by protocol upgrades, websockets for instance, which http.Server.Shutdown
+// neither waits for nor closes.
+type connTracker struct {
+	inflight atomic.Int64
+	mu       sync.Mutex
+	hijacked map[net.Conn]struct{}
+}
+
+func trackerOf(hs *http.Server) *connTracker {
+	if t, ok := trackers.Load(hs); ok {
+		return t.(*connTracker)
+	}
+	return nil
+}
+
+// track registers a tracker for hs and returns its handler counting the requests.
+func track(hs *http.Server, next http.Handler) http.Handler {
+	t := &connTracker{hijacked: make(map[net.Conn]struct{})}
+	trackers.Store(hs, t)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.inflight.Add(1)
+		defer t.inflight.Add(-1)
+		next.ServeHTTP(&trackingWriter{ResponseWriter: w, tracker: t}, r)
+	})
+}
+
+// InFlight returns the requests being served by the entrypoint listener hs.
+func InFlight(hs *http.Server) int {
+	if t := trackerOf(hs); t != nil {
+		return int(t.inflight.Load())
+	}
+	return 0
+}
+
+// wait returns once no request is in flight or ctx is done.
+func (t *connTracker) wait(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for t.inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (t *connTracker) closeHijacked() {
+	t.mu.Lock()
+	conns := make([]net.Conn, 0, len(t.hijacked))
+	for c := range t.hijacked {
+		conns = append(conns, c)
+	}
+	t.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+type trackingWriter struct {
+	http.ResponseWriter
+	tracker *connTracker
+}
+
+func (w *trackingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *trackingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack not supported by the underlying response writer")
+	}
+	conn, rw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	tc := &trackedConn{Conn: conn, tracker: w.tracker}
+	w.tracker.mu.Lock()
+	w.tracker.hijacked[tc] = struct{}{}
+	w.tracker.mu.Unlock()
+	return tc, rw, nil
+}
+
+// Unwrap lets http.ResponseController reach the original writer.
+func (w *trackingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// trackedConn forgets the hijacked connection once closed.
+type trackedConn struct {
+	net.Conn
+	tracker *connTracker
+}
+
+func (c *trackedConn) Close() error {
+	c.tracker.mu.Lock()
+	delete(c.tracker.hijacked, c)
+	c.tracker.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// ShutdownEntryPoints stops the entrypoint listeners gracefully. The router is marked
+// not ready and the listeners stop accepting, then the in-flight requests, upgraded
+// connections included, get until ctx is done to complete. The connections left are
+// closed once ctx is done.
+func ShutdownEntryPoints(ctx context.Context, servers map[string]*http.Server) *ShutdownReport {
+	draining.Store(true)
+	start := time.Now()
+	report := &ShutdownReport{}
+	for _, hs := range servers {
+		report.InFlight += InFlight(hs)
+	}
+	events.Logf(events.LOG_INFO, "[ROUTER]: Draining %d entrypoints, %d requests in flight", len(servers), report.InFlight)
+
+	var aborted atomic.Int64
+	var wg sync.WaitGroup
+	for name, hs := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer trackers.Delete(hs)
+			defer boundListeners.Delete(hs)
+			t := trackerOf(hs)
+			// Shutdown returns once the connections are idle, the hijacked ones excepted.
+			err := hs.Shutdown(ctx)
+			if err == nil && t != nil {
+				err = t.wait(ctx)
+			}
+			if err == nil {
+				return
+			}
+			if t != nil {
+				left := t.inflight.Load()
+				aborted.Add(left)
+				events.Logf(events.LOG_ERROR, "[ROUTER]: Grace period of the %s entrypoint over, closing %d requests in flight", name, left)
+				t.closeHijacked()
+			}
+			hs.Close()
+		}()
+	}
+	wg.Wait()
+
+	report.Aborted = int(aborted.Load())
+	report.Duration = time.Since(start)
+	events.Logf(events.LOG_INFO, "[ROUTER]: Entrypoints drained in %s, %d requests aborted", report.Duration.Round(time.Millisecond), report.Aborted)
+	return report
+}
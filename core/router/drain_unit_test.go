@@ -0,0 +1,185 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+// serveDrainTest starts an internal entrypoint in front of the given handlers by host.
+func serveDrainTest(t *testing.T, handlers map[string]http.Handler) *http.Server {
+	t.Helper()
+	t.Cleanup(func() { draining.Store(false) })
+	rs := newTestRouter(t)
+	rs.globalConfig.EntryPoints = map[string]*EntryPoint{
+		"internal": {Address: "127.0.0.1:0", Protocol: "http", HealthPath: "/healthz"},
+	}
+	rs.mu.Lock()
+	for host, h := range handlers {
+		rs.serverMap[host] = &server.Server{Name: host}
+		rs.httpServerMap[host] = h
+	}
+	rs.publish()
+	rs.mu.Unlock()
+
+	hs, err := ServeEntryPoint("internal", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hs
+}
+
+func waitInFlight(t *testing.T, hs *http.Server, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for InFlight(hs) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d requests in flight, got %d", n, InFlight(hs))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestShutdownEntryPoints_DrainsInFlightRequests(t *testing.T) {
+	release := make(chan struct{})
+	hs := serveDrainTest(t, map[string]http.Handler{
+		"slow.test": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			io.WriteString(w, "done")
+		}),
+	})
+
+	result := make(chan string, 1)
+	go func() {
+		req, _ := http.NewRequest("GET", "http://"+hs.Addr+"/", nil)
+		req.Host = "slow.test"
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		result <- string(body)
+	}()
+	waitInFlight(t, hs, 1)
+
+	time.AfterFunc(100*time.Millisecond, func() { close(release) })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	report := ShutdownEntryPoints(ctx, map[string]*http.Server{"internal": hs})
+
+	if report.InFlight != 1 || report.Aborted != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if got := <-result; got != "done" {
+		t.Fatalf("in-flight request not completed: %q", got)
+	}
+	if Ready() {
+		t.Fatal("router still ready once drained")
+	}
+	if _, err := net.Dial("tcp", hs.Addr); err == nil {
+		t.Fatal("listener still accepting after the shutdown")
+	}
+}
+
+func TestShutdownEntryPoints_ClosesUpgradedConnections(t *testing.T) {
+	hs := serveDrainTest(t, map[string]http.Handler{
+		"ws.test": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, rw, err := http.NewResponseController(w).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+			rw.Flush()
+			io.Copy(io.Discard, conn)
+		}),
+	})
+
+	conn, err := net.Dial("tcp", hs.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: ws.test\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("upgrade failed: %v %v", resp, err)
+	}
+	waitInFlight(t, hs, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	report := ShutdownEntryPoints(ctx, map[string]*http.Server{"internal": hs})
+	if report.InFlight != 1 || report.Aborted != 1 || report.Duration < 200*time.Millisecond {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("upgraded connection not closed at the end of the grace period: %v", err)
+	}
+}
+
+func TestReadiness_Transition(t *testing.T) {
+	release := make(chan struct{})
+	hs := serveDrainTest(t, map[string]http.Handler{
+		"slow.test": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}),
+	})
+	health := func() int {
+		rr := httptest.NewRecorder()
+		hs.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "http://any.test/healthz", nil))
+		return rr.Code
+	}
+
+	resp, err := http.Get("http://" + hs.Addr + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !Ready() {
+		t.Fatalf("ready router answered %d", resp.StatusCode)
+	}
+
+	go func() {
+		req, _ := http.NewRequest("GET", "http://"+hs.Addr+"/", nil)
+		req.Host = "slow.test"
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	waitInFlight(t, hs, 1)
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		ShutdownEntryPoints(context.Background(), map[string]*http.Server{"internal": hs})
+	}()
+
+	// Not ready as soon as the drain starts, while requests are still in flight.
+	deadline := time.Now().Add(2 * time.Second)
+	for health() != http.StatusServiceUnavailable {
+		if time.Now().After(deadline) {
+			t.Fatal("draining router still reported ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if InFlight(hs) != 1 {
+		t.Fatalf("the drain is over already, %d requests in flight", InFlight(hs))
+	}
+	close(release)
+	<-drained
+	if health() != http.StatusServiceUnavailable {
+		t.Fatal("drained router reported ready")
+	}
+}
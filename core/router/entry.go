@@ -1,10 +1,7 @@
 package router
 
 import (
-	"crypto/tls"
-	"errors"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/DoniLite/Mogoly/core/domain"
@@ -12,68 +9,53 @@ import (
 )
 
 func routeHandler(w http.ResponseWriter, r *http.Request) {
+	serveEntry(w, r, "", false)
+}
+
+func httpEntry(w http.ResponseWriter, r *http.Request) {
+	serveEntry(w, r, "", true)
+}
+
+// serveEntry sends r to the server answering its host. The servers not attached to the
+// entrypoint are not found from it, an empty entrypoint reaching all of them.
+func serveEntry(w http.ResponseWriter, r *http.Request, entryPoint string, redirectTLS bool) {
 	rs, err := GetRouter()
 	if err != nil {
 		events.Logf(events.LOG_ERROR, "[ROUTER]: Router not ready yet")
 		http.Error(w, "router not ready", http.StatusServiceUnavailable)
 		return
 	}
-	b, ok := rs.httpServerMap[strings.ToLower(r.Host)]
-	if !ok {
+	b, h, ok := rs.ResolveHost(r.Host)
+	if !ok || !servesEntryPoint(b, entryPoint) {
 		events.Logf(events.LOG_ERROR, "[ROUTER]: Not found route for %s", strings.ToLower(r.Host))
 		http.NotFound(w, r)
 		return
 	}
-	b.ServeHTTP(w, r)
-}
-
-func httpEntry(w http.ResponseWriter, r *http.Request) {
-	rs, err := GetRouter()
-	if err != nil {
-		events.Logf(events.LOG_ERROR, "[ROUTER]: Router not ready yet")
-		http.Error(w, "router not ready", http.StatusServiceUnavailable)
-		return
-	}
-	if b, ok := rs.serverMap[strings.ToLower(r.Host)]; ok && b.ForceTLS {
-		url := *r.URL
-		url.Scheme = "https"
-		url.Host = r.Host
-		events.Logf(events.LOG_INFO, "[ROUTER]: Redirecting new incoming request from host: %s to https url: %s", strings.ToLower(r.Host), url.String())
-		http.Redirect(w, r, url.String(), http.StatusMovedPermanently)
+	if redirectTLS && b.ForceTLS {
+		target, status := b.TLSRedirectTarget(r)
+		events.Logf(events.LOG_INFO, "[ROUTER]: Redirecting new incoming request from host: %s to https url: %s", strings.ToLower(r.Host), target)
+		http.Redirect(w, r, target, status)
 		return
 	}
-	routeHandler(w, r)
+	h.ServeHTTP(w, r)
 }
 
-func ServeHTTP(addr string) *http.Server {
-	hs := &http.Server{Addr: addr, Handler: http.HandlerFunc(httpEntry)}
-	go func() {
-		events.Logf(events.LOG_INFO, "[HTTP_SERVER]: HTTP listening on %s", addr)
-		if err := hs.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			events.Logf(events.LOG_ERROR, "[HTTP_SERVER]: http server error: %v", err)
-			os.Exit(1)
-		}
-	}()
-	return hs
+// ServeHTTP serves the http entrypoint on addr, the address is bound before returning.
+//
+// Deprecated: use ServeEntryPoint, which takes the address from the config.
+func ServeHTTP(addr string) (*http.Server, error) {
+	return serveAt(ENTRYPOINT_HTTP, addr, nil)
 }
 
-func ServeHTTPS(addr string, cm *domain.Manager) *http.Server {
-	ts := &http.Server{Addr: addr, Handler: http.HandlerFunc(routeHandler)}
-	ts.TLSConfig = &tls.Config{GetCertificate: cm.GetCertificate, MinVersion: tls.VersionTLS12}
-	// Create listener *first* so we can expose the effective addr (when :0 was requested).
-	ln, err := tls.Listen("tcp", addr, ts.TLSConfig)
-	if err != nil {
-		events.Logf(events.LOG_ERROR, "[HTTPS_SERVER]: https server error: %v", err)
-		os.Exit(1)
-	}
-	// Publish the effective addr (e.g., 127.0.0.1:51327) for tests and callers.
-	ts.Addr = ln.Addr().String()
-	events.Logf(events.LOG_INFO, "[HTTPS_SERVER]: HTTPS listening on %s", ts.Addr)
-	go func() {
-		if err := ts.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			events.Logf(events.LOG_ERROR, "[HTTPS_SERVER]: https server error: %v", err)
-			os.Exit(1)
-		}
-	}()
-	return ts
+// ServeHTTPS serves the https entrypoint on addr, the address is bound before returning.
+//
+// Deprecated: use ServeEntryPoint, which takes the address from the config.
+func ServeHTTPS(addr string, cm *domain.Manager) (*http.Server, error) {
+	return serveAt(ENTRYPOINT_HTTPS, addr, cm)
+}
+
+func serveAt(name, addr string, cm *domain.Manager) (*http.Server, error) {
+	ep := entryPointFor(name)
+	ep.Address, ep.Protocol = addr, name
+	return serveEntryPoint(name, ep, cm)
 }
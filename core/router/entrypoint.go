@@ -0,0 +1,302 @@
+package router
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/domain"
+	"github.com/DoniLite/Mogoly/core/events"
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+const (
+	ENTRYPOINT_HTTP  string = "http"
+	ENTRYPOINT_HTTPS string = "https"
+)
+
+// DefaultEntryPoint returns the limits used when the config does not override them.
+// Read and write timeouts stay disabled so that uploads and streamed responses
+// are not cut, slow clients are bounded by the header and idle timeouts.
+func DefaultEntryPoint() *EntryPoint {
+	return &EntryPoint{
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		MaxHeaderBytes:    http.DefaultMaxHeaderBytes,
+	}
+}
+
+// defaultAddresses are the listen addresses of the built-in entrypoints.
+var defaultAddresses = map[string]string{
+	ENTRYPOINT_HTTP:  ":80",
+	ENTRYPOINT_HTTPS: ":443",
+}
+
+// configuredEntryPoints returns the entrypoint settings of the config by lowercased name.
+func configuredEntryPoints() map[string]*EntryPoint {
+	if currentRouter == nil {
+		return nil
+	}
+	currentRouter.mu.RLock()
+	defer currentRouter.mu.RUnlock()
+	if currentRouter.globalConfig == nil {
+		return nil
+	}
+	return normalizeEntryPoints(currentRouter.globalConfig.EntryPoints)
+}
+
+func normalizeEntryPoints(eps map[string]*EntryPoint) map[string]*EntryPoint {
+	normalized := make(map[string]*EntryPoint, len(eps))
+	for name, ep := range eps {
+		if ep != nil {
+			normalized[strings.ToLower(name)] = ep
+		}
+	}
+	return normalized
+}
+
+// EntryPointNames returns the names of the enabled entrypoints, sorted: the built-in
+// http and https ones and those declared in the config.
+func EntryPointNames() []string {
+	return enabledEntryPoints(configuredEntryPoints())
+}
+
+func enabledEntryPoints(configured map[string]*EntryPoint) []string {
+	names := make(map[string]bool, len(configured)+len(defaultAddresses))
+	for name := range defaultAddresses {
+		names[name] = true
+	}
+	for name, ep := range configured {
+		names[name] = !ep.Disabled
+	}
+	var enabled []string
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		if names[name] {
+			enabled = append(enabled, name)
+		}
+	}
+	return enabled
+}
+
+// entryPointsWith returns the enabled entrypoints serving one of the protocols, sorted.
+func entryPointsWith(protocols ...string) []string {
+	var names []string
+	for _, name := range EntryPointNames() {
+		if slices.Contains(protocols, entryPointFor(name).Protocol) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// entryPointFor merges the configured settings of the named entrypoint over the defaults.
+func entryPointFor(name string) *EntryPoint {
+	name = strings.ToLower(name)
+	ep := DefaultEntryPoint()
+	ep.Address = defaultAddresses[name]
+	if _, builtin := defaultAddresses[name]; builtin {
+		ep.Protocol = name
+	}
+	conf, ok := configuredEntryPoints()[name]
+	if !ok {
+		return ep
+	}
+	if conf.Address != "" {
+		ep.Address = conf.Address
+	}
+	if conf.Protocol != "" {
+		ep.Protocol = strings.ToLower(conf.Protocol)
+	}
+	ep.Middlewares = conf.Middlewares
+	ep.Disabled = conf.Disabled
+	if conf.ReadHeaderTimeout > 0 {
+		ep.ReadHeaderTimeout = conf.ReadHeaderTimeout
+	}
+	if conf.ReadTimeout > 0 {
+		ep.ReadTimeout = conf.ReadTimeout
+	}
+	if conf.WriteTimeout > 0 {
+		ep.WriteTimeout = conf.WriteTimeout
+	}
+	if conf.IdleTimeout > 0 {
+		ep.IdleTimeout = conf.IdleTimeout
+	}
+	if conf.MaxHeaderBytes > 0 {
+		ep.MaxHeaderBytes = conf.MaxHeaderBytes
+	}
+	ep.ProxyProtocol = conf.ProxyProtocol
+	ep.HealthPath = conf.HealthPath
+	return ep
+}
+
+// apply copies the entrypoint limits on hs.
+func (ep *EntryPoint) apply(hs *http.Server) {
+	hs.ReadHeaderTimeout = ep.ReadHeaderTimeout
+	hs.ReadTimeout = ep.ReadTimeout
+	hs.WriteTimeout = ep.WriteTimeout
+	hs.IdleTimeout = ep.IdleTimeout
+	hs.MaxHeaderBytes = ep.MaxHeaderBytes
+}
+
+// accepting returns the listener accepting the connections of ln, reading the PROXY
+// protocol headers of the trusted load balancers when configured.
+func (ep *EntryPoint) accepting(ln net.Listener) net.Listener {
+	if ep.ProxyProtocol == nil {
+		return ln
+	}
+	return ep.ProxyProtocol.Listener(ln, ep.ReadHeaderTimeout)
+}
+
+// validate checks a configured entrypoint, the built-in ones get their protocol from
+// their name.
+func (ep *EntryPoint) validate(name string) error {
+	var errs []error
+	protocol := strings.ToLower(ep.Protocol)
+	if protocol == "" {
+		if _, builtin := defaultAddresses[name]; !builtin {
+			errs = append(errs, fmt.Errorf("protocol is required"))
+		}
+	} else if !slices.Contains([]string{ENTRYPOINT_HTTP, ENTRYPOINT_HTTPS, ENTRYPOINT_TCP, ENTRYPOINT_UDP}, protocol) {
+		errs = append(errs, fmt.Errorf("unknown protocol %q, expected http, https, tcp or udp", ep.Protocol))
+	} else if (protocol == ENTRYPOINT_TCP || protocol == ENTRYPOINT_UDP) && len(ep.Middlewares) > 0 {
+		errs = append(errs, fmt.Errorf("middlewares are not supported on %s entrypoints", protocol))
+	}
+	if ep.Address == "" {
+		if _, builtin := defaultAddresses[name]; !builtin {
+			errs = append(errs, fmt.Errorf("address is required"))
+		}
+	} else if _, _, err := net.SplitHostPort(ep.Address); err != nil {
+		errs = append(errs, fmt.Errorf("invalid address %q: %w", ep.Address, err))
+	}
+	for _, m := range ep.Middlewares {
+		if err := server.ValidateMiddleware(m); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if ep.ProxyProtocol != nil {
+		if protocol == ENTRYPOINT_UDP {
+			errs = append(errs, fmt.Errorf("proxy_protocol is not supported on udp entrypoints"))
+		} else if err := ep.ProxyProtocol.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("proxy_protocol: %w", err))
+		}
+	}
+	if ep.HealthPath != "" {
+		if protocol == ENTRYPOINT_TCP || protocol == ENTRYPOINT_UDP {
+			errs = append(errs, fmt.Errorf("health_path is not supported on %s entrypoints", protocol))
+		} else if !strings.HasPrefix(ep.HealthPath, "/") {
+			errs = append(errs, fmt.Errorf("health_path %q must start with /", ep.HealthPath))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("entrypoint %q: %w", name, errors.Join(errs...))
+	}
+	return nil
+}
+
+// ServeEntryPoint starts the listener of the named entrypoint. The address is bound
+// before returning so that a port already in use is reported to the caller, the https
+// entrypoints need cm to get their certificates.
+func ServeEntryPoint(name string, cm *domain.Manager) (*http.Server, error) {
+	name = strings.ToLower(name)
+	ep := entryPointFor(name)
+	if ep.Disabled {
+		return nil, fmt.Errorf("entrypoint %s is disabled", name)
+	}
+	if ep.Protocol == ENTRYPOINT_TCP || ep.Protocol == ENTRYPOINT_UDP {
+		return nil, fmt.Errorf("entrypoint %s is a %s entrypoint, see Serve%sEntryPoint", name, ep.Protocol, strings.ToUpper(ep.Protocol))
+	}
+	if ep.Address == "" {
+		return nil, fmt.Errorf("entrypoint %s is not configured", name)
+	}
+	return serveEntryPoint(name, ep, cm)
+}
+
+// ServeEntryPoints starts the enabled http and https entrypoints. The listeners started
+// are returned along with the bind errors of the others, the inherited listeners left
+// unused are closed, those of the tcp and udp entrypoints excepted.
+func ServeEntryPoints(cm *domain.Manager) (map[string]*http.Server, error) {
+	started := make(map[string]*http.Server)
+	var errs []error
+	for _, name := range entryPointsWith(ENTRYPOINT_HTTP, ENTRYPOINT_HTTPS) {
+		hs, err := ServeEntryPoint(name, cm)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		started[name] = hs
+	}
+	closeInherited(entryPointsWith(ENTRYPOINT_TCP, ENTRYPOINT_UDP))
+	return started, errors.Join(errs...)
+}
+
+func serveEntryPoint(name string, ep *EntryPoint, cm *domain.Manager) (*http.Server, error) {
+	hs := &http.Server{Addr: ep.Address}
+	ep.apply(hs)
+
+	tag := "[HTTP_SERVER]"
+	ln := takeInherited(name, ep.Address)
+	if ln == nil {
+		var err error
+		if ln, err = net.Listen("tcp", ep.Address); err != nil {
+			return nil, fmt.Errorf("entrypoint %s: %w", name, err)
+		}
+	}
+	boundListeners.Store(hs, ln)
+	ln = ep.accepting(ln)
+	if ep.Protocol == ENTRYPOINT_HTTPS {
+		tag = "[HTTPS_SERVER]"
+		if cm == nil {
+			ln.Close()
+			boundListeners.Delete(hs)
+			return nil, fmt.Errorf("entrypoint %s: no certificate manager for https", name)
+		}
+		hs.TLSConfig = &tls.Config{GetCertificate: cm.GetCertificate, MinVersion: tls.VersionTLS12}
+		ln = tls.NewListener(ln, hs.TLSConfig)
+	}
+	hs.Handler = track(hs, withHealthPath(ep.HealthPath, entryPointHandler(name, ep)))
+	// Publish the effective addr (e.g., 127.0.0.1:51327 when :0 was requested).
+	hs.Addr = ln.Addr().String()
+	events.Logf(events.LOG_INFO, "%s: Entrypoint %s (%s) listening on %s", tag, name, ep.Protocol, hs.Addr)
+	go func() {
+		if err := hs.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			events.Logf(events.LOG_ERROR, "%s: Entrypoint %s error: %v", tag, name, err)
+		}
+	}()
+	return hs, nil
+}
+
+// entryPointHandler serves the servers attached to the entrypoint behind its
+// middlewares, the plain http ones redirecting the ForceTLS servers.
+func entryPointHandler(name string, ep *EntryPoint) http.Handler {
+	redirectTLS := ep.Protocol != ENTRYPOINT_HTTPS
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveEntry(w, r, name, redirectTLS)
+	})
+	var middlewares []func(http.Handler) http.Handler
+	for _, m := range ep.Middlewares {
+		built, err := server.BuildMiddleware(m)
+		if err != nil {
+			events.Logf(events.LOG_ERROR, "[ROUTER]: Cannot build the %s middleware of the %s entrypoint: %v", m.Name, name, err)
+			built = misconfiguredMiddleware
+		}
+		middlewares = append(middlewares, built)
+	}
+	return server.ChainMiddleware(h, middlewares...)
+}
+
+// servesEntryPoint reports whether s is attached to the named entrypoint, the servers
+// listing no entrypoint are attached to all of them.
+func servesEntryPoint(s *server.Server, name string) bool {
+	if name == "" || s == nil || len(s.EntryPoints) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(s.EntryPoints, func(ep string) bool {
+		return strings.EqualFold(ep, name)
+	})
+}
@@ -0,0 +1,183 @@
+package router
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+func TestEntryPointFor(t *testing.T) {
+	prev := currentRouter
+	defer func() { currentRouter = prev }()
+
+	currentRouter = nil
+	if ep := entryPointFor(ENTRYPOINT_HTTP); ep.ReadHeaderTimeout != 10*time.Second || ep.MaxHeaderBytes != http.DefaultMaxHeaderBytes {
+		t.Fatalf("defaults without router: %+v", ep)
+	}
+
+	currentRouter = &RouterState{globalConfig: &Config{EntryPoints: map[string]*EntryPoint{
+		ENTRYPOINT_HTTPS: {WriteTimeout: 30 * time.Second, MaxHeaderBytes: 8 << 10},
+	}}}
+	hs := &http.Server{}
+	entryPointFor(ENTRYPOINT_HTTPS).apply(hs)
+	if hs.WriteTimeout != 30*time.Second || hs.MaxHeaderBytes != 8<<10 || hs.ReadHeaderTimeout != 10*time.Second || hs.IdleTimeout != 120*time.Second {
+		t.Fatalf("https entrypoint not merged over defaults: %+v", hs)
+	}
+	if ep := entryPointFor(ENTRYPOINT_HTTP); ep.WriteTimeout != 0 {
+		t.Fatalf("http entrypoint must keep the defaults: %+v", ep)
+	}
+}
+
+func TestServeEntryPoints(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer backend.Close()
+
+	rs := newTestRouter(t)
+	rs.globalConfig.EntryPoints = map[string]*EntryPoint{
+		ENTRYPOINT_HTTP:  {Disabled: true},
+		ENTRYPOINT_HTTPS: {Disabled: true},
+		"Internal": {Address: "127.0.0.1:0", Protocol: "http", Middlewares: []server.Middleware{
+			{Name: string(server.MogolyHeaders), Config: map[string]any{"response": map[string]any{"set": map[string]any{"X-Entrypoint": "internal"}}}},
+		}},
+		"public": {Address: "127.0.0.1:0", Protocol: "http"},
+	}
+	if err := rs.globalConfig.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	rs.AddServer(&server.Server{Name: "admin.test", URL: backend.URL, EntryPoints: []string{"internal"}})
+	rs.AddServer(&server.Server{Name: "www.test", URL: backend.URL})
+
+	started, err := ServeEntryPoints(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, hs := range started {
+			hs.Close()
+		}
+	}()
+	if names := slices.Sorted(maps.Keys(started)); !slices.Equal(names, []string{"internal", "public"}) {
+		t.Fatalf("started entrypoints %v", names)
+	}
+
+	get := func(entryPoint, host string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("GET", "http://"+started[entryPoint].Addr+"/", nil)
+		req.Host = host
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := get("internal", "admin.test"); resp.StatusCode != http.StatusNoContent || resp.Header.Get("X-Entrypoint") != "internal" {
+		t.Fatalf("internal entrypoint: %d %v", resp.StatusCode, resp.Header)
+	}
+	if resp := get("public", "admin.test"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("server attached to internal only answered %d on public", resp.StatusCode)
+	}
+	if resp := get("public", "www.test"); resp.StatusCode != http.StatusNoContent || resp.Header.Get("X-Entrypoint") != "" {
+		t.Fatalf("public entrypoint: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	// A port already in use is reported instead of exiting.
+	rs.globalConfig.EntryPoints["public"] = &EntryPoint{Address: started["internal"].Addr, Protocol: "http"}
+	if _, err := ServeEntryPoint("public", nil); err == nil || !strings.Contains(err.Error(), "entrypoint public") {
+		t.Fatalf("expected a bind error, got %v", err)
+	}
+	if hs, err := ServeHTTP(started["internal"].Addr); err == nil || hs != nil {
+		t.Fatalf("deprecated ServeHTTP returned %v, %v on a port in use", hs, err)
+	}
+}
+
+func TestConfigValidate_EntryPoints(t *testing.T) {
+	cf := &Config{
+		EntryPoints: map[string]*EntryPoint{
+			"internal": {Address: ":8080", Protocol: "ftp"},
+			"metrics":  {Address: "8081", Protocol: "http"},
+			"private":  {Protocol: "http"},
+			"dns":      {Address: ":53", Protocol: "udp", ProxyProtocol: &server.ProxyProtocolConfig{TrustedCIDRs: []string{"10.0.0.0/8"}}},
+			"lb":       {Address: ":8443", Protocol: "tcp", ProxyProtocol: &server.ProxyProtocolConfig{}},
+			"lb2":      {Address: ":8444", Protocol: "http", ProxyProtocol: &server.ProxyProtocolConfig{TrustedCIDRs: []string{"10.0.0.0/33"}}},
+			"probe":    {Address: ":8445", Protocol: "http", HealthPath: "healthz"},
+			"db":       {Address: ":5432", Protocol: "tcp", HealthPath: "/healthz"},
+		},
+		Servers: []*server.Server{{Name: "example.test", EntryPoints: []string{"missing"}, ProxyProtocol: 3}},
+	}
+	err := cf.Validate()
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("invalid entrypoints accepted: %v", err)
+	}
+	for _, want := range []string{
+		`"internal": unknown protocol`,
+		`"metrics": invalid address`,
+		`"private": address is required`,
+		`unknown or disabled entrypoint "missing"`,
+		`"dns": proxy_protocol is not supported on udp entrypoints`,
+		`"lb": proxy_protocol: trusted_cidrs is required`,
+		`"lb2": proxy_protocol: invalid trusted_cidrs entry "10.0.0.0/33"`,
+		`server "example.test": unknown proxy_protocol version 3`,
+		`"probe": health_path "healthz" must start with /`,
+		`"db": health_path is not supported on tcp entrypoints`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in %v", want, err)
+		}
+	}
+
+	cf = &Config{
+		EntryPoints: map[string]*EntryPoint{ENTRYPOINT_HTTP: {Address: ":8080"}},
+		Servers:     []*server.Server{{Name: "example.test", EntryPoints: []string{"HTTP", "https"}}},
+	}
+	if err := cf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServeEntryPoint_ProxyProtocol(t *testing.T) {
+	forwarded := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded <- r.Header.Get("X-Forwarded-For")
+	}))
+	defer backend.Close()
+
+	rs := newTestRouter(t)
+	rs.globalConfig.EntryPoints = map[string]*EntryPoint{
+		"lb": {Address: "127.0.0.1:0", Protocol: "http", ProxyProtocol: &server.ProxyProtocolConfig{TrustedCIDRs: []string{"127.0.0.1"}}},
+	}
+	rs.AddServer(&server.Server{Name: "www.test", URL: backend.URL})
+	hs, err := ServeEntryPoint("lb", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hs.Close()
+
+	conn, err := net.Dial("tcp", hs.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	fmt.Fprint(conn, "PROXY TCP4 203.0.113.7 10.0.0.1 51000 80\r\nGET / HTTP/1.1\r\nHost: www.test\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := <-forwarded; got != "203.0.113.7" {
+		t.Fatalf("backend saw the client %q, want the one of the PROXY header", got)
+	}
+}
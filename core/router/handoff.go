@@ -0,0 +1,135 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/DoniLite/Mogoly/core/events"
+)
+
+var (
+	// Bound TCP listeners of the entrypoint servers, by *http.Server.
+	boundListeners sync.Map
+
+	inheritedMu sync.Mutex
+	// Listeners received from a parent process, by entrypoint name.
+	inherited map[string]net.Listener
+	// Sockets of the udp entrypoints received from a parent process, by entrypoint name.
+	inheritedPackets map[string]net.PacketConn
+)
+
+// InheritListeners hands listeners received from a previous process, by entrypoint
+// name, to ServeEntryPoint which uses them instead of binding the address again: the
+// connections queued on them are not lost across an upgrade.
+func InheritListeners(listeners map[string]net.Listener) {
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+	inherited = make(map[string]net.Listener, len(listeners))
+	for name, ln := range listeners {
+		inherited[strings.ToLower(name)] = ln
+	}
+}
+
+// InheritPacketConns is InheritListeners for the sockets of the udp entrypoints.
+func InheritPacketConns(conns map[string]net.PacketConn) {
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+	inheritedPackets = make(map[string]net.PacketConn, len(conns))
+	for name, pc := range conns {
+		inheritedPackets[strings.ToLower(name)] = pc
+	}
+}
+
+// takeInheritedPacket is takeInherited for the sockets of the udp entrypoints.
+func takeInheritedPacket(name, address string) net.PacketConn {
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+	pc, ok := inheritedPackets[name]
+	if !ok {
+		return nil
+	}
+	delete(inheritedPackets, name)
+	if !boundTo(pc.LocalAddr(), address) {
+		events.Logf(events.LOG_INFO, "[ROUTER]: Entrypoint %s moved from %s to %s, closing the inherited socket", name, pc.LocalAddr(), address)
+		pc.Close()
+		return nil
+	}
+	return pc
+}
+
+// takeInherited returns the inherited listener of the entrypoint if it is bound to
+// address, an inherited listener bound elsewhere is closed.
+func takeInherited(name, address string) net.Listener {
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+	ln, ok := inherited[name]
+	if !ok {
+		return nil
+	}
+	delete(inherited, name)
+	if !boundTo(ln.Addr(), address) {
+		events.Logf(events.LOG_INFO, "[ROUTER]: Entrypoint %s moved from %s to %s, closing the inherited listener", name, ln.Addr(), address)
+		ln.Close()
+		return nil
+	}
+	return ln
+}
+
+// closeInherited closes the inherited listeners no entrypoint took, except those of the
+// entrypoints in keep which are started by another call.
+func closeInherited(keep []string) {
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+	for name, ln := range inherited {
+		if slices.Contains(keep, name) {
+			continue
+		}
+		events.Logf(events.LOG_INFO, "[ROUTER]: Closing the inherited listener of the %s entrypoint, no longer served", name)
+		ln.Close()
+		delete(inherited, name)
+	}
+	for name, pc := range inheritedPackets {
+		if slices.Contains(keep, name) {
+			continue
+		}
+		events.Logf(events.LOG_INFO, "[ROUTER]: Closing the inherited socket of the %s entrypoint, no longer served", name)
+		pc.Close()
+		delete(inheritedPackets, name)
+	}
+}
+
+// boundTo reports whether addr is the one address resolves to, an empty host
+// matching the listeners bound to all the interfaces.
+func boundTo(addr net.Addr, address string) bool {
+	var ip net.IP
+	var port int
+	switch bound := addr.(type) {
+	case *net.TCPAddr:
+		ip, port = bound.IP, bound.Port
+	case *net.UDPAddr:
+		ip, port = bound.IP, bound.Port
+	default:
+		return false
+	}
+	want, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil || want.Port != port {
+		return false
+	}
+	if want.IP == nil || want.IP.IsUnspecified() {
+		return ip.IsUnspecified()
+	}
+	return want.IP.Equal(ip)
+}
+
+// EntryPointListener returns the TCP listener of the entrypoint server hs, the one
+// to pass to a new process, the TLS layer being added by the process serving it.
+func EntryPointListener(hs *http.Server) (net.Listener, error) {
+	if ln, ok := boundListeners.Load(hs); ok {
+		return ln.(net.Listener), nil
+	}
+	return nil, fmt.Errorf("no listener for the %s server", hs.Addr)
+}
@@ -0,0 +1,94 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+func TestInheritListeners(t *testing.T) {
+	rs := newTestRouter(t)
+	rs.globalConfig.EntryPoints = map[string]*EntryPoint{
+		ENTRYPOINT_HTTP:  {Disabled: true},
+		ENTRYPOINT_HTTPS: {Disabled: true},
+	}
+	rs.mu.Lock()
+	rs.serverMap["app.test"] = &server.Server{Name: "app.test"}
+	rs.httpServerMap["app.test"] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	rs.publish()
+	rs.mu.Unlock()
+
+	listen := func() net.Listener {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ln
+	}
+	kept, moved, unused := listen(), listen(), listen()
+	rs.globalConfig.EntryPoints["internal"] = &EntryPoint{Address: kept.Addr().String(), Protocol: "http"}
+	rs.globalConfig.EntryPoints["metrics"] = &EntryPoint{Address: "127.0.0.1:0", Protocol: "http"}
+	InheritListeners(map[string]net.Listener{"Internal": kept, "metrics": moved, "legacy": unused})
+
+	started, err := ServeEntryPoints(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, hs := range started {
+			hs.Close()
+		}
+	}()
+
+	if got, err := EntryPointListener(started["internal"]); err != nil || got != kept {
+		t.Fatalf("internal entrypoint did not serve the inherited listener: %v %v", got, err)
+	}
+	req, _ := http.NewRequest("GET", "http://"+kept.Addr().String()+"/", nil)
+	req.Host = "app.test"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("inherited listener answered %d", resp.StatusCode)
+	}
+
+	if started["metrics"].Addr == moved.Addr().String() {
+		t.Fatal("listener bound to another address must not be reused")
+	}
+	for _, ln := range []net.Listener{moved, unused} {
+		if _, err := ln.Accept(); err == nil {
+			t.Fatalf("listener %s left open", ln.Addr())
+		}
+	}
+}
+
+func TestBoundTo(t *testing.T) {
+	any4 := &net.TCPAddr{IP: net.IPv4zero, Port: 8080}
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+	dns := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+	cases := []struct {
+		addr    net.Addr
+		address string
+		want    bool
+	}{
+		{any4, ":8080", true},
+		{any4, "0.0.0.0:8080", true},
+		{any4, ":8081", false},
+		{local, "127.0.0.1:8080", true},
+		{local, "0.0.0.0:8080", false},
+		{local, ":8080", false},
+		{dns, "127.0.0.1:53", true},
+		{dns, "127.0.0.1:54", false},
+	}
+	for _, c := range cases {
+		if got := boundTo(c.addr, c.address); got != c.want {
+			t.Errorf("boundTo(%s, %q) = %v, want %v", c.addr, c.address, got, c.want)
+		}
+	}
+}
@@ -1,10 +1,23 @@
 package router
 
 import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
 	"github.com/DoniLite/Mogoly/core/config"
+	"github.com/DoniLite/Mogoly/core/server"
 	"gopkg.in/yaml.v3"
 )
 
+// ErrInvalidConfig wraps the errors returned by Config.Validate.
+var ErrInvalidConfig = errors.New("invalid router config")
+
+// ErrNotFound wraps the errors of the lookups of unknown servers, handlers and services.
+var ErrNotFound = errors.New("not found")
+
 func MarshalConfig(config *Config) ([]byte, error) {
 	return yaml.Marshal(config)
 }
@@ -14,9 +27,121 @@ func UnmarshalConfig(data []byte) (*Config, error) {
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, err
 	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 	return &config, nil
 }
 
+// Validate rejects the servers using unknown middlewares, invalid middleware configs,
+// invalid host patterns, invalid routes, invalid tcp, udp or PROXY protocol configs or
+// unknown entrypoints, the servers attached to entrypoints of another kind, the udp entrypoints
+// targeting several servers, and the invalid entrypoints.
+func (cf *Config) Validate() error {
+	var errs []error
+	entryPoints := normalizeEntryPoints(cf.EntryPoints)
+	for _, name := range slices.Sorted(maps.Keys(entryPoints)) {
+		if err := entryPoints[name].validate(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	known := enabledEntryPoints(entryPoints)
+	udpTargets := make(map[string][]string)
+	for _, s := range cf.Servers {
+		if s == nil {
+			continue
+		}
+		if err := s.ValidateMiddlewares(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := s.ValidateProxyProtocol(); err != nil {
+			errs = append(errs, err)
+		}
+		if s.TCP != nil && s.UDP != nil {
+			errs = append(errs, fmt.Errorf("server %q: a server has either a tcp or a udp config", s.Name))
+		}
+		if s.TCP != nil {
+			if err := s.TCP.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("server %q: tcp: %w", s.Name, err))
+			}
+		}
+		if s.UDP != nil {
+			if err := s.UDP.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("server %q: udp: %w", s.Name, err))
+			}
+		}
+		if s.TLSRedirect != nil {
+			if err := s.TLSRedirect.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("server %q: tls_redirect: %w", s.Name, err))
+			}
+		}
+		for _, host := range s.Hosts {
+			if err := validateHostPattern(host); err != nil {
+				errs = append(errs, fmt.Errorf("server %q: %w", s.Name, err))
+			}
+		}
+		kind := serverKind(s)
+		for _, ep := range s.EntryPoints {
+			name := strings.ToLower(ep)
+			if !slices.Contains(known, name) {
+				errs = append(errs, fmt.Errorf("server %q: unknown or disabled entrypoint %q", s.Name, ep))
+			} else if protocol := protocolOf(entryPoints, name); kind == ENTRYPOINT_HTTP && entryPointKind(protocol) != kind {
+				errs = append(errs, fmt.Errorf("server %q: entrypoint %q is a %s entrypoint, only the servers with a %s config use it", s.Name, ep, protocol, protocol))
+			} else if entryPointKind(protocol) != kind {
+				errs = append(errs, fmt.Errorf("server %q: entrypoint %q serves %s, the servers with a %s config use %s entrypoints", s.Name, ep, protocol, kind, kind))
+			}
+		}
+		if kind == ENTRYPOINT_UDP {
+			for _, name := range known {
+				if protocolOf(entryPoints, name) == ENTRYPOINT_UDP && servesEntryPoint(s, name) {
+					udpTargets[name] = append(udpTargets[name], s.Name)
+				}
+			}
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(udpTargets)) {
+		if targets := udpTargets[name]; len(targets) > 1 {
+			errs = append(errs, fmt.Errorf("entrypoint %q: a udp entrypoint targets one server, got %s", name, strings.Join(targets, ", ")))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, errors.Join(errs...))
+	}
+	return nil
+}
+
+// protocolOf returns the protocol of an entrypoint of the config, by lowercased name.
+func protocolOf(entryPoints map[string]*EntryPoint, name string) string {
+	if ep, ok := entryPoints[name]; ok && ep.Protocol != "" {
+		return strings.ToLower(ep.Protocol)
+	}
+	return name
+}
+
+// entryPointKind returns http for the http and https entrypoints, the protocol otherwise.
+func entryPointKind(protocol string) string {
+	if protocol == ENTRYPOINT_HTTPS {
+		return ENTRYPOINT_HTTP
+	}
+	return protocol
+}
+
+// serverKind returns the kind of entrypoints serving s: tcp, udp or http.
+func serverKind(s *server.Server) string {
+	switch {
+	case s.UDP != nil:
+		return ENTRYPOINT_UDP
+	case s.TCP != nil:
+		return ENTRYPOINT_TCP
+	}
+	return ENTRYPOINT_HTTP
+}
+
+// ConfigPath returns the path of the router config file, ~/.mogoly/router.yaml.
+func ConfigPath() (string, error) {
+	return config.ConfigFilePath(ROUTER_CONFIG_FILE)
+}
+
 func LoadConfig() (*Config, error) {
 	data, err := config.LoadConfigFile(ROUTER_CONFIG_FILE)
 	if err != nil {
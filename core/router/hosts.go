@@ -0,0 +1,115 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/DoniLite/Mogoly/core/events"
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+// hostIndex resolves the Host header of a request to the key of the server answering it.
+// Exact hosts always win over wildcards, the longest wildcard suffix wins between wildcards.
+type hostIndex struct {
+	exact     map[string]string
+	wildcards []wildcardHost
+}
+
+type wildcardHost struct {
+	suffix string // `.app.test` for `*.app.test`
+	key    string
+}
+
+// normalizeHost lowercases host and strips its port and trailing dot.
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// validateHostPattern accepts plain hostnames and a leading `*.` wildcard label.
+func validateHostPattern(pattern string) error {
+	host := normalizeHost(pattern)
+	if host == "" {
+		return fmt.Errorf("empty host")
+	}
+	rest := strings.TrimPrefix(host, "*.")
+	if strings.Contains(rest, "*") {
+		return fmt.Errorf("invalid host %q: only a leading `*.` wildcard is supported", pattern)
+	}
+	if rest == "" {
+		return fmt.Errorf("invalid host %q: a wildcard needs a domain", pattern)
+	}
+	return nil
+}
+
+// serverHosts returns the normalized hosts answered by s, its name first.
+func serverHosts(s *server.Server) []string {
+	hosts := make([]string, 0, len(s.Hosts)+1)
+	for _, h := range append([]string{s.Name}, s.Hosts...) {
+		if h = normalizeHost(h); h != "" && !slices.Contains(hosts, h) {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// buildHostIndex indexes the hosts of the servers keyed by their lowercase name. Servers
+// are visited in name order so that a host claimed twice always goes to the same one.
+func buildHostIndex(servers map[string]*server.Server) *hostIndex {
+	idx := &hostIndex{exact: make(map[string]string)}
+	keys := make([]string, 0, len(servers))
+	for key := range servers {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	claimed := make(map[string]string)
+	for _, key := range keys {
+		for _, host := range serverHosts(servers[key]) {
+			if owner, exists := claimed[host]; exists {
+				if owner != key {
+					events.Logf(events.LOG_ERROR, "[ROUTER]: Host %s of the %s server is already served by %s, ignoring it", host, key, owner)
+				}
+				continue
+			}
+			if err := validateHostPattern(host); err != nil {
+				events.Logf(events.LOG_ERROR, "[ROUTER]: Ignoring host of the %s server: %v", key, err)
+				continue
+			}
+			claimed[host] = key
+			if suffix, ok := strings.CutPrefix(host, "*"); ok {
+				idx.wildcards = append(idx.wildcards, wildcardHost{suffix: suffix, key: key})
+				continue
+			}
+			idx.exact[host] = key
+		}
+	}
+	slices.SortStableFunc(idx.wildcards, func(a, b wildcardHost) int {
+		return len(b.suffix) - len(a.suffix)
+	})
+	return idx
+}
+
+// lookup returns the key of the server answering host.
+func (idx *hostIndex) lookup(host string) (string, bool) {
+	if idx == nil {
+		return "", false
+	}
+	host = normalizeHost(host)
+	if key, ok := idx.exact[host]; ok {
+		return key, true
+	}
+	for _, w := range idx.wildcards {
+		if len(host) > len(w.suffix) && strings.HasSuffix(host, w.suffix) {
+			return w.key, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,80 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+func TestHostIndexLookup(t *testing.T) {
+	idx := buildHostIndex(map[string]*server.Server{
+		"app.test":     {Name: "App.test", Hosts: []string{"www.app.test", "*.app.test"}},
+		"admin":        {Name: "admin", Hosts: []string{"admin.app.test"}},
+		"eu":           {Name: "eu", Hosts: []string{"*.eu.app.test"}},
+		"duplicate":    {Name: "duplicate", Hosts: []string{"www.app.test"}},
+		"ipv6.backend": {Name: "ipv6.backend", Hosts: []string{"[::1]"}},
+	})
+
+	cases := map[string]string{
+		"app.test":            "app.test",
+		"APP.test:8080":       "app.test",
+		"www.app.test.":       "app.test",
+		"admin.app.test:443":  "admin",
+		"api.app.test":        "app.test",
+		"a.b.app.test":        "app.test",
+		"shop.eu.app.test:80": "eu",
+		"[::1]:8443":          "ipv6.backend",
+		"ipv6.backend":        "ipv6.backend",
+		"eu.app.test":         "app.test",
+		"other.test":          "",
+		"xapp.test":           "",
+		"":                    "",
+		"duplicate":           "duplicate",
+	}
+	for host, want := range cases {
+		got, ok := idx.lookup(host)
+		if got != want || ok != (want != "") {
+			t.Errorf("lookup(%q) = %q, %v; want %q", host, got, ok, want)
+		}
+	}
+}
+
+func TestRouteHandler_Hosts(t *testing.T) {
+	prev := currentRouter
+	defer func() { currentRouter = prev }()
+
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name))
+		})
+	}
+	rs := &RouterState{
+		serverMap: map[string]*server.Server{
+			"web":   {Name: "web", Hosts: []string{"*.web.test"}},
+			"admin": {Name: "admin", Hosts: []string{"admin.web.test"}},
+		},
+		httpServerMap: map[string]http.Handler{"web": named("web"), "admin": named("admin")},
+	}
+	rs.publish()
+	currentRouter = rs
+
+	for host, want := range map[string]string{"admin.web.test:8080": "admin", "blog.web.test": "web"} {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = host
+		routeHandler(rr, req)
+		if rr.Body.String() != want {
+			t.Fatalf("%s: served by %q, want %q", host, rr.Body.String(), want)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "web.test"
+	routeHandler(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("wildcard must not match its bare domain, got %d", rr.Code)
+	}
+}
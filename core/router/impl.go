@@ -1,10 +1,14 @@
 package router
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
+	"slices"
 	"strings"
+	"sync/atomic"
 
 	"github.com/DoniLite/Mogoly/cloud"
 	"github.com/DoniLite/Mogoly/core/config"
@@ -22,11 +26,10 @@ const (
 func (rs *RouterState) AddServer(server *server.Server) {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
-	if _, exists := rs.serverMap[strings.ToLower(server.Name)]; exists {
+	if !rs.addServer(server) {
 		return
 	}
-	rs.serverMap[strings.ToLower(server.Name)] = server
-	rs.httpServerMap[strings.ToLower(server.Name)] = CreateSingleHttpServer(server)
+	rs.publish()
 
 	err := rs.globalConfig.PersistConfig()
 	if err != nil {
@@ -34,6 +37,22 @@ func (rs *RouterState) AddServer(server *server.Server) {
 	}
 }
 
+// addServer adds the server to the router and its config unless it is already known,
+// the caller holds rs.mu and publishes the routing table.
+func (rs *RouterState) addServer(server *server.Server) bool {
+	if _, exists := rs.serverMap[strings.ToLower(server.Name)]; exists {
+		return false
+	}
+	rs.serverMap[strings.ToLower(server.Name)] = server
+	rs.httpServerMap[strings.ToLower(server.Name)] = CreateSingleHttpServer(server)
+	// Keeping the persisted config in sync, a reload of the file would drop the server otherwise.
+	if rs.globalConfig == nil {
+		rs.globalConfig = &Config{}
+	}
+	rs.globalConfig.Servers = append(rs.globalConfig.Servers, server)
+	return true
+}
+
 func (rs *RouterState) RemoveServer(server *server.Server) {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
@@ -42,6 +61,10 @@ func (rs *RouterState) RemoveServer(server *server.Server) {
 	}
 	delete(rs.httpServerMap, strings.ToLower(server.Name))
 	delete(rs.serverMap, strings.ToLower(server.Name))
+	rs.publish()
+	if rs.globalConfig != nil {
+		rs.globalConfig.Servers = withoutServer(rs.globalConfig.Servers, server.Name)
+	}
 
 	err := rs.globalConfig.PersistConfig()
 	if err != nil {
@@ -49,22 +72,28 @@ func (rs *RouterState) RemoveServer(server *server.Server) {
 	}
 }
 
+func withoutServer(servers []*server.Server, name string) []*server.Server {
+	return slices.DeleteFunc(slices.Clone(servers), func(s *server.Server) bool {
+		return s == nil || strings.EqualFold(s.Name, name)
+	})
+}
+
 func (rs *RouterState) GetServer(name string) (*server.Server, error) {
 	rs.mu.RLock()
 	defer rs.mu.RUnlock()
 	if _, exists := rs.serverMap[strings.ToLower(name)]; !exists {
-		return nil, fmt.Errorf("server %s not found", name)
+		return nil, fmt.Errorf("server %s %w", name, ErrNotFound)
 	}
 	return rs.serverMap[strings.ToLower(name)], nil
 }
 
+// GetHandler returns the handler of the server from the published routing table.
 func (rs *RouterState) GetHandler(name string) (http.Handler, error) {
-	rs.mu.RLock()
-	defer rs.mu.RUnlock()
-	if _, exists := rs.httpServerMap[strings.ToLower(name)]; !exists {
-		return nil, fmt.Errorf("handler %s not found", name)
+	h, exists := rs.routing().handlers[strings.ToLower(name)]
+	if !exists {
+		return nil, fmt.Errorf("handler %s %w", name, ErrNotFound)
 	}
-	return rs.httpServerMap[strings.ToLower(name)], nil
+	return h, nil
 }
 
 func (rs *RouterState) ListServers() []*server.Server {
@@ -85,19 +114,27 @@ func (rs *RouterState) AddService(service *cloud.ServiceConfig) {
 	if _, exists := rs.cloudMap[strings.ToLower(service.Name)]; exists {
 		return
 	}
-	events.Logf(events.LOG_INFO, "[ROUTER]: Creating service instance for %s", service.Name)
+	// Without a manager the service is only recorded, as the reconciliation does.
+	if rs.serviceManager != nil {
+		events.Logf(events.LOG_INFO, "[ROUTER]: Creating service instance for %s", service.Name)
 
-	currentService, err := rs.serviceManager.CreateInstance(*service)
-	if err != nil {
-		events.Logf(events.LOG_ERROR, "[ROUTER]: Error while creating service instance for %s: %v", service.Name, err)
-		return
+		currentService, err := rs.serviceManager.CreateInstance(*service)
+		if err != nil {
+			events.Logf(events.LOG_ERROR, "[ROUTER]: Error while creating service instance for %s: %v", service.Name, err)
+			return
+		}
+		rs.cloudServiceInstanceMap[strings.ToLower(service.Name)] = currentService
+		events.Logf(events.LOG_INFO, "[ROUTER]: Service instance for %s created successfully", service.Name)
 	}
 	rs.cloudMap[strings.ToLower(service.Name)] = service
-	rs.cloudServiceInstanceMap[strings.ToLower(service.Name)] = currentService
-
-	events.Logf(events.LOG_INFO, "[ROUTER]: Service instance for %s created successfully", service.Name)
+	rs.publish()
+	// Keeping the persisted config in sync, a reload of the file would delete the instance otherwise.
+	if rs.globalConfig == nil {
+		rs.globalConfig = &Config{}
+	}
+	rs.globalConfig.Services = withService(rs.globalConfig.Services, service)
 
-	err = rs.globalConfig.PersistConfig()
+	err := rs.globalConfig.PersistConfig()
 	if err != nil {
 		events.Logf(events.LOG_ERROR, "[ROUTER]: Error while persisting router config: %v", err)
 	}
@@ -112,19 +149,19 @@ func (rs *RouterState) RemoveService(service *cloud.ServiceConfig) {
 
 	events.Logf(events.LOG_INFO, "[ROUTER]: Removing service instance for %s", service.Name)
 
-	serviceInstance, exists := rs.cloudServiceInstanceMap[strings.ToLower(service.Name)]
-	if !exists {
-		return
-	}
-
-	if err := rs.serviceManager.DeleteInstance(serviceInstance.ID); err != nil {
-		events.Logf(events.LOG_ERROR, "[ROUTER]: Error while deleting service instance for %s: %v", service.Name, err)
-		return
+	if serviceInstance, exists := rs.cloudServiceInstanceMap[strings.ToLower(service.Name)]; exists && rs.serviceManager != nil {
+		if err := rs.serviceManager.DeleteInstance(serviceInstance.ID); err != nil {
+			events.Logf(events.LOG_ERROR, "[ROUTER]: Error while deleting service instance for %s: %v", service.Name, err)
+			return
+		}
+		events.Logf(events.LOG_INFO, "[ROUTER]: Service instance for %s deleted successfully", service.Name)
 	}
 	delete(rs.cloudServiceInstanceMap, strings.ToLower(service.Name))
 	delete(rs.cloudMap, strings.ToLower(service.Name))
-
-	events.Logf(events.LOG_INFO, "[ROUTER]: Service instance for %s deleted successfully", service.Name)
+	rs.publish()
+	if rs.globalConfig != nil {
+		rs.globalConfig.Services = withoutService(rs.globalConfig.Services, service.Name)
+	}
 
 	err := rs.globalConfig.PersistConfig()
 	if err != nil {
@@ -132,11 +169,23 @@ func (rs *RouterState) RemoveService(service *cloud.ServiceConfig) {
 	}
 }
 
+// withService replaces the service of the same name in services, or appends it.
+func withService(services []*cloud.ServiceConfig, service *cloud.ServiceConfig) []*cloud.ServiceConfig {
+	services = withoutService(services, service.Name)
+	return append(services, service)
+}
+
+func withoutService(services []*cloud.ServiceConfig, name string) []*cloud.ServiceConfig {
+	return slices.DeleteFunc(slices.Clone(services), func(s *cloud.ServiceConfig) bool {
+		return s == nil || strings.EqualFold(s.Name, name)
+	})
+}
+
 func (rs *RouterState) GetService(name string) (*cloud.ServiceConfig, error) {
 	rs.mu.RLock()
 	defer rs.mu.RUnlock()
 	if _, exists := rs.cloudMap[strings.ToLower(name)]; !exists {
-		return nil, fmt.Errorf("service %s not found", name)
+		return nil, fmt.Errorf("service %s %w", name, ErrNotFound)
 	}
 	return rs.cloudMap[strings.ToLower(name)], nil
 }
@@ -145,7 +194,7 @@ func (rs *RouterState) GetServiceInstance(name string) (*cloud.ServiceInstance,
 	rs.mu.RLock()
 	defer rs.mu.RUnlock()
 	if _, exists := rs.cloudServiceInstanceMap[strings.ToLower(name)]; !exists {
-		return nil, fmt.Errorf("service instance %s not found", name)
+		return nil, fmt.Errorf("service instance %s %w", name, ErrNotFound)
 	}
 	return rs.cloudServiceInstanceMap[strings.ToLower(name)], nil
 }
@@ -153,11 +202,11 @@ func (rs *RouterState) GetServiceInstance(name string) (*cloud.ServiceInstance,
 func (rs *RouterState) RecreateInstanceWithDomain(id string, domain *cloud.DomainConfig) error {
 	instance, isAvailable := rs.serviceManager.GetInstance(id)
 	if !isAvailable {
-		return fmt.Errorf("service instance %s not found", id)
+		return fmt.Errorf("service instance %s %w", id, ErrNotFound)
 	}
 
 	if _, exists := rs.cloudServiceInstanceMap[strings.ToLower(instance.Name)]; !exists {
-		return fmt.Errorf("service instance %s not found", instance.Name)
+		return fmt.Errorf("service instance %s %w", instance.Name, ErrNotFound)
 	}
 
 	events.Logf(events.LOG_INFO, "[ROUTER]: Recreating service instance %s with domain %s", id, domain.Domain)
@@ -167,11 +216,18 @@ func (rs *RouterState) RecreateInstanceWithDomain(id string, domain *cloud.Domai
 		return err
 	}
 
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
 	config := rs.cloudMap[strings.ToLower(service.Name)]
 	config.Domain = domain
 
 	rs.cloudServiceInstanceMap[strings.ToLower(service.Name)] = service
 	rs.cloudMap[strings.ToLower(service.Name)] = config
+	rs.publish()
+	if rs.globalConfig == nil {
+		rs.globalConfig = &Config{}
+	}
+	rs.globalConfig.Services = withService(rs.globalConfig.Services, config)
 
 	events.Logf(events.LOG_INFO, "[ROUTER]: Service instance for %s recreated successfully", service.Name)
 
@@ -220,8 +276,27 @@ func (rs *RouterState) StreamInstanceLogs(id string) (io.Reader, error) {
 
 // Config
 
+// GetConfig returns a copy of the running config taken under the router lock, its
+// servers and services are the ones of the router.
 func (rs *RouterState) GetConfig() *Config {
-	return rs.globalConfig
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	if rs.globalConfig == nil {
+		return nil
+	}
+	cf := *rs.globalConfig
+	cf.Servers = slices.Clone(cf.Servers)
+	cf.Services = slices.Clone(cf.Services)
+	cf.Variables = maps.Clone(cf.Variables)
+	cf.EntryPoints = maps.Clone(cf.EntryPoints)
+	return &cf
+}
+
+// PersistConfig writes the running config under the router lock.
+func (rs *RouterState) PersistConfig() error {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.globalConfig.PersistConfig()
 }
 
 func (cf *Config) BuildVars() {
@@ -243,5 +318,17 @@ func (cf *Config) PersistConfig() error {
 		return err
 	}
 	events.Logf(events.LOG_INFO, "[ROUTER]: Persisting router config")
+	digest := sha256.Sum256(configBytes)
+	persistedDigest.Store(&digest)
 	return config.WriteIntoConfigFile(ROUTER_CONFIG_FILE, configBytes)
 }
+
+// persistedDigest is the digest of the last config written by PersistConfig.
+var persistedDigest atomic.Pointer[[sha256.Size]byte]
+
+// IsPersistedConfig reports whether content is the config last written by PersistConfig,
+// the config watcher uses it to skip the writes of the router itself.
+func IsPersistedConfig(content []byte) bool {
+	digest := persistedDigest.Load()
+	return digest != nil && *digest == sha256.Sum256(content)
+}
@@ -0,0 +1,292 @@
+package router
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/DoniLite/Mogoly/cloud"
+	"github.com/DoniLite/Mogoly/core/config"
+	"github.com/DoniLite/Mogoly/core/events"
+	"github.com/DoniLite/Mogoly/core/server"
+	"gopkg.in/yaml.v3"
+)
+
+// ReconcileSummary lists the changes applied by Reconcile.
+type ReconcileSummary struct {
+	AddedServers     []string
+	UpdatedServers   []string
+	RemovedServers   []string
+	AddedServices    []string
+	UpdatedServices  []string
+	RemovedServices  []string
+	ChangedVariables []string
+	EntryPoints      bool // The entrypoint settings changed, they apply to the next started listeners
+}
+
+func (s *ReconcileSummary) Empty() bool {
+	return len(s.AddedServers)+len(s.UpdatedServers)+len(s.RemovedServers)+
+		len(s.AddedServices)+len(s.UpdatedServices)+len(s.RemovedServices)+
+		len(s.ChangedVariables) == 0 && !s.EntryPoints
+}
+
+func (s *ReconcileSummary) String() string {
+	if s.Empty() {
+		return "no changes"
+	}
+	var parts []string
+	add := func(label string, names []string) {
+		if len(names) > 0 {
+			parts = append(parts, fmt.Sprintf("%s %v", label, names))
+		}
+	}
+	add("servers added", s.AddedServers)
+	add("servers updated", s.UpdatedServers)
+	add("servers removed", s.RemovedServers)
+	add("services added", s.AddedServices)
+	add("services updated", s.UpdatedServices)
+	add("services removed", s.RemovedServices)
+	add("variables changed", s.ChangedVariables)
+	if s.EntryPoints {
+		parts = append(parts, "entrypoints changed")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ReconcileConfig applies desired to the current router, see RouterState.Reconcile.
+func ReconcileConfig(desired *Config) (*ReconcileSummary, error) {
+	rs, err := GetRouter()
+	if err != nil {
+		return nil, err
+	}
+	return rs.Reconcile(desired)
+}
+
+// Reconcile makes the router match desired: servers and services missing from it are
+// removed, new ones are added and the changed ones are rebuilt, the unchanged ones keep
+// their handlers, proxies and health state. An invalid desired config changes nothing.
+func (rs *RouterState) Reconcile(desired *Config) (*ReconcileSummary, error) {
+	if desired == nil {
+		return nil, fmt.Errorf("nil config")
+	}
+	if err := desired.Validate(); err != nil {
+		return nil, err
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	summary := &ReconcileSummary{}
+	current := rs.globalConfig
+	if current == nil {
+		current = &Config{}
+	}
+	next := &Config{
+		Servers:     make([]*server.Server, 0, len(desired.Servers)),
+		Services:    make([]*cloud.ServiceConfig, 0, len(desired.Services)),
+		Variables:   desired.Variables,
+		EntryPoints: desired.EntryPoints,
+	}
+
+	rs.reconcileServers(desired, next, summary)
+	rs.reconcileServices(desired, next, summary)
+
+	summary.ChangedVariables = reconcileVariables(current.Variables, desired.Variables)
+	summary.EntryPoints = (len(current.EntryPoints) > 0 || len(desired.EntryPoints) > 0) &&
+		!reflect.DeepEqual(current.EntryPoints, desired.EntryPoints)
+	if summary.EntryPoints {
+		events.Logf(events.LOG_INFO, "[ROUTER]: Entrypoint settings changed, they apply once the listeners are restarted")
+	}
+
+	rs.publish()
+	rs.globalConfig = next
+	if summary.Empty() {
+		events.Logf(events.LOG_INFO, "[ROUTER]: Config reconciled, no changes")
+		return summary, nil
+	}
+	events.Logf(events.LOG_INFO, "[ROUTER]: Config reconciled: %s", summary)
+	if err := next.PersistConfig(); err != nil {
+		events.Logf(events.LOG_ERROR, "[ROUTER]: Error while persisting router config: %v", err)
+	}
+	return summary, nil
+}
+
+func (rs *RouterState) reconcileServers(desired, next *Config, summary *ReconcileSummary) {
+	wanted := make(map[string]bool, len(desired.Servers))
+	for _, srv := range desired.Servers {
+		if srv == nil {
+			continue
+		}
+		key := strings.ToLower(srv.Name)
+		if wanted[key] {
+			events.Logf(events.LOG_ERROR, "[ROUTER]: Duplicated server %s in the config, keeping the first one", srv.Name)
+			continue
+		}
+		wanted[key] = true
+
+		live, exists := rs.serverMap[key]
+		switch {
+		case !exists:
+			summary.AddedServers = append(summary.AddedServers, srv.Name)
+		case serverSpec(live) == serverSpec(srv):
+			next.Servers = append(next.Servers, live)
+			continue
+		default:
+			inheritHealth(live, srv)
+			summary.UpdatedServers = append(summary.UpdatedServers, srv.Name)
+		}
+		rs.serverMap[key] = srv
+		rs.httpServerMap[key] = CreateSingleHttpServer(srv)
+		next.Servers = append(next.Servers, srv)
+	}
+	for _, key := range slices.Sorted(maps.Keys(rs.serverMap)) {
+		if wanted[key] {
+			continue
+		}
+		summary.RemovedServers = append(summary.RemovedServers, rs.serverMap[key].Name)
+		delete(rs.serverMap, key)
+		delete(rs.httpServerMap, key)
+	}
+}
+
+func (rs *RouterState) reconcileServices(desired, next *Config, summary *ReconcileSummary) {
+	wanted := make(map[string]bool, len(desired.Services))
+	for _, svc := range desired.Services {
+		if svc == nil {
+			continue
+		}
+		key := strings.ToLower(svc.Name)
+		if wanted[key] {
+			continue
+		}
+		wanted[key] = true
+
+		live, exists := rs.cloudMap[key]
+		if exists && specOf(live) == specOf(svc) {
+			next.Services = append(next.Services, live)
+			continue
+		}
+		if exists {
+			rs.deleteServiceInstance(key)
+			summary.UpdatedServices = append(summary.UpdatedServices, svc.Name)
+		} else {
+			summary.AddedServices = append(summary.AddedServices, svc.Name)
+		}
+		rs.cloudMap[key] = svc
+		next.Services = append(next.Services, svc)
+		if rs.serviceManager == nil {
+			continue
+		}
+		instance, err := rs.serviceManager.CreateInstance(*svc)
+		if err != nil {
+			events.Logf(events.LOG_ERROR, "[ROUTER]: Error while creating service instance for %s: %v", svc.Name, err)
+			continue
+		}
+		rs.cloudServiceInstanceMap[key] = instance
+	}
+	for _, key := range slices.Sorted(maps.Keys(rs.cloudMap)) {
+		if wanted[key] {
+			continue
+		}
+		summary.RemovedServices = append(summary.RemovedServices, rs.cloudMap[key].Name)
+		rs.deleteServiceInstance(key)
+		delete(rs.cloudMap, key)
+	}
+}
+
+func (rs *RouterState) deleteServiceInstance(key string) {
+	instance, exists := rs.cloudServiceInstanceMap[key]
+	if !exists || rs.serviceManager == nil {
+		return
+	}
+	if err := rs.serviceManager.DeleteInstance(instance.ID); err != nil {
+		events.Logf(events.LOG_ERROR, "[ROUTER]: Error while deleting service instance for %s: %v", instance.Name, err)
+	}
+	delete(rs.cloudServiceInstanceMap, key)
+}
+
+// reconcileVariables applies the variable changes to the environment and returns their names.
+func reconcileVariables(current, desired map[string]string) []string {
+	var changed []string
+	for _, key := range slices.Sorted(maps.Keys(desired)) {
+		if value, exists := current[key]; exists && value == desired[key] {
+			continue
+		}
+		if err := config.SetEnv(key, desired[key]); err != nil {
+			events.Logf(events.LOG_ERROR, "[ROUTER]: Error while setting environment variable %s: %v", key, err)
+		}
+		changed = append(changed, key)
+	}
+	for _, key := range slices.Sorted(maps.Keys(current)) {
+		if _, exists := desired[key]; exists {
+			continue
+		}
+		if err := config.UnsetEnv(key); err != nil {
+			events.Logf(events.LOG_ERROR, "[ROUTER]: Error while unsetting environment variable %s: %v", key, err)
+		}
+		changed = append(changed, key)
+	}
+	return changed
+}
+
+// Runtime fields left out when comparing two servers.
+var serverRuntimeKeys = []string{"id", "is_healthy", "lasthealthcheck"}
+
+// serverSpec returns the configured part of s in a comparable form.
+func serverSpec(s *server.Server) string {
+	raw, err := yaml.Marshal(s)
+	if err != nil {
+		return ""
+	}
+	var fields map[string]any
+	if err := yaml.Unmarshal(raw, &fields); err != nil {
+		return string(raw)
+	}
+	stripRuntimeKeys(fields)
+	return specOf(fields)
+}
+
+func stripRuntimeKeys(fields map[string]any) {
+	for _, key := range serverRuntimeKeys {
+		delete(fields, key)
+	}
+	balance, _ := fields["balance"].([]any)
+	for _, b := range balance {
+		if m, ok := b.(map[string]any); ok {
+			stripRuntimeKeys(m)
+		}
+	}
+}
+
+// specOf serializes v, map keys being sorted the output is stable.
+func specOf(v any) string {
+	raw, err := yaml.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(raw)
+}
+
+// inheritHealth copies the health state of the balancing servers kept by an update,
+// so that they are not considered down until the next health check.
+func inheritHealth(old, updated *server.Server) {
+	previous := make(map[string]*server.Server, len(old.BalancingServers))
+	for _, bs := range old.BalancingServers {
+		if bs != nil {
+			previous[bs.Name] = bs
+		}
+	}
+	for _, bs := range updated.BalancingServers {
+		if bs == nil {
+			continue
+		}
+		if prev, ok := previous[bs.Name]; ok {
+			bs.IsHealthy = prev.IsHealthy
+			bs.LastHealthCheck = prev.LastHealthCheck
+		}
+	}
+	updated.IsHealthy = old.IsHealthy
+	updated.LastHealthCheck = old.LastHealthCheck
+}
@@ -0,0 +1,227 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DoniLite/Mogoly/cloud"
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+func TestReconcile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	web := &server.Server{Name: "web.test", URL: "http://127.0.0.1:9001", BalancingServers: []*server.Server{
+		{Name: "web-1", URL: "http://127.0.0.1:9001", IsHealthy: true},
+	}}
+	api := &server.Server{Name: "api.test", URL: "http://127.0.0.1:9002"}
+	old := &server.Server{Name: "old.test", URL: "http://127.0.0.1:9003"}
+	rs := &RouterState{
+		serverMap:               make(map[string]*server.Server),
+		httpServerMap:           make(map[string]http.Handler),
+		cloudMap:                make(map[string]*cloud.ServiceConfig),
+		cloudServiceInstanceMap: make(map[string]*cloud.ServiceInstance),
+		globalConfig:            &Config{Servers: []*server.Server{web, api, old}, Variables: map[string]string{"RECONCILE_KEEP": "1", "RECONCILE_DROP": "1"}},
+	}
+	for _, s := range rs.globalConfig.Servers {
+		rs.serverMap[s.Name] = s
+		rs.httpServerMap[s.Name] = CreateSingleHttpServer(s)
+	}
+	rs.publish()
+	webHandler := rs.httpServerMap["web.test"]
+
+	desired, err := UnmarshalConfig([]byte(`
+server:
+  - name: web.test
+    url: http://127.0.0.1:9001
+    balance:
+      - name: web-1
+        url: http://127.0.0.1:9001
+  - name: api.test
+    url: http://127.0.0.1:9002
+    hosts: [api.example.test]
+    balance:
+      - name: api-1
+        url: http://127.0.0.1:9002
+  - name: new.test
+    url: http://127.0.0.1:9004
+variables:
+  RECONCILE_KEEP: "1"
+  RECONCILE_NEW: "2"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := rs.Reconcile(desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(summary.AddedServers, []string{"new.test"}) || !slices.Equal(summary.UpdatedServers, []string{"api.test"}) ||
+		!slices.Equal(summary.RemovedServers, []string{"old.test"}) || !slices.Equal(summary.ChangedVariables, []string{"RECONCILE_NEW", "RECONCILE_DROP"}) {
+		t.Fatalf("unexpected summary: %s", summary)
+	}
+
+	if rs.serverMap["web.test"] != web || reflect.ValueOf(rs.httpServerMap["web.test"]).Pointer() != reflect.ValueOf(webHandler).Pointer() {
+		t.Fatal("unchanged server was rebuilt")
+	}
+	if rs.serverMap["api.test"] == api || len(rs.serverMap["api.test"].BalancingServers) != 1 {
+		t.Fatal("updated server was not replaced")
+	}
+	if _, ok := rs.serverMap["old.test"]; ok {
+		t.Fatal("removed server still routed")
+	}
+	if key, ok := rs.routing().hosts.lookup("api.example.test"); !ok || key != "api.test" {
+		t.Fatal("hosts not reindexed")
+	}
+	if _, ok := os.LookupEnv("MOGOLY_RECONCILE_DROP"); ok {
+		t.Fatal("removed variable still set")
+	}
+	if len(rs.globalConfig.Servers) != 3 || rs.globalConfig.Servers[0] != web {
+		t.Fatalf("config not replaced: %+v", rs.globalConfig.Servers)
+	}
+
+	if summary, err := rs.Reconcile(desired); err != nil || !summary.Empty() {
+		t.Fatalf("second reconcile must be a no-op: %v %v", summary, err)
+	}
+
+	invalid := &Config{Servers: []*server.Server{{Name: "broken.test", Middlewares: []server.Middleware{{Name: "nope"}}}}}
+	if _, err := rs.Reconcile(invalid); err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	if _, ok := rs.serverMap["web.test"]; !ok {
+		t.Fatal("invalid config must leave the router untouched")
+	}
+}
+
+func TestAddRemoveServer_KeepConfigInSync(t *testing.T) {
+	rs := newTestRouter(t)
+	s := &server.Server{Name: "Sync.test", URL: "http://127.0.0.1:9005"}
+
+	rs.AddServer(s)
+	if len(rs.globalConfig.Servers) != 1 || rs.globalConfig.Servers[0] != s {
+		t.Fatalf("added server missing from the config: %+v", rs.globalConfig.Servers)
+	}
+	// Reconciling with the persisted file must keep the server.
+	persisted, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary, err := rs.Reconcile(persisted); err != nil || !summary.Empty() {
+		t.Fatalf("reloading the persisted config changed the router: %v %v", summary, err)
+	}
+
+	rs.RemoveServer(&server.Server{Name: "sync.test"})
+	if len(rs.globalConfig.Servers) != 0 {
+		t.Fatalf("removed server still in the config: %+v", rs.globalConfig.Servers)
+	}
+}
+
+func TestAddRemoveService_KeepConfigInSync(t *testing.T) {
+	rs := newTestRouter(t)
+	svc := &cloud.ServiceConfig{Name: "Cache", Type: cloud.Redis}
+
+	rs.AddService(svc)
+	if len(rs.globalConfig.Services) != 1 || rs.globalConfig.Services[0] != svc {
+		t.Fatalf("added service missing from the config: %+v", rs.globalConfig.Services)
+	}
+	// Reconciling with the persisted file must keep the service and its instance.
+	persisted, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary, err := rs.Reconcile(persisted); err != nil || !summary.Empty() {
+		t.Fatalf("reloading the persisted config changed the router: %v %v", summary, err)
+	}
+
+	rs.RemoveService(&cloud.ServiceConfig{Name: "cache"})
+	if len(rs.globalConfig.Services) != 0 {
+		t.Fatalf("removed service still in the config: %+v", rs.globalConfig.Services)
+	}
+	if _, err := rs.GetService("cache"); err == nil {
+		t.Fatal("removed service still served")
+	}
+}
+
+func TestMergeRouterConfigs(t *testing.T) {
+	rs := newTestRouter(t)
+	existing := &server.Server{Name: "app.test", URL: "http://127.0.0.1:9006"}
+	rs.AddServer(existing)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			entryPointFor("internal")
+		}
+	}()
+	conf := MergeRouterConfigs(&Config{
+		Servers:     []*server.Server{{Name: "App.test", URL: "http://127.0.0.1:9007"}, {Name: "api.test", URL: "http://127.0.0.1:9008"}},
+		EntryPoints: map[string]*EntryPoint{"internal": {Address: ":9000", Protocol: "http"}},
+		Variables:   map[string]string{"MOGOLY_MERGE_TEST": "1"},
+	})
+	<-done
+
+	if len(conf.Servers) != 2 || conf.Servers[0] != existing || conf.EntryPoints["internal"] == nil || conf.Variables["MOGOLY_MERGE_TEST"] != "1" {
+		t.Fatalf("unexpected merged config: %+v", conf)
+	}
+	if _, err := rs.GetHandler("api.test"); err != nil {
+		t.Fatalf("merged server not served: %v", err)
+	}
+}
+
+func TestGetConfig_ConcurrentChanges(t *testing.T) {
+	rs := newTestRouter(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 50 {
+			s := &server.Server{Name: fmt.Sprintf("app%d.test", i), URL: "http://127.0.0.1:9006"}
+			rs.AddServer(s)
+			rs.RemoveServer(s)
+		}
+	}()
+	for range 50 {
+		if _, err := MarshalConfig(rs.GetConfig()); err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.PersistConfig(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	cf := rs.GetConfig()
+	cf.Servers = append(cf.Servers, &server.Server{Name: "copy.test"})
+	if _, err := rs.GetServer("copy.test"); err == nil || len(rs.GetConfig().Servers) != 0 {
+		t.Fatal("editing the returned config changed the router")
+	}
+}
+
+func TestStartup_InvalidConfig(t *testing.T) {
+	rs := newTestRouter(t)
+	path, err := ConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := os.WriteFile(path, []byte("entrypoints:\n  internal:\n    address: \":8080\"\n    protocol: ftp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Startup(nil); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("invalid %s must be returned, got %v", ROUTER_CONFIG_FILE, err)
+	}
+	if currentRouter != rs {
+		t.Fatal("invalid config must not replace the router")
+	}
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "ftp") {
+		t.Fatalf("invalid config overwritten: %s", data)
+	}
+}
@@ -0,0 +1,178 @@
+package router
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/DoniLite/Mogoly/core/events"
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+type compiledRoute struct {
+	label     string
+	priority  int
+	prefix    string
+	pathRegex *regexp.Regexp
+	methods   []string
+	headers   map[string]*regexp.Regexp
+	query     map[string]*regexp.Regexp
+	handler   http.Handler
+}
+
+// routeTable dispatches the requests of a host between its routes, the requests
+// matching no route reach fallback.
+type routeTable struct {
+	routes   []*compiledRoute
+	fallback http.Handler
+}
+
+func newRouteTable(s *server.Server, fallback http.Handler) *routeTable {
+	rt := &routeTable{fallback: fallback}
+	for i, route := range s.Routes {
+		if route == nil {
+			continue
+		}
+		label := route.RouteLabel(i)
+		cr, err := compileRoute(route)
+		if err != nil {
+			// A route we cannot match would send its requests to the fallback backends.
+			events.Logf(events.LOG_ERROR, "[ROUTER]: Invalid route %s of the %s server: %v", label, s.Name, err)
+			cr = &compiledRoute{prefix: route.PathPrefix, handler: misconfiguredMiddleware(nil)}
+		}
+		cr.label, cr.priority = label, route.Priority
+
+		if cr.handler == nil {
+			target := fallback
+			if route.Server != "" && !strings.EqualFold(route.Server, s.Name) {
+				target = serverHandler(route.Server)
+			}
+			var middlewares []func(http.Handler) http.Handler
+			for _, m := range route.Middlewares {
+				built, err := server.BuildMiddleware(m)
+				if err != nil {
+					events.Logf(events.LOG_ERROR, "[ROUTER]: Cannot build the %s middleware of the route %s of the %s server: %v", m.Name, label, s.Name, err)
+					built = misconfiguredMiddleware
+				}
+				middlewares = append(middlewares, built)
+			}
+			cr.handler = server.ChainMiddleware(target, middlewares...)
+		}
+		rt.routes = append(rt.routes, cr)
+	}
+	slices.SortStableFunc(rt.routes, func(a, b *compiledRoute) int {
+		return b.priority - a.priority
+	})
+	events.Logf(events.LOG_INFO, "[ROUTER]: %d routes assigned to the %s server", len(rt.routes), s.Name)
+	return rt
+}
+
+func compileRoute(route *server.Route) (*compiledRoute, error) {
+	if err := route.Validate(); err != nil {
+		return nil, err
+	}
+	cr := &compiledRoute{prefix: route.PathPrefix}
+	if route.PathRegex != "" {
+		cr.pathRegex = regexp.MustCompile(route.PathRegex)
+	}
+	for _, m := range route.Methods {
+		cr.methods = append(cr.methods, strings.ToUpper(strings.TrimSpace(m)))
+	}
+	if len(route.Headers) > 0 {
+		cr.headers = make(map[string]*regexp.Regexp, len(route.Headers))
+		for name, pattern := range route.Headers {
+			cr.headers[http.CanonicalHeaderKey(name)] = optionalRegexp(pattern)
+		}
+	}
+	if len(route.Query) > 0 {
+		cr.query = make(map[string]*regexp.Regexp, len(route.Query))
+		for name, pattern := range route.Query {
+			cr.query[name] = optionalRegexp(pattern)
+		}
+	}
+	return cr, nil
+}
+
+// optionalRegexp compiles an already validated pattern, nil for the empty one.
+func optionalRegexp(pattern string) *regexp.Regexp {
+	if pattern == "" {
+		return nil
+	}
+	return regexp.MustCompile(pattern)
+}
+
+// serverHandler forwards to the handler of another server, looked up on each request
+// so that the target can be added or replaced after the route.
+func serverHandler(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rs, err := GetRouter()
+		if err != nil {
+			http.Error(w, "router not ready", http.StatusServiceUnavailable)
+			return
+		}
+		h, err := rs.GetHandler(name)
+		if err != nil {
+			events.Logf(events.LOG_ERROR, "[ROUTER]: Route target %s not found", name)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (rt *routeTable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var query url.Values
+	for _, route := range rt.routes {
+		if route.match(r, &query) {
+			route.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	rt.fallback.ServeHTTP(w, r)
+}
+
+func (cr *compiledRoute) match(r *http.Request, query *url.Values) bool {
+	if cr.prefix != "" && !hasPathPrefix(r.URL.Path, cr.prefix) {
+		return false
+	}
+	if cr.pathRegex != nil && !cr.pathRegex.MatchString(r.URL.Path) {
+		return false
+	}
+	if len(cr.methods) > 0 && !slices.Contains(cr.methods, r.Method) {
+		return false
+	}
+	for name, re := range cr.headers {
+		if !valuesMatch(r.Header.Values(name), re) {
+			return false
+		}
+	}
+	if len(cr.query) > 0 && *query == nil {
+		*query = r.URL.Query()
+	}
+	for name, re := range cr.query {
+		if !valuesMatch((*query)[name], re) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPathPrefix matches prefix on whole path segments.
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+func valuesMatch(values []string, re *regexp.Regexp) bool {
+	if len(values) == 0 {
+		return false
+	}
+	if re == nil {
+		return true
+	}
+	return slices.ContainsFunc(values, re.MatchString)
+}
@@ -0,0 +1,89 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+func TestRouteTable(t *testing.T) {
+	prev := currentRouter
+	defer func() { currentRouter = prev }()
+
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Served-By", name)
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+	rs := &RouterState{httpServerMap: map[string]http.Handler{
+		"api-lb":    named("api-lb"),
+		"api-v2":    named("api-v2"),
+		"canary-lb": named("canary-lb"),
+		"admin-lb":  named("admin-lb"),
+	}}
+	rs.publish()
+	currentRouter = rs
+
+	s := &server.Server{Name: "example.test", Routes: []*server.Route{
+		{Name: "api", PathPrefix: "/api", Server: "api-lb"},
+		{Name: "api-v2", PathRegex: `^/api/v2(/|$)`, Priority: 10, Server: "api-v2"},
+		{Name: "canary", PathPrefix: "/api", Headers: map[string]string{"X-Canary": ""}, Priority: 10, Server: "canary-lb"},
+		{Name: "admin-write", PathPrefix: "/admin/", Methods: []string{"post", "DELETE"}, Query: map[string]string{"token": "^[a-f0-9]+$"}, Server: "admin-lb",
+			Middlewares: []server.Middleware{{Name: string(server.MogolyHeaders), Config: map[string]any{"response": map[string]any{"set": map[string]any{"X-Route": "admin"}}}}}},
+		{Name: "missing", PathPrefix: "/gone", Server: "does-not-exist"},
+	}}
+	rt := newRouteTable(s, named("web-lb"))
+
+	cases := []struct {
+		method, target string
+		header         http.Header
+		want           string
+	}{
+		{"GET", "/", nil, "web-lb"},
+		{"GET", "/api", nil, "api-lb"},
+		{"GET", "/api/users", nil, "api-lb"},
+		{"GET", "/apiary", nil, "web-lb"},
+		{"GET", "/api/v2/users", nil, "api-v2"},
+		{"GET", "/api/users", http.Header{"X-Canary": {"1"}}, "canary-lb"},
+		{"POST", "/admin/users?token=abc123", nil, "admin-lb"},
+		{"GET", "/admin/users?token=abc123", nil, "web-lb"},
+		{"POST", "/admin/users?token=nope", nil, "web-lb"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.target, nil)
+		for k, v := range c.header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		rt.ServeHTTP(rr, req)
+		if got := rr.Header().Get("X-Served-By"); got != c.want {
+			t.Errorf("%s %s %v: served by %q, want %q", c.method, c.target, c.header, got, c.want)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	rt.ServeHTTP(rr, httptest.NewRequest("POST", "/admin/x?token=ff", nil))
+	if rr.Header().Get("X-Route") != "admin" {
+		t.Fatalf("route middlewares not applied: %v", rr.Header())
+	}
+
+	rr = httptest.NewRecorder()
+	rt.ServeHTTP(rr, httptest.NewRequest("GET", "/gone", nil))
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("missing target: expected 502, got %d", rr.Code)
+	}
+}
+
+func TestConfigValidate_Routes(t *testing.T) {
+	cf := &Config{Servers: []*server.Server{{Name: "example.test", Routes: []*server.Route{
+		{PathPrefix: "api"},
+		{PathRegex: "("},
+	}}}}
+	if err := cf.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("invalid routes accepted: %v", err)
+	}
+}
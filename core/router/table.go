@@ -0,0 +1,95 @@
+package router
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+// routingTable is the immutable view of the servers read by the request path. It is
+// rebuilt after every change and published atomically: requests never take rs.mu and
+// the in-flight ones keep serving with the table they started with.
+type routingTable struct {
+	servers  map[string]*server.Server
+	handlers map[string]http.Handler
+	hosts    *hostIndex
+	// The servers routed by TLS SNI on the tcp entrypoints, keyed like servers, and
+	// the routes of the cloud services having a domain
+	tcpServers map[string]*server.Server
+	sni        *hostIndex
+	// The servers targeted by the udp entrypoints, in key order
+	udpServers []*server.Server
+}
+
+var emptyRoutingTable = &routingTable{}
+
+// publish swaps the routing table for one built from the current maps, it must be
+// called with rs.mu held for writing once the maps are in their final state.
+func (rs *RouterState) publish() {
+	httpServers := make(map[string]*server.Server, len(rs.serverMap))
+	tcpServers := serviceRoutes(rs.cloudMap, rs.cloudServiceInstanceMap)
+	var udpServers []*server.Server
+	for _, key := range slices.Sorted(maps.Keys(rs.serverMap)) {
+		switch s := rs.serverMap[key]; {
+		case s.UDP != nil:
+			udpServers = append(udpServers, s)
+		case s.TCP != nil:
+			tcpServers[key] = s
+		default:
+			httpServers[key] = s
+		}
+	}
+	rs.table.Store(&routingTable{
+		servers:    httpServers,
+		handlers:   maps.Clone(rs.httpServerMap),
+		hosts:      buildHostIndex(httpServers),
+		tcpServers: tcpServers,
+		sni:        buildHostIndex(tcpServers),
+		udpServers: udpServers,
+	})
+}
+
+// routing returns the published routing table.
+func (rs *RouterState) routing() *routingTable {
+	if t := rs.table.Load(); t != nil {
+		return t
+	}
+	return emptyRoutingTable
+}
+
+func (t *routingTable) resolve(host string) (*server.Server, http.Handler, bool) {
+	key, ok := t.hosts.lookup(host)
+	if !ok {
+		return nil, nil, false
+	}
+	h, ok := t.handlers[key]
+	return t.servers[key], h, ok
+}
+
+// ResolveSNI returns the server routing the TLS connections for serverName on the
+// tcp entrypoints.
+func (rs *RouterState) ResolveSNI(serverName string) (*server.Server, bool) {
+	t := rs.routing()
+	key, ok := t.sni.lookup(serverName)
+	if !ok {
+		return nil, false
+	}
+	return t.tcpServers[key], true
+}
+
+// ResolveUDP returns the server targeted by the named udp entrypoint.
+func (rs *RouterState) ResolveUDP(entryPoint string) (*server.Server, bool) {
+	for _, s := range rs.routing().udpServers {
+		if servesEntryPoint(s, entryPoint) {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// ResolveHost returns the server answering the given Host header value.
+func (rs *RouterState) ResolveHost(host string) (*server.Server, http.Handler, bool) {
+	return rs.routing().resolve(host)
+}
@@ -0,0 +1,124 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/DoniLite/Mogoly/cloud"
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+func newTestRouter(t *testing.T) *RouterState {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	prev := currentRouter
+	t.Cleanup(func() { currentRouter = prev })
+
+	rs := &RouterState{
+		serverMap:               make(map[string]*server.Server),
+		httpServerMap:           make(map[string]http.Handler),
+		cloudMap:                make(map[string]*cloud.ServiceConfig),
+		cloudServiceInstanceMap: make(map[string]*cloud.ServiceInstance),
+		globalConfig:            &Config{},
+	}
+	rs.publish()
+	currentRouter = rs
+	return rs
+}
+
+func serveHost(host string) int {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = host
+	rr := httptest.NewRecorder()
+	routeHandler(rr, req)
+	return rr.Code
+}
+
+// Run with -race: requests are served while servers are added, removed and reconciled.
+func TestRoutingTable_ConcurrentReloads(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	rs := newTestRouter(t)
+	stable := &server.Server{Name: "stable.test", URL: backend.URL}
+	rs.AddServer(stable)
+
+	var wg sync.WaitGroup
+	var stop atomic.Bool
+	errs := make(chan error, 16)
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				if code := serveHost("stable.test"); code != http.StatusOK {
+					errs <- fmt.Errorf("stable host answered %d", code)
+					return
+				}
+				if code := serveHost("flapping.test:8080"); code != http.StatusOK && code != http.StatusNotFound {
+					errs <- fmt.Errorf("flapping host answered %d", code)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := range 50 {
+		flapping := &server.Server{Name: "flapping.test", URL: backend.URL}
+		rs.AddServer(flapping)
+		rs.RemoveServer(flapping)
+		if _, err := rs.Reconcile(&Config{Servers: []*server.Server{
+			{Name: "stable.test", URL: backend.URL},
+			{Name: "flapping.test", URL: backend.URL, Hosts: []string{fmt.Sprintf("alias-%d.test", i)}},
+		}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stop.Store(true)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestRoutingTable_InFlightRequestsKeepTheirTable(t *testing.T) {
+	rs := newTestRouter(t)
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	slow := &server.Server{Name: "slow.test"}
+	rs.mu.Lock()
+	rs.serverMap["slow.test"] = slow
+	rs.httpServerMap["slow.test"] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	})
+	rs.publish()
+	rs.mu.Unlock()
+	before := rs.routing()
+
+	done := make(chan int)
+	go func() { done <- serveHost("slow.test") }()
+	<-entered
+
+	rs.RemoveServer(slow)
+	if rs.routing() == before {
+		t.Fatal("removal did not publish a new table")
+	}
+	if _, _, ok := before.resolve("slow.test"); !ok {
+		t.Fatal("a published table must never change")
+	}
+	if code := serveHost("slow.test"); code != http.StatusNotFound {
+		t.Fatalf("new requests must use the new table, got %d", code)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusAccepted {
+		t.Fatalf("in-flight request answered %d", code)
+	}
+}
@@ -0,0 +1,318 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DoniLite/Mogoly/cloud"
+	"github.com/DoniLite/Mogoly/core/domain"
+	"github.com/DoniLite/Mogoly/core/events"
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+// ENTRYPOINT_TCP is the protocol of the entrypoints routing TCP connections by TLS SNI.
+const ENTRYPOINT_TCP string = "tcp"
+
+// errHelloRead stops the handshake started to read the ClientHello of a connection.
+var errHelloRead = errors.New("client hello read")
+
+// TCPServer is the listener of a tcp entrypoint. The connections are routed by the
+// SNI of their TLS ClientHello to the servers having a tcp config, then passed through
+// or terminated according to it.
+type TCPServer struct {
+	Name string
+	Addr string // Effective listen address
+
+	ep             *EntryPoint
+	ln             net.Listener
+	accepting      net.Listener // ln, reading the PROXY protocol headers when configured
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	closed         atomic.Bool
+	mu             sync.Mutex
+	conns          map[net.Conn]struct{}
+}
+
+// ServeTCPEntryPoint starts the listener of the named tcp entrypoint, bound before
+// returning. cm provides the certificates of the servers terminating TLS.
+func ServeTCPEntryPoint(name string, cm *domain.Manager) (*TCPServer, error) {
+	name = strings.ToLower(name)
+	ep := entryPointFor(name)
+	if ep.Disabled {
+		return nil, fmt.Errorf("entrypoint %s is disabled", name)
+	}
+	if ep.Protocol != ENTRYPOINT_TCP {
+		return nil, fmt.Errorf("entrypoint %s is not a tcp entrypoint", name)
+	}
+	var getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	if cm != nil {
+		getCertificate = cm.GetCertificate
+	}
+	return serveTCPEntryPoint(name, ep, getCertificate)
+}
+
+// ServeTCPEntryPoints starts the enabled tcp entrypoints, see ServeEntryPoints.
+func ServeTCPEntryPoints(cm *domain.Manager) (map[string]*TCPServer, error) {
+	started := make(map[string]*TCPServer)
+	var errs []error
+	for _, name := range entryPointsWith(ENTRYPOINT_TCP) {
+		ts, err := ServeTCPEntryPoint(name, cm)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		started[name] = ts
+	}
+	closeInherited(entryPointsWith(ENTRYPOINT_HTTP, ENTRYPOINT_HTTPS, ENTRYPOINT_UDP))
+	return started, errors.Join(errs...)
+}
+
+func serveTCPEntryPoint(name string, ep *EntryPoint, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) (*TCPServer, error) {
+	ln := takeInherited(name, ep.Address)
+	if ln == nil {
+		var err error
+		if ln, err = net.Listen("tcp", ep.Address); err != nil {
+			return nil, fmt.Errorf("entrypoint %s: %w", name, err)
+		}
+	}
+	ts := &TCPServer{
+		Name:           name,
+		Addr:           ln.Addr().String(),
+		ep:             ep,
+		ln:             ln,
+		accepting:      ep.accepting(ln),
+		getCertificate: getCertificate,
+		conns:          make(map[net.Conn]struct{}),
+	}
+	events.Logf(events.LOG_INFO, "[TCP_SERVER]: Entrypoint %s (%s) listening on %s", name, ep.Protocol, ts.Addr)
+	go ts.serve()
+	return ts, nil
+}
+
+// Listener returns the TCP listener of the entrypoint, the one to pass to a new process.
+func (ts *TCPServer) Listener() net.Listener {
+	return ts.ln
+}
+
+// Active returns the connections being forwarded.
+func (ts *TCPServer) Active() int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return len(ts.conns)
+}
+
+// Close stops the listener and closes the forwarded connections.
+func (ts *TCPServer) Close() error {
+	err := ts.stopAccepting()
+	ts.closeConns()
+	return err
+}
+
+// Shutdown stops the listener and waits for the forwarded connections to end until
+// ctx is done, the connections left are then closed and counted in the result.
+func (ts *TCPServer) Shutdown(ctx context.Context) (aborted int) {
+	ts.stopAccepting()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for ts.Active() > 0 {
+		select {
+		case <-ctx.Done():
+			aborted = ts.Active()
+			ts.closeConns()
+			return aborted
+		case <-ticker.C:
+		}
+	}
+	return 0
+}
+
+func (ts *TCPServer) stopAccepting() error {
+	if ts.closed.Swap(true) {
+		return nil
+	}
+	return ts.ln.Close()
+}
+
+func (ts *TCPServer) closeConns() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for c := range ts.conns {
+		c.Close()
+	}
+}
+
+func (ts *TCPServer) serve() {
+	for {
+		conn, err := ts.accepting.Accept()
+		if err != nil {
+			if ts.closed.Load() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			events.Logf(events.LOG_ERROR, "[TCP_SERVER]: Entrypoint %s accept error: %v", ts.Name, err)
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		ts.mu.Lock()
+		ts.conns[conn] = struct{}{}
+		ts.mu.Unlock()
+		go func() {
+			defer func() {
+				ts.mu.Lock()
+				delete(ts.conns, conn)
+				ts.mu.Unlock()
+			}()
+			ts.handle(conn)
+		}()
+	}
+}
+
+// handle reads the ClientHello of conn and hands it to the server routing its SNI.
+func (ts *TCPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	if ts.ep.ReadHeaderTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(ts.ep.ReadHeaderTimeout))
+	}
+	serverName, hello, err := readClientHello(conn)
+	if err != nil {
+		events.Logf(events.LOG_INFO, "[TCP_SERVER]: No TLS ClientHello from %s on the %s entrypoint: %v", conn.RemoteAddr(), ts.Name, err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	rs, err := GetRouter()
+	if err != nil {
+		events.Logf(events.LOG_ERROR, "[ROUTER]: Router not ready yet")
+		return
+	}
+	s, ok := rs.ResolveSNI(serverName)
+	if !ok || !servesEntryPoint(s, ts.Name) {
+		events.Logf(events.LOG_ERROR, "[ROUTER]: Not found tcp route for %q on the %s entrypoint", serverName, ts.Name)
+		return
+	}
+
+	var client net.Conn = &replayConn{Conn: conn, r: io.MultiReader(bytes.NewReader(hello), conn)}
+	if s.TCP.TerminatesTLS() {
+		if ts.getCertificate == nil {
+			events.Logf(events.LOG_ERROR, "[TCP_SERVER]: No certificate manager to terminate TLS for the %s server", s.Name)
+			return
+		}
+		tlsConn := tls.Server(client, &tls.Config{GetCertificate: ts.getCertificate, MinVersion: tls.VersionTLS12})
+		if ts.ep.ReadHeaderTimeout > 0 {
+			conn.SetDeadline(time.Now().Add(ts.ep.ReadHeaderTimeout))
+		}
+		if err := tlsConn.Handshake(); err != nil {
+			events.Logf(events.LOG_INFO, "[TCP_SERVER]: TLS handshake with %s failed for the %s server: %v", conn.RemoteAddr(), s.Name, err)
+			return
+		}
+		conn.SetDeadline(time.Time{})
+		client = tlsConn
+	}
+	s.ServeTCP(client)
+}
+
+// readClientHello reads the ClientHello starting conn and returns its server name along
+// with the bytes read, to be replayed to the TLS stack handling the connection.
+func readClientHello(conn net.Conn) (string, []byte, error) {
+	var read bytes.Buffer
+	var serverName string
+	err := tls.Server(helloConn{Conn: conn, r: io.TeeReader(conn, &read)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	if !errors.Is(err, errHelloRead) {
+		return "", nil, err
+	}
+	if serverName == "" {
+		return "", nil, errors.New("missing SNI")
+	}
+	return serverName, read.Bytes(), nil
+}
+
+// helloConn feeds a handshake aborted once the ClientHello is read, nothing is written
+// to the client.
+type helloConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c helloConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c helloConn) Write(p []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+// replayConn reads the bytes consumed to route the connection before the connection itself.
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *replayConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *replayConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// ShutdownTCPEntryPoints stops the tcp entrypoints like ShutdownEntryPoints, the
+// forwarded connections get until ctx is done to end.
+func ShutdownTCPEntryPoints(ctx context.Context, servers map[string]*TCPServer) *ShutdownReport {
+	start := time.Now()
+	report := &ShutdownReport{}
+	for _, ts := range servers {
+		report.InFlight += ts.Active()
+	}
+	events.Logf(events.LOG_INFO, "[ROUTER]: Draining %d tcp entrypoints, %d connections in flight", len(servers), report.InFlight)
+
+	var aborted atomic.Int64
+	var wg sync.WaitGroup
+	for name, ts := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if left := ts.Shutdown(ctx); left > 0 {
+				aborted.Add(int64(left))
+				events.Logf(events.LOG_ERROR, "[ROUTER]: Grace period of the %s entrypoint over, closed %d connections", name, left)
+			}
+		}()
+	}
+	wg.Wait()
+
+	report.Aborted = int(aborted.Load())
+	report.Duration = time.Since(start)
+	return report
+}
+
+// serviceRoutes returns the tcp routes of the cloud services having a domain: TLS is
+// terminated for the domain and the stream forwarded to the port published by the
+// instance, on the entrypoint named by the domain config or all the tcp ones.
+func serviceRoutes(services map[string]*cloud.ServiceConfig, instances map[string]*cloud.ServiceInstance) map[string]*server.Server {
+	routes := make(map[string]*server.Server)
+	for key, svc := range services {
+		instance := instances[key]
+		if svc == nil || svc.Domain == nil || svc.Domain.Domain == "" || instance == nil || instance.ExternalPort == 0 {
+			continue
+		}
+		route := &server.Server{
+			Name:     svc.Domain.Domain,
+			Protocol: ENTRYPOINT_TCP,
+			Host:     "127.0.0.1",
+			Port:     instance.ExternalPort,
+			TCP:      &server.TCPConfig{TLS: server.TCP_TLS_TERMINATE, AllowedCIDRs: svc.Domain.AllowedCIDRs},
+		}
+		if svc.Domain.EntryPoint != "" {
+			route.EntryPoints = []string{svc.Domain.EntryPoint}
+		}
+		routes["service:"+key] = route
+	}
+	return routes
+}
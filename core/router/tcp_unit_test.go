@@ -0,0 +1,265 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DoniLite/Mogoly/cloud"
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+func testCertificate(t *testing.T, hosts ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// echoBackend answers each line with its name and the line, behind TLS when cert is set.
+func echoBackend(t *testing.T, name string, cert *tls.Certificate) *server.Server {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	if cert != nil {
+		ln = tls.NewListener(ln, &tls.Config{Certificates: []tls.Certificate{*cert}})
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					io.WriteString(conn, name+":"+line)
+				}
+			}()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return &server.Server{Name: name, Host: host, Port: p, IsHealthy: true}
+}
+
+func serveTCPTest(t *testing.T, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *TCPServer {
+	t.Helper()
+	ep := entryPointFor("databases")
+	ep.Address, ep.Protocol = "127.0.0.1:0", ENTRYPOINT_TCP
+	ts, err := serveTCPEntryPoint("databases", ep, getCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ts.Close() })
+	return ts
+}
+
+func dialTLS(t *testing.T, addr, serverName string) (*tls.Conn, error) {
+	t.Helper()
+	d := &net.Dialer{Timeout: 2 * time.Second}
+	return tls.DialWithDialer(d, "tcp", addr, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+}
+
+func roundTrip(t *testing.T, conn net.Conn, line string) string {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.WriteString(conn, line+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(got)
+}
+
+func TestServeTCPEntryPoint_RoutesBySNI(t *testing.T) {
+	rs := newTestRouter(t)
+	backendCert := testCertificate(t, "db.test")
+	rs.AddServer(&server.Server{
+		Name:             "db.test",
+		TCP:              &server.TCPConfig{},
+		BalancingServers: []*server.Server{echoBackend(t, "db-1", &backendCert), echoBackend(t, "db-2", &backendCert)},
+	})
+	plain := echoBackend(t, "cache", nil)
+	rs.AddServer(&server.Server{Name: "cache.test", Host: plain.Host, Port: plain.Port, TCP: &server.TCPConfig{TLS: server.TCP_TLS_TERMINATE}})
+	rs.AddServer(&server.Server{Name: "web.test", URL: "http://127.0.0.1:1"})
+
+	terminated := testCertificate(t, "cache.test")
+	ts := serveTCPTest(t, func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return &terminated, nil })
+
+	// Passthrough: the backends terminate TLS and are picked in turn.
+	seen := map[string]bool{}
+	for range 2 {
+		conn, err := dialTLS(t, ts.Addr, "db.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := roundTrip(t, conn, "ping")
+		conn.Close()
+		name, line, _ := strings.Cut(got, ":")
+		if line != "ping" {
+			t.Fatalf("passthrough answered %q", got)
+		}
+		seen[name] = true
+	}
+	if !seen["db-1"] || !seen["db-2"] {
+		t.Fatalf("connections not balanced: %v", seen)
+	}
+
+	// Terminate: Mogoly presents the certificate, the backend gets the plain stream.
+	conn, err := dialTLS(t, ts.Addr, "cache.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if cn := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; cn != "cache.test" {
+		t.Fatalf("terminated with the certificate of %s", cn)
+	}
+	if got := roundTrip(t, conn, "ping"); got != "cache:ping" {
+		t.Fatalf("terminated connection answered %q", got)
+	}
+
+	// Unknown names and http servers are not routed.
+	for _, name := range []string{"unknown.test", "web.test"} {
+		if conn, err := dialTLS(t, ts.Addr, name); err == nil {
+			conn.Close()
+			t.Fatalf("%s routed", name)
+		}
+	}
+}
+
+func TestServeTCPEntryPoint_Attachment(t *testing.T) {
+	rs := newTestRouter(t)
+	backend := echoBackend(t, "db", nil)
+	rs.AddServer(&server.Server{Name: "db.test", Host: backend.Host, Port: backend.Port, EntryPoints: []string{"other"},
+		TCP: &server.TCPConfig{TLS: server.TCP_TLS_TERMINATE}})
+	cert := testCertificate(t, "db.test")
+	ts := serveTCPTest(t, func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return &cert, nil })
+
+	if conn, err := dialTLS(t, ts.Addr, "db.test"); err == nil {
+		conn.Close()
+		t.Fatal("server attached to another entrypoint routed")
+	}
+}
+
+func TestShutdownTCPEntryPoints(t *testing.T) {
+	rs := newTestRouter(t)
+	backend := echoBackend(t, "db", nil)
+	rs.AddServer(&server.Server{Name: "db.test", Host: backend.Host, Port: backend.Port, TCP: &server.TCPConfig{TLS: server.TCP_TLS_TERMINATE}})
+	cert := testCertificate(t, "db.test")
+	ts := serveTCPTest(t, func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return &cert, nil })
+
+	conn, err := dialTLS(t, ts.Addr, "db.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	roundTrip(t, conn, "ping")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	report := ShutdownTCPEntryPoints(ctx, map[string]*TCPServer{"databases": ts})
+	if report.InFlight != 1 || report.Aborted != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("connection left open after the grace period")
+	}
+	if _, err := net.DialTimeout("tcp", ts.Addr, time.Second); err == nil {
+		t.Fatal("listener still accepting")
+	}
+}
+
+func TestConfigValidate_TCPServers(t *testing.T) {
+	cf := &Config{
+		EntryPoints: map[string]*EntryPoint{
+			"databases": {Address: ":5443", Protocol: "tcp"},
+			"raw":       {Address: ":5444", Protocol: "tcp", Middlewares: []server.Middleware{{Name: string(server.MogolyHeaders)}}},
+		},
+		Servers: []*server.Server{
+			{Name: "web.test", EntryPoints: []string{"databases"}},
+			{Name: "db.test", EntryPoints: []string{"http"}, TCP: &server.TCPConfig{}},
+			{Name: "bad.test", TCP: &server.TCPConfig{TLS: "reencrypt", AllowedCIDRs: []string{"10.0.0.0/33"}, IdleTimeout: time.Nanosecond}},
+		},
+	}
+	err := cf.Validate()
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("invalid tcp servers accepted: %v", err)
+	}
+	for _, want := range []string{
+		`"raw": middlewares are not supported`,
+		`server "web.test": entrypoint "databases" is a tcp entrypoint`,
+		`server "db.test": entrypoint "http" serves http`,
+		`unknown tls mode "reencrypt"`,
+		`invalid allowed_cidrs entry "10.0.0.0/33"`,
+		`idle_timeout must be 0 or at least 10ms, got 1ns`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in %v", want, err)
+		}
+	}
+
+	cf = &Config{
+		EntryPoints: map[string]*EntryPoint{"databases": {Address: ":5443", Protocol: "TCP"}},
+		Servers:     []*server.Server{{Name: "db.test", EntryPoints: []string{"Databases"}, TCP: &server.TCPConfig{TLS: "passthrough"}}},
+	}
+	if err := cf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServiceRoutes(t *testing.T) {
+	services := map[string]*cloud.ServiceConfig{
+		"pg":    {Name: "pg", Domain: &cloud.DomainConfig{Domain: "db.example.com", EntryPoint: "databases", AllowedCIDRs: []string{"10.0.0.0/8"}}},
+		"redis": {Name: "redis"},
+	}
+	instances := map[string]*cloud.ServiceInstance{
+		"pg":    {Name: "pg", ExternalPort: 5433},
+		"redis": {Name: "redis", ExternalPort: 6380},
+	}
+	routes := serviceRoutes(services, instances)
+	if len(routes) != 1 {
+		t.Fatalf("want one route, got %v", routes)
+	}
+	r := routes["service:pg"]
+	if r == nil || r.Name != "db.example.com" || r.Port != 5433 || !r.TCP.TerminatesTLS() || r.EntryPoints[0] != "databases" || r.TCP.AllowedCIDRs[0] != "10.0.0.0/8" {
+		t.Fatalf("unexpected service route %+v", r)
+	}
+}
@@ -31,7 +31,9 @@ func TestServeHTTPS_LocalhostSNI(t *testing.T) {
 	app := httptest.NewServer(http.HandlerFunc(server.Ping))
 	defer app.Close()
 	s := &server.Server{Name: "app.localhost", URL: app.URL}
-	buildRouter(&Config{Servers: []*server.Server{s}})
+	if err := buildRouter(&Config{Servers: []*server.Server{s}}); err != nil {
+		t.Fatalf("failed to build the router: %v", err)
+	}
 
 	cm, err := domain.NewManager()
 	if err != nil {
@@ -40,7 +42,10 @@ func TestServeHTTPS_LocalhostSNI(t *testing.T) {
 	if err := cm.Add("app.localhost", true); err != nil {
 		t.Fatalf("failed to add domain: %v", err)
 	}
-	ts := ServeHTTPS("127.0.0.1:0", cm)
+	ts, err := ServeHTTPS("127.0.0.1:0", cm)
+	if err != nil {
+		t.Fatal(err)
+	}
 	defer func() {
 		if err := ts.Close(); err != nil {
 			log.Printf("Error while closing the server: %v", err)
@@ -3,6 +3,8 @@ package router
 import (
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/DoniLite/Mogoly/cloud"
 	"github.com/DoniLite/Mogoly/core/server"
@@ -12,6 +14,7 @@ type RouterState struct {
 	mu                      sync.RWMutex
 	httpServerMap           map[string]http.Handler // host -> backend
 	serverMap               map[string]*server.Server
+	table                   atomic.Pointer[routingTable] // Snapshot read by the request path
 	cloudMap                map[string]*cloud.ServiceConfig
 	cloudServiceInstanceMap map[string]*cloud.ServiceInstance
 	serviceManager          *cloud.CloudManager
@@ -23,4 +26,22 @@ type Config struct {
 	Servers   []*server.Server       `json:"server" yaml:"server"` // The servers instances
 	Services  []*cloud.ServiceConfig `json:"services,omitempty" yaml:"services,omitempty"`
 	Variables map[string]string      `json:"variables,omitempty" yaml:"variables,omitempty"`
+	// Listeners by name, the http (:80) and https (:443) ones exist unless disabled
+	EntryPoints map[string]*EntryPoint `json:"entrypoints,omitempty" yaml:"entrypoints,omitempty"`
+}
+
+// EntryPoint describes a listener and the limits applied to its http.Server, zero values
+// fall back to the defaults of DefaultEntryPoint.
+type EntryPoint struct {
+	Address           string                      `json:"address,omitempty" yaml:"address,omitempty"`         // Listen address, :80 for http and :443 for https by default
+	Protocol          string                      `json:"protocol,omitempty" yaml:"protocol,omitempty"`       // http, https, tcp or udp, defaults to the name of the http and https entrypoints
+	Middlewares       []server.Middleware         `json:"middlewares,omitempty" yaml:"middlewares,omitempty"` // Applied to every request of the entrypoint, before the server middlewares
+	Disabled          bool                        `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	ReadHeaderTimeout time.Duration               `json:"read_header_timeout,omitempty" yaml:"read_header_timeout,omitempty"`
+	ReadTimeout       time.Duration               `json:"read_timeout,omitempty" yaml:"read_timeout,omitempty"`
+	WriteTimeout      time.Duration               `json:"write_timeout,omitempty" yaml:"write_timeout,omitempty"`
+	IdleTimeout       time.Duration               `json:"idle_timeout,omitempty" yaml:"idle_timeout,omitempty"`
+	MaxHeaderBytes    int                         `json:"max_header_bytes,omitempty" yaml:"max_header_bytes,omitempty"`
+	ProxyProtocol     *server.ProxyProtocolConfig `json:"proxy_protocol,omitempty" yaml:"proxy_protocol,omitempty"` // Reads the client addresses from the PROXY protocol headers of the trusted load balancers
+	HealthPath        string                      `json:"health_path,omitempty" yaml:"health_path,omitempty"`       // Answers 200 on every host while the router is ready, 503 once it drains
 }
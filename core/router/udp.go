@@ -0,0 +1,299 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/events"
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+// ENTRYPOINT_UDP is the protocol of the entrypoints forwarding datagrams.
+const ENTRYPOINT_UDP string = "udp"
+
+// Largest datagram forwarded, the maximum UDP payload.
+const maxDatagramSize = 64 << 10
+
+// How often the idle sessions are looked for.
+var udpReapInterval = 250 * time.Millisecond
+
+// UDPServer is the socket of a udp entrypoint. The datagrams of each client address
+// are forwarded to the backend picked for its session, the server answering being the
+// one with a udp config attached to the entrypoint, and the replies sent back to it.
+type UDPServer struct {
+	Name string
+	Addr string // Effective listen address
+
+	pc       net.PacketConn
+	draining atomic.Bool
+	closed   atomic.Bool
+	done     chan struct{}
+	mu       sync.Mutex
+	sessions map[string]*udpSession
+}
+
+// udpSession binds a client address to the socket connected to its backend.
+type udpSession struct {
+	client   net.Addr
+	server   *server.Server
+	upstream *net.UDPConn
+	timeout  time.Duration
+	active   atomic.Int64
+}
+
+func (us *udpSession) touch() {
+	us.active.Store(time.Now().UnixNano())
+}
+
+func (us *udpSession) idle() bool {
+	return time.Since(time.Unix(0, us.active.Load())) >= us.timeout
+}
+
+// ServeUDPEntryPoint starts the socket of the named udp entrypoint, bound before returning.
+func ServeUDPEntryPoint(name string) (*UDPServer, error) {
+	name = strings.ToLower(name)
+	ep := entryPointFor(name)
+	if ep.Disabled {
+		return nil, fmt.Errorf("entrypoint %s is disabled", name)
+	}
+	if ep.Protocol != ENTRYPOINT_UDP {
+		return nil, fmt.Errorf("entrypoint %s is not a udp entrypoint", name)
+	}
+	return serveUDPEntryPoint(name, ep)
+}
+
+// ServeUDPEntryPoints starts the enabled udp entrypoints, see ServeEntryPoints.
+func ServeUDPEntryPoints() (map[string]*UDPServer, error) {
+	started := make(map[string]*UDPServer)
+	var errs []error
+	for _, name := range entryPointsWith(ENTRYPOINT_UDP) {
+		us, err := ServeUDPEntryPoint(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		started[name] = us
+	}
+	closeInherited(entryPointsWith(ENTRYPOINT_HTTP, ENTRYPOINT_HTTPS, ENTRYPOINT_TCP))
+	return started, errors.Join(errs...)
+}
+
+func serveUDPEntryPoint(name string, ep *EntryPoint) (*UDPServer, error) {
+	pc := takeInheritedPacket(name, ep.Address)
+	if pc == nil {
+		var err error
+		if pc, err = net.ListenPacket("udp", ep.Address); err != nil {
+			return nil, fmt.Errorf("entrypoint %s: %w", name, err)
+		}
+	}
+	us := &UDPServer{
+		Name:     name,
+		Addr:     pc.LocalAddr().String(),
+		pc:       pc,
+		done:     make(chan struct{}),
+		sessions: make(map[string]*udpSession),
+	}
+	events.Logf(events.LOG_INFO, "[UDP_SERVER]: Entrypoint %s (%s) listening on %s", name, ep.Protocol, us.Addr)
+	go us.serve()
+	go us.reap()
+	return us, nil
+}
+
+// PacketConn returns the socket of the entrypoint, the one to pass to a new process.
+func (us *UDPServer) PacketConn() net.PacketConn {
+	return us.pc
+}
+
+// Sessions returns the client sessions being forwarded.
+func (us *UDPServer) Sessions() int {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	return len(us.sessions)
+}
+
+// Close closes the socket and ends the sessions.
+func (us *UDPServer) Close() error {
+	if us.closed.Swap(true) {
+		return nil
+	}
+	us.draining.Store(true)
+	close(us.done)
+	err := us.pc.Close()
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	for key, s := range us.sessions {
+		s.upstream.Close()
+		delete(us.sessions, key)
+	}
+	return err
+}
+
+// Shutdown stops reading the datagrams, those left in the socket are for the process
+// taking it over if any, and waits for the sessions to end. The replies of the backends
+// are still sent back until ctx is done, then the socket is closed and the sessions
+// left are counted in the result.
+func (us *UDPServer) Shutdown(ctx context.Context) (aborted int) {
+	us.draining.Store(true)
+	us.pc.SetReadDeadline(time.Now())
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for us.Sessions() > 0 {
+		select {
+		case <-ctx.Done():
+			aborted = us.Sessions()
+			us.Close()
+			return aborted
+		case <-ticker.C:
+		}
+	}
+	us.Close()
+	return 0
+}
+
+func (us *UDPServer) serve() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := us.pc.ReadFrom(buf)
+		if err != nil {
+			if us.draining.Load() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			events.Logf(events.LOG_ERROR, "[UDP_SERVER]: Entrypoint %s read error: %v", us.Name, err)
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		s := us.session(addr)
+		if s == nil {
+			continue
+		}
+		s.touch()
+		if _, err := s.upstream.Write(buf[:n]); err != nil {
+			events.Logf(events.LOG_DEBUG, "[UDP_SERVER]: Datagram from %s to the %s server not forwarded: %v", addr, s.server.Name, err)
+		}
+	}
+}
+
+// session returns the session of the client, started when missing. The backend is
+// dialed without holding the lock, the reaper and the other clients do not wait on it.
+func (us *UDPServer) session(client net.Addr) *udpSession {
+	key := client.String()
+	us.mu.Lock()
+	s, ok := us.sessions[key]
+	us.mu.Unlock()
+	if ok {
+		return s
+	}
+
+	rs, err := GetRouter()
+	if err != nil {
+		events.Logf(events.LOG_ERROR, "[ROUTER]: Router not ready yet")
+		return nil
+	}
+	target, ok := rs.ResolveUDP(us.Name)
+	if !ok {
+		events.Logf(events.LOG_ERROR, "[ROUTER]: No udp server attached to the %s entrypoint", us.Name)
+		return nil
+	}
+	limit := target.UDP.SessionLimit()
+	if us.Sessions() >= limit {
+		events.Logf(events.LOG_DEBUG, "[UDP_SERVER]: Datagram from %s dropped, the %s entrypoint has %d sessions", client, us.Name, limit)
+		return nil
+	}
+	upstream, backend, err := target.DialUDP()
+	if err != nil {
+		events.Logf(events.LOG_ERROR, "[UDP_SERVER]: Error while reaching the backend of the %s server: %v", target.Name, err)
+		return nil
+	}
+	s = &udpSession{client: client, server: target, upstream: upstream, timeout: target.UDP.SessionIdleTimeout()}
+	s.touch()
+
+	us.mu.Lock()
+	if existing, ok := us.sessions[key]; ok || us.closed.Load() || len(us.sessions) >= limit {
+		us.mu.Unlock()
+		upstream.Close()
+		return existing
+	}
+	us.sessions[key] = s
+	us.mu.Unlock()
+	events.Logf(events.LOG_INFO, "[UDP_SERVER]: Forwarding %s -> %s (backend Name: %s)", client, upstream.RemoteAddr(), backend.Name)
+	go us.reply(s)
+	return s
+}
+
+// reply sends the datagrams of the backend back to the client until the session ends.
+func (us *UDPServer) reply(s *udpSession) {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, err := s.upstream.Read(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				events.Logf(events.LOG_DEBUG, "[UDP_SERVER]: Session of %s with the %s server: %v", s.client, s.server.Name, err)
+			}
+			// Connected UDP sockets report the ICMP errors of the backend, the session goes on.
+			if errors.Is(err, net.ErrClosed) || us.closed.Load() {
+				return
+			}
+			continue
+		}
+		s.touch()
+		if _, err := us.pc.WriteTo(buf[:n], s.client); err != nil {
+			events.Logf(events.LOG_DEBUG, "[UDP_SERVER]: Reply to %s not sent: %v", s.client, err)
+		}
+	}
+}
+
+// reap ends the sessions idle for their timeout.
+func (us *UDPServer) reap() {
+	ticker := time.NewTicker(udpReapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-us.done:
+			return
+		case <-ticker.C:
+		}
+		us.mu.Lock()
+		for key, s := range us.sessions {
+			if s.idle() {
+				s.upstream.Close()
+				delete(us.sessions, key)
+			}
+		}
+		us.mu.Unlock()
+	}
+}
+
+// ShutdownUDPEntryPoints stops the udp entrypoints like ShutdownEntryPoints, the
+// sessions get until ctx is done to end.
+func ShutdownUDPEntryPoints(ctx context.Context, servers map[string]*UDPServer) *ShutdownReport {
+	start := time.Now()
+	report := &ShutdownReport{}
+	for _, us := range servers {
+		report.InFlight += us.Sessions()
+	}
+	events.Logf(events.LOG_INFO, "[ROUTER]: Draining %d udp entrypoints, %d sessions in flight", len(servers), report.InFlight)
+
+	var aborted atomic.Int64
+	var wg sync.WaitGroup
+	for name, us := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if left := us.Shutdown(ctx); left > 0 {
+				aborted.Add(int64(left))
+				events.Logf(events.LOG_ERROR, "[ROUTER]: Grace period of the %s entrypoint over, ended %d sessions", name, left)
+			}
+		}()
+	}
+	wg.Wait()
+
+	report.Aborted = int(aborted.Load())
+	report.Duration = time.Since(start)
+	return report
+}
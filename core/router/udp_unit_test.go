@@ -0,0 +1,197 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/server"
+)
+
+// udpEchoBackend answers each datagram with its name and the datagram.
+func udpEchoBackend(t *testing.T, name string) *server.Server {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo([]byte(name+":"+string(buf[:n])), addr)
+		}
+	}()
+	addr := pc.LocalAddr().(*net.UDPAddr)
+	return &server.Server{Name: name, Host: "127.0.0.1", Port: addr.Port, IsHealthy: true}
+}
+
+func serveUDPTest(t *testing.T) *UDPServer {
+	t.Helper()
+	ep := entryPointFor("dns")
+	ep.Address, ep.Protocol = "127.0.0.1:0", ENTRYPOINT_UDP
+	us, err := serveUDPEntryPoint("dns", ep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { us.Close() })
+	return us
+}
+
+func exchange(t *testing.T, conn net.Conn, msg string) string {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestServeUDPEntryPoint_Sessions(t *testing.T) {
+	rs := newTestRouter(t)
+	rs.AddServer(&server.Server{
+		Name:             "dns.local",
+		UDP:              &server.UDPConfig{SessionTimeout: 200 * time.Millisecond},
+		BalancingServers: []*server.Server{udpEchoBackend(t, "ns1"), udpEchoBackend(t, "ns2")},
+	})
+	us := serveUDPTest(t)
+
+	backendOf := func(conn net.Conn) string {
+		t.Helper()
+		name, msg, _ := strings.Cut(exchange(t, conn, "query"), ":")
+		if msg != "query" {
+			t.Fatalf("unexpected reply %q", msg)
+		}
+		return name
+	}
+	clients := make([]net.Conn, 2)
+	for i := range clients {
+		conn, err := net.Dial("udp", us.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		clients[i] = conn
+	}
+
+	// Each client keeps its backend for the session, the sessions are balanced.
+	first, second := backendOf(clients[0]), backendOf(clients[1])
+	if first == second {
+		t.Fatalf("both sessions sent to %s", first)
+	}
+	for range 3 {
+		if got := backendOf(clients[0]); got != first {
+			t.Fatalf("session moved from %s to %s", first, got)
+		}
+	}
+	if n := us.Sessions(); n != 2 {
+		t.Fatalf("want 2 sessions, got %d", n)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for us.Sessions() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d idle sessions not ended", us.Sessions())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if got := backendOf(clients[0]); got != "ns1" && got != "ns2" {
+		t.Fatalf("no new session after expiry: %q", got)
+	}
+}
+
+func TestServeUDPEntryPoint_MaxSessions(t *testing.T) {
+	rs := newTestRouter(t)
+	backend := udpEchoBackend(t, "ns1")
+	rs.AddServer(&server.Server{Name: "dns.local", Host: backend.Host, Port: backend.Port, UDP: &server.UDPConfig{MaxSessions: 1}})
+	us := serveUDPTest(t)
+
+	first, err := net.Dial("udp", us.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	exchange(t, first, "query")
+
+	second, err := net.Dial("udp", us.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetDeadline(time.Now().Add(300 * time.Millisecond))
+	second.Write([]byte("query"))
+	if n, err := second.Read(make([]byte, 1500)); err == nil {
+		t.Fatalf("datagram of a client over the session cap forwarded, got %d bytes", n)
+	}
+	if n := us.Sessions(); n != 1 {
+		t.Fatalf("want 1 session, got %d", n)
+	}
+	// The clients with a session are still served.
+	if got := exchange(t, first, "again"); got != "ns1:again" {
+		t.Fatalf("unexpected reply %q", got)
+	}
+}
+
+func TestShutdownUDPEntryPoints(t *testing.T) {
+	rs := newTestRouter(t)
+	backend := udpEchoBackend(t, "syslog")
+	rs.AddServer(&server.Server{Name: "syslog.local", Host: backend.Host, Port: backend.Port, UDP: &server.UDPConfig{}})
+	us := serveUDPTest(t)
+
+	conn, err := net.Dial("udp", us.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	exchange(t, conn, "hello")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	report := ShutdownUDPEntryPoints(ctx, map[string]*UDPServer{"dns": us})
+	if report.InFlight != 1 || report.Aborted != 1 || us.Sessions() != 0 {
+		t.Fatalf("unexpected report %+v, %d sessions left", report, us.Sessions())
+	}
+}
+
+func TestConfigValidate_UDPServers(t *testing.T) {
+	cf := &Config{
+		EntryPoints: map[string]*EntryPoint{
+			"dns":    {Address: ":53", Protocol: "udp"},
+			"syslog": {Address: ":514", Protocol: "udp"},
+		},
+		Servers: []*server.Server{
+			{Name: "ns.local", UDP: &server.UDPConfig{}},
+			{Name: "ns2.local", EntryPoints: []string{"dns"}, UDP: &server.UDPConfig{SessionTimeout: -time.Second, MaxSessions: -1}},
+			{Name: "web.test", EntryPoints: []string{"syslog"}},
+			{Name: "both.local", TCP: &server.TCPConfig{}, UDP: &server.UDPConfig{}, EntryPoints: []string{"syslog"}},
+		},
+	}
+	err := cf.Validate()
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("invalid udp servers accepted: %v", err)
+	}
+	for _, want := range []string{
+		`"ns2.local": udp: session_timeout must not be negative`,
+		`max_sessions must not be negative`,
+		`server "web.test": entrypoint "syslog" is a udp entrypoint`,
+		`server "both.local": a server has either a tcp or a udp config`,
+		`entrypoint "dns": a udp entrypoint targets one server, got ns.local, ns2.local`,
+		`entrypoint "syslog": a udp entrypoint targets one server, got ns.local, both.local`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in %v", want, err)
+		}
+	}
+}
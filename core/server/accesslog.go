@@ -0,0 +1,402 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/config"
+	"github.com/DoniLite/Mogoly/core/events"
+	goevents "github.com/DoniLite/go-events"
+)
+
+const (
+	AccessLogFormatCommon   string = "common"
+	AccessLogFormatCombined string = "combined"
+	AccessLogFormatJSON     string = "json"
+
+	AccessLogOutputFile   string = "file"
+	AccessLogOutputEvents string = "events"
+	AccessLogOutputStdout string = "stdout"
+
+	ACCESS_LOG_FILE string = "access.log"
+
+	clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+var accessLogPlaceholder = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+type AccessLogMiddlewareConfig struct {
+	Format        string   `json:"format,omitempty" yaml:"format,omitempty"`                 // common (default), combined or json
+	Template      string   `json:"template,omitempty" yaml:"template,omitempty"`             // Custom line such as `{method} {path} {status} {duration_ms}`, replaces Format
+	Output        string   `json:"output,omitempty" yaml:"output,omitempty"`                 // file (default), events or stdout
+	Path          string   `json:"path,omitempty" yaml:"path,omitempty"`                     // File of the file output, ~/.mogoly/access.log by default
+	SampleRate    float64  `json:"sample_rate,omitempty" yaml:"sample_rate,omitempty"`       // Share of the requests logged between 0 and 1, 5xx answers are always logged
+	Fields        []string `json:"fields,omitempty" yaml:"fields,omitempty"`                 // Fields kept in the json lines and event payloads, all by default
+	ExcludeFields []string `json:"exclude_fields,omitempty" yaml:"exclude_fields,omitempty"` // Fields removed from the json lines and event payloads
+}
+
+func (c AccessLogMiddlewareConfig) Validate() error {
+	switch strings.ToLower(c.Format) {
+	case "", AccessLogFormatCommon, AccessLogFormatCombined, AccessLogFormatJSON:
+	default:
+		return fmt.Errorf("unknown format %q", c.Format)
+	}
+	switch strings.ToLower(c.Output) {
+	case "", AccessLogOutputFile, AccessLogOutputEvents, AccessLogOutputStdout:
+	default:
+		return fmt.Errorf("unknown output %q", c.Output)
+	}
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return fmt.Errorf("sample_rate must be between 0 and 1, got %v", c.SampleRate)
+	}
+	return nil
+}
+
+// AccessLogEntry describes one proxied request.
+type AccessLogEntry struct {
+	Time      time.Time `json:"time"`
+	ClientIP  string    `json:"client_ip"`
+	User      string    `json:"user"`
+	RequestID string    `json:"request_id"`
+	Method    string    `json:"method"`
+	Host      string    `json:"host"`
+	Path      string    `json:"path"`
+	URI       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Duration  float64   `json:"duration_ms"`
+	Backend   string    `json:"backend"`
+	Upstream  float64   `json:"upstream_ms"`
+	Referer   string    `json:"referer"`
+	UserAgent string    `json:"user_agent"`
+}
+
+// fields returns the entry keyed by the json names, the form filtered and templated.
+func (e *AccessLogEntry) fields() map[string]any {
+	return map[string]any{
+		"time":        e.Time.Format(time.RFC3339Nano),
+		"client_ip":   e.ClientIP,
+		"user":        e.User,
+		"request_id":  e.RequestID,
+		"method":      e.Method,
+		"host":        e.Host,
+		"path":        e.Path,
+		"uri":         e.URI,
+		"proto":       e.Proto,
+		"status":      e.Status,
+		"bytes":       e.Bytes,
+		"duration_ms": e.Duration,
+		"backend":     e.Backend,
+		"upstream_ms": e.Upstream,
+		"referer":     e.Referer,
+		"user_agent":  e.UserAgent,
+	}
+}
+
+// proxyTrace is filled by Server.ServeHTTP for the middlewares wrapping it.
+type proxyTrace struct {
+	Backend         string
+	UpstreamLatency time.Duration
+}
+
+type proxyTraceContextKey struct{}
+
+func withProxyTrace(ctx context.Context, pt *proxyTrace) context.Context {
+	return context.WithValue(ctx, proxyTraceContextKey{}, pt)
+}
+
+func proxyTraceFromContext(ctx context.Context) *proxyTrace {
+	pt, _ := ctx.Value(proxyTraceContextKey{}).(*proxyTrace)
+	return pt
+}
+
+type accessLogger struct {
+	format     string
+	template   string
+	sampleRate float64
+	include    map[string]bool
+	exclude    map[string]bool
+	output     string
+	file       *accessLogFile
+}
+
+// Files are shared by path so that several servers can log into the same one.
+type accessLogFile struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+var (
+	accessLogFilesMu sync.Mutex
+	accessLogFiles   = make(map[string]*accessLogFile)
+)
+
+// defaultAccessLogPath returns ~/.mogoly/access.log.
+func defaultAccessLogPath() string {
+	dir, err := config.CreateConfigDir("")
+	if err != nil {
+		events.Logf(events.LOG_ERROR, "[ACCESSLOG]: Cannot resolve the default access log path: %v", err)
+	}
+	return filepath.Join(dir, ACCESS_LOG_FILE)
+}
+
+func openAccessLogFile(path string) (*accessLogFile, error) {
+	accessLogFilesMu.Lock()
+	defer accessLogFilesMu.Unlock()
+	if f, ok := accessLogFiles[path]; ok {
+		return f, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	alf := &accessLogFile{w: f}
+	accessLogFiles[path] = alf
+	return alf, nil
+}
+
+func (f *accessLogFile) write(line []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.w.Write(line); err != nil {
+		events.Logf(events.LOG_ERROR, "[ACCESSLOG]: Error while writing the access log: %v", err)
+	}
+}
+
+// AccessLogMiddleware records one line per request with its status, size, latency,
+// the backend picked by the load balancer and the upstream latency.
+func AccessLogMiddleware(conf AccessLogMiddlewareConfig) func(next http.Handler) http.Handler {
+	al := &accessLogger{
+		format:     strings.ToLower(conf.Format),
+		template:   conf.Template,
+		sampleRate: conf.SampleRate,
+		output:     strings.ToLower(conf.Output),
+	}
+	switch al.format {
+	case AccessLogFormatCommon, AccessLogFormatCombined, AccessLogFormatJSON:
+	case "":
+		al.format = AccessLogFormatCommon
+	default:
+		events.Logf(events.LOG_ERROR, "[ACCESSLOG]: Unknown format %q, falling back to %s", conf.Format, AccessLogFormatCommon)
+		al.format = AccessLogFormatCommon
+	}
+	if al.sampleRate <= 0 || al.sampleRate > 1 {
+		al.sampleRate = 1
+	}
+	if len(conf.Fields) > 0 {
+		al.include = make(map[string]bool, len(conf.Fields))
+		for _, f := range conf.Fields {
+			al.include[strings.ToLower(f)] = true
+		}
+	}
+	al.exclude = make(map[string]bool, len(conf.ExcludeFields))
+	for _, f := range conf.ExcludeFields {
+		al.exclude[strings.ToLower(f)] = true
+	}
+
+	switch al.output {
+	case AccessLogOutputEvents:
+	case AccessLogOutputStdout:
+		al.file = &accessLogFile{w: os.Stdout}
+	default:
+		al.output = AccessLogOutputFile
+		path := conf.Path
+		if path == "" {
+			path = defaultAccessLogPath()
+		}
+		f, err := openAccessLogFile(path)
+		if err != nil {
+			events.Logf(events.LOG_ERROR, "[ACCESSLOG]: Cannot open %s, writing to stdout: %v", path, err)
+			f = &accessLogFile{w: os.Stdout}
+		}
+		al.file = f
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			pt := &proxyTrace{}
+			r = r.WithContext(withProxyTrace(r.Context(), pt))
+			lw := &accessLogWriter{ResponseWriter: w}
+			// The next middlewares may rewrite the URL, log what the client asked for.
+			logged := *r
+			u := *r.URL
+			logged.URL = &u
+			defer al.log(&logged, lw, pt, start)
+			next.ServeHTTP(lw, r)
+		})
+	}
+}
+
+func (al *accessLogger) log(r *http.Request, lw *accessLogWriter, pt *proxyTrace, start time.Time) {
+	status := lw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status < 500 && al.sampleRate < 1 && rand.Float64() >= al.sampleRate {
+		return
+	}
+	user := "-"
+	if r.URL.User != nil && r.URL.User.Username() != "" {
+		user = r.URL.User.Username()
+	} else if u, _, ok := r.BasicAuth(); ok && u != "" {
+		user = u
+	}
+	entry := &AccessLogEntry{
+		Time:      start,
+		ClientIP:  clientIP(r.RemoteAddr),
+		User:      user,
+		RequestID: RequestIDFromContext(r.Context()),
+		Method:    r.Method,
+		Host:      r.Host,
+		Path:      r.URL.Path,
+		URI:       r.URL.RequestURI(),
+		Proto:     r.Proto,
+		Status:    status,
+		Bytes:     lw.bytes,
+		Duration:  float64(time.Since(start).Microseconds()) / 1000,
+		Backend:   pt.Backend,
+		Upstream:  float64(pt.UpstreamLatency.Microseconds()) / 1000,
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+	}
+	if entry.RequestID == "" {
+		entry.RequestID = r.Header.Get(RequestIDDefaultHeader)
+	}
+
+	line := al.render(entry)
+	if al.output == AccessLogOutputEvents {
+		events.GetEventBus().Emit(events.AccessLogEvent, &goevents.EventData{
+			Message: strings.TrimSuffix(string(line), "\n"),
+			Payload: al.filter(entry.fields()),
+		})
+		return
+	}
+	al.file.write(line)
+}
+
+func (al *accessLogger) filter(fields map[string]any) map[string]any {
+	for k := range fields {
+		if (al.include != nil && !al.include[k]) || al.exclude[k] {
+			delete(fields, k)
+		}
+	}
+	return fields
+}
+
+// render formats the entry as a newline terminated line.
+func (al *accessLogger) render(e *AccessLogEntry) []byte {
+	if al.template != "" {
+		fields := e.fields()
+		line := accessLogPlaceholder.ReplaceAllStringFunc(al.template, func(m string) string {
+			v, ok := fields[m[1:len(m)-1]]
+			if !ok {
+				return m
+			}
+			if s, ok := v.(string); ok {
+				return escapeLogValue(s)
+			}
+			return fmt.Sprint(v)
+		})
+		return []byte(line + "\n")
+	}
+	switch al.format {
+	case AccessLogFormatJSON:
+		line, err := json.Marshal(al.filter(e.fields()))
+		if err != nil {
+			events.Logf(events.LOG_ERROR, "[ACCESSLOG]: Error while encoding the access log entry: %v", err)
+			return nil
+		}
+		return append(line, '\n')
+	case AccessLogFormatCombined:
+		return []byte(clfLine(e) + fmt.Sprintf(` "%s" "%s"`+"\n", escapeLogValue(e.Referer), escapeLogValue(e.UserAgent)))
+	default:
+		return []byte(clfLine(e) + "\n")
+	}
+}
+
+func clfLine(e *AccessLogEntry) string {
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+	return fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %s`,
+		e.ClientIP, escapeLogValue(e.User), e.Time.Format(clfTimeFormat), escapeLogValue(e.Method), escapeLogValue(e.URI), escapeLogValue(e.Proto), e.Status, size)
+}
+
+// escapeLogValue escapes the quotes, backslashes and the bytes outside of printable
+// ASCII as Apache and nginx do, client values cannot forge access log lines.
+func escapeLogValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// accessLogWriter records the status and the size of the response.
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessLogWriter) WriteHeader(code int) {
+	if w.status == 0 && (code < 100 || code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessLogWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *accessLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("hijack not supported by the underlying response writer")
+}
+
+// Unwrap lets http.ResponseController reach the original writer.
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/DoniLite/Mogoly/core/events"
+)
+
+const bodyLimitDefaultMaxBytes = 10 << 20
+
+type BodyLimitMiddlewareConfig struct {
+	MaxBytes int64 `json:"max_bytes,omitempty" yaml:"max_bytes,omitempty"` // Maximum request body size in bytes, 10 MiB by default
+}
+
+// BodyLimitMiddleware rejects with 413 the requests whose body is bigger than MaxBytes.
+// A declared Content-Length is checked before reaching the backend, chunked bodies
+// are cut once the limit is crossed.
+func BodyLimitMiddleware(conf BodyLimitMiddlewareConfig) func(next http.Handler) http.Handler {
+	if conf.MaxBytes <= 0 {
+		conf.MaxBytes = bodyLimitDefaultMaxBytes
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > conf.MaxBytes {
+				events.Logf(events.LOG_INFO, "[BODYLIMIT]: Rejecting %s %s, body of %d bytes exceeds %d", r.Method, r.URL.Path, r.ContentLength, conf.MaxBytes)
+				w.Header().Set("Connection", "close")
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = http.MaxBytesReader(w, r.Body, conf.MaxBytes)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
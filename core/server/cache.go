@@ -0,0 +1,515 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/events"
+)
+
+const (
+	CacheStatusHeader string = "X-Cache"
+
+	CacheStatusHit         string = "HIT"
+	CacheStatusMiss        string = "MISS"
+	CacheStatusStale       string = "STALE"
+	CacheStatusRevalidated string = "REVALIDATED"
+	CacheStatusBypass      string = "BYPASS"
+
+	cacheDefaultMaxSize      = 64 << 20
+	cacheDefaultMaxEntrySize = 8 << 20
+	cacheRevalidateTimeout   = 30 * time.Second
+)
+
+// Statuses cacheable by default (RFC 9110 section 15.1).
+var cacheableStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+	http.StatusPermanentRedirect:    true,
+}
+
+type CacheMiddlewareConfig struct {
+	MaxSize      int64  `json:"max_size,omitempty" yaml:"max_size,omitempty"`             // Memory budget in bytes shared by the cached responses
+	MaxEntrySize int64  `json:"max_entry_size,omitempty" yaml:"max_entry_size,omitempty"` // Bigger bodies are streamed to the client without being stored
+	Disk         bool   `json:"disk,omitempty" yaml:"disk,omitempty"`                     // Enables the on-disk tier
+	DiskDir      string `json:"disk_dir,omitempty" yaml:"disk_dir,omitempty"`             // Directory of the on-disk tier, ~/.mogoly/cache by default
+	MaxDiskSize  int64  `json:"max_disk_size,omitempty" yaml:"max_disk_size,omitempty"`   // Size budget in bytes of the on-disk tier, unlimited when 0
+}
+
+type cacheCall struct {
+	done chan struct{}
+}
+
+type cacheHandler struct {
+	next         http.Handler
+	store        *cacheStore
+	maxEntrySize int64
+
+	mu    sync.Mutex
+	calls map[string]*cacheCall
+}
+
+// CacheMiddleware caches the GET/HEAD responses of the backends following their
+// Cache-Control, Vary and validators. Concurrent misses on the same URL are
+// collapsed into a single upstream request.
+func CacheMiddleware(conf CacheMiddlewareConfig) func(next http.Handler) http.Handler {
+	if conf.MaxSize <= 0 {
+		conf.MaxSize = cacheDefaultMaxSize
+	}
+	if conf.MaxEntrySize <= 0 {
+		conf.MaxEntrySize = cacheDefaultMaxEntrySize
+	}
+	diskDir := ""
+	if conf.Disk {
+		diskDir = conf.DiskDir
+		if diskDir == "" {
+			dir, err := defaultCacheDir()
+			if err != nil {
+				events.Logf(events.LOG_ERROR, "[CACHE]: Disk tier disabled: %v", err)
+			}
+			diskDir = dir
+		}
+	}
+	store := getCacheStore(conf.MaxSize, diskDir, conf.MaxDiskSize)
+
+	return func(next http.Handler) http.Handler {
+		return &cacheHandler{
+			next:         next,
+			store:        store,
+			maxEntrySize: conf.MaxEntrySize,
+			calls:        make(map[string]*cacheCall),
+		}
+	}
+}
+
+func (c *cacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reqCC := parseCacheControl(r.Header.Get("Cache-Control"))
+	if (r.Method != http.MethodGet && r.Method != http.MethodHead) || r.Header.Get("Upgrade") != "" || reqCC.has("no-store") {
+		w.Header().Set(CacheStatusHeader, CacheStatusBypass)
+		c.next.ServeHTTP(w, r)
+		return
+	}
+	key := cacheKey(r)
+	noCache := reqCC.has("no-cache") || strings.Contains(r.Header.Get("Pragma"), "no-cache")
+
+	if entry, ok := c.store.get(key, r); ok {
+		now := time.Now()
+		age := entry.age(now)
+		switch {
+		case !noCache && !entry.NoCache && age < entry.Lifetime:
+			serveCacheEntry(w, r, entry, CacheStatusHit)
+			return
+		case !noCache && !entry.NoCache && age < entry.Lifetime+entry.StaleWhileRevalidate:
+			serveCacheEntry(w, r, entry, CacheStatusStale)
+			c.revalidateInBackground(key, r, entry)
+			return
+		case entry.hasValidators():
+			c.revalidate(w, r, key, entry)
+			return
+		}
+	}
+
+	if r.Method == http.MethodHead {
+		w.Header().Set(CacheStatusHeader, CacheStatusMiss)
+		c.next.ServeHTTP(w, r)
+		return
+	}
+	c.fetch(w, r, key)
+}
+
+// join registers the caller as the leader of the upstream request for key, or
+// returns the call already in flight.
+func (c *cacheHandler) join(key string) (*cacheCall, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if call, ok := c.calls[key]; ok {
+		return call, false
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	c.calls[key] = call
+	return call, true
+}
+
+func (c *cacheHandler) finish(key string, call *cacheCall) {
+	c.mu.Lock()
+	delete(c.calls, key)
+	c.mu.Unlock()
+	close(call.done)
+}
+
+func (c *cacheHandler) fetch(w http.ResponseWriter, r *http.Request, key string) {
+	call, leader := c.join(key)
+	if !leader {
+		select {
+		case <-call.done:
+		case <-r.Context().Done():
+			return
+		}
+		if entry, ok := c.store.get(key, r); ok {
+			serveCacheEntry(w, r, entry, CacheStatusHit)
+			return
+		}
+		// The leader got an uncacheable answer, go upstream on our own.
+		w.Header().Set(CacheStatusHeader, CacheStatusMiss)
+		c.next.ServeHTTP(w, r)
+		return
+	}
+	defer c.finish(key, call)
+
+	upstream := r.Clone(r.Context())
+	removeConditionalHeaders(upstream.Header)
+	rec := newCacheRecorder(w, c.maxEntrySize, false, CacheStatusMiss)
+	c.next.ServeHTTP(rec, upstream)
+	if entry := newCacheEntry(r, rec, time.Now()); entry != nil {
+		c.store.put(key, cacheHost(r), r.URL.Path, entry)
+	}
+}
+
+// revalidate asks the backend whether the stored entry is still valid and serves it on 304.
+func (c *cacheHandler) revalidate(w http.ResponseWriter, r *http.Request, key string, entry *cacheEntry) {
+	upstream := r.Clone(r.Context())
+	upstream.Method = http.MethodGet
+	setConditionalHeaders(upstream.Header, entry)
+	rec := newCacheRecorder(w, c.maxEntrySize, true, CacheStatusMiss)
+	c.next.ServeHTTP(rec, upstream)
+
+	now := time.Now()
+	if rec.status == http.StatusNotModified {
+		refreshed := entry.refresh(rec.header, now)
+		c.store.put(key, cacheHost(r), r.URL.Path, refreshed)
+		serveCacheEntry(w, r, refreshed, CacheStatusRevalidated)
+		return
+	}
+	if fresh := newCacheEntry(r, rec, now); fresh != nil {
+		c.store.put(key, cacheHost(r), r.URL.Path, fresh)
+	}
+}
+
+// revalidateInBackground refreshes a stale entry served under stale-while-revalidate.
+func (c *cacheHandler) revalidateInBackground(key string, r *http.Request, entry *cacheEntry) {
+	call, leader := c.join(key)
+	if !leader {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cacheRevalidateTimeout)
+	// httputil.ReverseProxy only aborts on a broken body copy when serving an http.Server.
+	if srv := r.Context().Value(http.ServerContextKey); srv != nil {
+		ctx = context.WithValue(ctx, http.ServerContextKey, srv)
+	}
+	upstream := r.Clone(ctx)
+	upstream.Method = http.MethodGet
+	setConditionalHeaders(upstream.Header, entry)
+	go func() {
+		defer cancel()
+		defer c.finish(key, call)
+		defer func() {
+			if err := recover(); err != nil {
+				events.Logf(events.LOG_ERROR, "[CACHE]: Background revalidation of %s aborted: %v", key, err)
+			}
+		}()
+		rec := newCacheRecorder(nil, c.maxEntrySize, true, "")
+		c.next.ServeHTTP(rec, upstream)
+		now := time.Now()
+		if rec.status == http.StatusNotModified {
+			c.store.put(key, cacheHost(upstream), upstream.URL.Path, entry.refresh(rec.header, now))
+			return
+		}
+		if fresh := newCacheEntry(upstream, rec, now); fresh != nil {
+			c.store.put(key, cacheHost(upstream), upstream.URL.Path, fresh)
+			return
+		}
+		events.Logf(events.LOG_DEBUG, "[CACHE]: Background revalidation of %s returned an uncacheable %d", key, rec.status)
+	}()
+}
+
+func serveCacheEntry(w http.ResponseWriter, r *http.Request, entry *cacheEntry, status string) {
+	h := w.Header()
+	for k, values := range entry.Header {
+		h[k] = append([]string(nil), values...)
+	}
+	h.Set("Age", strconv.FormatInt(int64(entry.age(time.Now()).Seconds()), 10))
+	h.Set(CacheStatusHeader, status)
+
+	if etag := entry.Header.Get("ETag"); etag != "" && entry.Status == http.StatusOK && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Length", strconv.Itoa(len(entry.Body)))
+	w.WriteHeader(entry.Status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(entry.Body); err != nil {
+		events.Logf(events.LOG_ERROR, "[CACHE]: Error writing cached response: %v", err)
+	}
+}
+
+// newCacheEntry builds the entry to store for a recorded response, nil when it must not be stored.
+func newCacheEntry(r *http.Request, rec *cacheRecorder, now time.Time) *cacheEntry {
+	if rec.overflow || !rec.wroteHeader || !cacheableStatuses[rec.status] || rec.truncated() {
+		return nil
+	}
+	h := rec.header
+	cc := parseCacheControl(h.Get("Cache-Control"))
+	if cc.has("no-store") || cc.has("private") || h.Get("Set-Cookie") != "" {
+		return nil
+	}
+	if r.Header.Get("Authorization") != "" && !cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return nil
+	}
+	vary := varyHeaderNames(h)
+	if vary == nil {
+		return nil
+	}
+
+	entry := &cacheEntry{
+		VaryValues: make(map[string]string, len(vary)),
+		Status:     rec.status,
+		Header:     h.Clone(),
+		Body:       bytes.Clone(rec.body.Bytes()),
+		StoredAt:   now,
+		NoCache:    cc.has("no-cache"),
+	}
+	removeHopByHopHeaders(entry.Header)
+	entry.Header.Del(CacheStatusHeader)
+	entry.Header.Del("Age")
+	for _, name := range vary {
+		entry.VaryValues[name] = strings.Join(r.Header.Values(name), ",")
+	}
+	entry.applyFreshness(h, cc)
+	if entry.Lifetime <= 0 && !entry.hasValidators() {
+		return nil
+	}
+	return entry
+}
+
+func (e *cacheEntry) applyFreshness(h http.Header, cc cacheControl) {
+	e.InitialAge = 0
+	if age, err := strconv.ParseInt(h.Get("Age"), 10, 64); err == nil && age > 0 {
+		e.InitialAge = time.Duration(age) * time.Second
+	}
+	e.Lifetime = 0
+	if v, ok := cc.seconds("s-maxage"); ok {
+		e.Lifetime = v
+	} else if v, ok := cc.seconds("max-age"); ok {
+		e.Lifetime = v
+	} else if expires, err := http.ParseTime(h.Get("Expires")); err == nil {
+		date, err := http.ParseTime(h.Get("Date"))
+		if err != nil {
+			date = e.StoredAt
+		}
+		e.Lifetime = expires.Sub(date)
+	}
+	e.StaleWhileRevalidate = 0
+	if v, ok := cc.seconds("stale-while-revalidate"); ok && !cc.has("must-revalidate") {
+		e.StaleWhileRevalidate = v
+	}
+}
+
+// refresh returns a copy of the entry updated with the headers of a 304 answer.
+func (e *cacheEntry) refresh(h http.Header, now time.Time) *cacheEntry {
+	refreshed := *e
+	refreshed.Header = e.Header.Clone()
+	for k, values := range h {
+		if k == "Content-Length" || k == CacheStatusHeader {
+			continue
+		}
+		refreshed.Header[k] = append([]string(nil), values...)
+	}
+	removeHopByHopHeaders(refreshed.Header)
+	refreshed.Header.Del("Age")
+	refreshed.StoredAt = now
+	cc := parseCacheControl(refreshed.Header.Get("Cache-Control"))
+	refreshed.NoCache = cc.has("no-cache")
+	refreshed.applyFreshness(h, cc)
+	if h.Get("Cache-Control") == "" && h.Get("Expires") == "" {
+		refreshed.Lifetime = e.Lifetime
+	}
+	return &refreshed
+}
+
+func cacheKey(r *http.Request) string {
+	// HEAD requests are answered from GET entries.
+	return http.MethodGet + " " + requestScheme(r) + "://" + strings.ToLower(r.Host) + r.URL.RequestURI()
+}
+
+func cacheHost(r *http.Request) string {
+	return strings.ToLower(stripHostPort(r.Host))
+}
+
+// varyHeaderNames returns the canonical names listed in Vary, nil when the response varies on "*".
+func varyHeaderNames(h http.Header) []string {
+	names := []string{}
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil
+			}
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+func removeConditionalHeaders(h http.Header) {
+	for _, name := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"} {
+		h.Del(name)
+	}
+}
+
+func setConditionalHeaders(h http.Header, entry *cacheEntry) {
+	removeConditionalHeaders(h)
+	if etag := entry.Header.Get("ETag"); etag != "" {
+		h.Set("If-None-Match", etag)
+	}
+	if lm := entry.Header.Get("Last-Modified"); lm != "" {
+		h.Set("If-Modified-Since", lm)
+	}
+}
+
+// etagMatches implements the weak comparison used by If-None-Match.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+type cacheControl map[string]string
+
+func parseCacheControl(header string) cacheControl {
+	cc := cacheControl{}
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			cc[name] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+func (cc cacheControl) seconds(directive string) (time.Duration, bool) {
+	v, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// cacheRecorder forwards the backend response to the client (when any) while
+// keeping a bounded copy of it for the cache.
+type cacheRecorder struct {
+	w            http.ResponseWriter
+	header       http.Header
+	status       int
+	body         bytes.Buffer
+	maxBody      int64
+	overflow     bool
+	written      int64 // Body bytes received, compared with the Content-Length of the backend
+	intercept304 bool  // keep 304 answers to our own conditional requests away from the client
+	label        string
+	wroteHeader  bool
+	forwarding   bool
+}
+
+func newCacheRecorder(w http.ResponseWriter, maxBody int64, intercept304 bool, label string) *cacheRecorder {
+	return &cacheRecorder{w: w, header: make(http.Header), maxBody: maxBody, intercept304: intercept304, label: label}
+}
+
+func (rec *cacheRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *cacheRecorder) WriteHeader(code int) {
+	if code >= 100 && code < 200 {
+		return
+	}
+	if rec.wroteHeader {
+		return
+	}
+	rec.wroteHeader = true
+	rec.status = code
+	if rec.w == nil || (rec.intercept304 && code == http.StatusNotModified) {
+		return
+	}
+	h := rec.w.Header()
+	for k, values := range rec.header {
+		h[k] = values
+	}
+	h.Set(CacheStatusHeader, rec.label)
+	rec.w.WriteHeader(code)
+	rec.forwarding = true
+}
+
+func (rec *cacheRecorder) Write(b []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if rec.forwarding {
+		if _, err := rec.w.Write(b); err != nil {
+			return 0, err
+		}
+	}
+	rec.written += int64(len(b))
+	if !rec.overflow {
+		if int64(rec.body.Len()+len(b)) > rec.maxBody {
+			rec.overflow = true
+			rec.body.Reset()
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	return len(b), nil
+}
+
+// truncated reports whether the body ended before the Content-Length announced by the backend.
+func (rec *cacheRecorder) truncated() bool {
+	length, err := strconv.ParseInt(rec.header.Get("Content-Length"), 10, 64)
+	return err == nil && length != rec.written
+}
+
+func (rec *cacheRecorder) Flush() {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.forwarding {
+		return
+	}
+	if f, ok := rec.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
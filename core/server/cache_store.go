@@ -0,0 +1,388 @@
+package server
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/config"
+	"github.com/DoniLite/Mogoly/core/events"
+)
+
+const (
+	CACHE_DIR string = "cache"
+
+	cacheFileExt     = ".cache"
+	cacheMaxVariants = 8
+)
+
+// One stored response, selected among its siblings by the request headers listed in Vary.
+type cacheEntry struct {
+	VaryValues           map[string]string
+	Status               int
+	Header               http.Header
+	Body                 []byte
+	StoredAt             time.Time
+	InitialAge           time.Duration
+	Lifetime             time.Duration
+	StaleWhileRevalidate time.Duration
+	NoCache              bool
+}
+
+// All the variants stored for the same URL, the unit of eviction.
+type cacheBucket struct {
+	Key      string
+	Host     string
+	Path     string
+	Variants []*cacheEntry
+}
+
+func (b *cacheBucket) size() int64 {
+	var n int64
+	for _, e := range b.Variants {
+		n += int64(len(e.Body)) + 512
+		for k, values := range e.Header {
+			n += int64(len(k))
+			for _, v := range values {
+				n += int64(len(v))
+			}
+		}
+	}
+	return n
+}
+
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	return e.InitialAge + now.Sub(e.StoredAt)
+}
+
+func (e *cacheEntry) matches(r *http.Request) bool {
+	for name, value := range e.VaryValues {
+		if strings.Join(r.Header.Values(name), ",") != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *cacheEntry) hasValidators() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+type cacheElement struct {
+	bucket *cacheBucket
+	size   int64
+}
+
+// cacheStore is an LRU bounded in bytes, optionally backed by a directory on disk.
+type cacheStore struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	lru     *list.List
+	items   map[string]*list.Element
+	disk    *cacheDisk
+}
+
+type cacheDisk struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+}
+
+var (
+	cacheStoresMu sync.Mutex
+	cacheStores   = make(map[string]*cacheStore)
+)
+
+// getCacheStore shares a store between the middlewares using the same settings so
+// that rebuilt handlers keep their cache and purges reach every instance.
+func getCacheStore(maxSize int64, diskDir string, maxDiskSize int64) *cacheStore {
+	id := fmt.Sprintf("%d|%s|%d", maxSize, diskDir, maxDiskSize)
+	cacheStoresMu.Lock()
+	defer cacheStoresMu.Unlock()
+	if s, ok := cacheStores[id]; ok {
+		return s
+	}
+	s := &cacheStore{
+		maxSize: maxSize,
+		lru:     list.New(),
+		items:   make(map[string]*list.Element),
+	}
+	if diskDir != "" {
+		if err := os.MkdirAll(diskDir, 0755); err != nil {
+			events.Logf(events.LOG_ERROR, "[CACHE]: Disk tier disabled, cannot create %s: %v", diskDir, err)
+		} else {
+			s.disk = &cacheDisk{dir: diskDir, maxSize: maxDiskSize}
+		}
+	}
+	cacheStores[id] = s
+	return s
+}
+
+// defaultCacheDir returns ~/.mogoly/cache.
+func defaultCacheDir() (string, error) {
+	return config.CreateConfigDir(CACHE_DIR)
+}
+
+// PurgeCache drops the cached responses of host (every host when empty) whose path
+// starts with prefix (every path when empty). It returns the number of purged URLs.
+func PurgeCache(host, prefix string) int {
+	host = strings.ToLower(stripHostPort(host))
+	match := func(h, p string) bool {
+		return (host == "" || h == host) && strings.HasPrefix(p, prefix)
+	}
+	cacheStoresMu.Lock()
+	stores := make([]*cacheStore, 0, len(cacheStores))
+	for _, s := range cacheStores {
+		stores = append(stores, s)
+	}
+	cacheStoresMu.Unlock()
+
+	purged := 0
+	for _, s := range stores {
+		purged += s.purge(match)
+	}
+	events.Logf(events.LOG_INFO, "[CACHE]: Purged %d cached URLs (host: %q, prefix: %q)", purged, host, prefix)
+	return purged
+}
+
+func (s *cacheStore) get(key string, r *http.Request) (*cacheEntry, bool) {
+	s.mu.Lock()
+	var bucket *cacheBucket
+	if el, ok := s.items[key]; ok {
+		s.lru.MoveToFront(el)
+		bucket = el.Value.(*cacheElement).bucket
+	}
+	s.mu.Unlock()
+
+	if bucket == nil && s.disk != nil {
+		loaded, err := s.disk.load(key)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				events.Logf(events.LOG_ERROR, "[CACHE]: Error while reading the disk entry for %s: %v", key, err)
+			}
+			return nil, false
+		}
+		bucket = loaded
+		s.mu.Lock()
+		s.insertLocked(bucket)
+		s.mu.Unlock()
+	}
+	if bucket == nil {
+		return nil, false
+	}
+	for _, e := range bucket.Variants {
+		if e.matches(r) {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+func (s *cacheStore) put(key, host, path string, entry *cacheEntry) {
+	s.mu.Lock()
+	bucket := &cacheBucket{Key: key, Host: host, Path: path}
+	if el, ok := s.items[key]; ok {
+		old := el.Value.(*cacheElement).bucket
+		bucket.Variants = slices.DeleteFunc(slices.Clone(old.Variants), func(e *cacheEntry) bool {
+			return mapsEqual(e.VaryValues, entry.VaryValues)
+		})
+	}
+	bucket.Variants = append([]*cacheEntry{entry}, bucket.Variants...)
+	if len(bucket.Variants) > cacheMaxVariants {
+		bucket.Variants = bucket.Variants[:cacheMaxVariants]
+	}
+	s.insertLocked(bucket)
+	s.mu.Unlock()
+
+	if s.disk != nil {
+		if err := s.disk.save(bucket); err != nil {
+			events.Logf(events.LOG_ERROR, "[CACHE]: Error while writing the disk entry for %s: %v", key, err)
+		}
+	}
+}
+
+// insertLocked replaces the bucket stored under its key and evicts the least recently used ones.
+func (s *cacheStore) insertLocked(bucket *cacheBucket) {
+	elem := &cacheElement{bucket: bucket, size: bucket.size()}
+	if el, ok := s.items[bucket.Key]; ok {
+		s.size -= el.Value.(*cacheElement).size
+		el.Value = elem
+		s.lru.MoveToFront(el)
+	} else {
+		s.items[bucket.Key] = s.lru.PushFront(elem)
+	}
+	s.size += elem.size
+	for s.size > s.maxSize && s.lru.Len() > 0 {
+		oldest := s.lru.Back()
+		evicted := oldest.Value.(*cacheElement)
+		s.lru.Remove(oldest)
+		delete(s.items, evicted.bucket.Key)
+		s.size -= evicted.size
+	}
+}
+
+func (s *cacheStore) purge(match func(host, path string) bool) int {
+	s.mu.Lock()
+	purged := make(map[string]struct{})
+	for key, el := range s.items {
+		elem := el.Value.(*cacheElement)
+		if match(elem.bucket.Host, elem.bucket.Path) {
+			s.lru.Remove(el)
+			delete(s.items, key)
+			s.size -= elem.size
+			purged[key] = struct{}{}
+		}
+	}
+	s.mu.Unlock()
+
+	if s.disk != nil {
+		for _, key := range s.disk.purge(match) {
+			purged[key] = struct{}{}
+		}
+	}
+	return len(purged)
+}
+
+func mapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *cacheDisk) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+cacheFileExt)
+}
+
+func (d *cacheDisk) load(key string) (*cacheBucket, error) {
+	f, err := os.Open(d.path(key))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			events.Logf(events.LOG_ERROR, "[CACHE]: Error while closing the disk entry: %v", err)
+		}
+	}()
+	var bucket cacheBucket
+	if err := gob.NewDecoder(f).Decode(&bucket); err != nil {
+		return nil, err
+	}
+	if bucket.Key != key {
+		return nil, fs.ErrNotExist
+	}
+	return &bucket, nil
+}
+
+func (d *cacheDisk) save(bucket *cacheBucket) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	tmp, err := os.CreateTemp(d.dir, "entry-*.tmp")
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(tmp).Encode(bucket); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), d.path(bucket.Key)); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	d.enforceSizeLocked()
+	return nil
+}
+
+// enforceSizeLocked removes the oldest files until the directory fits in maxSize.
+func (d *cacheDisk) enforceSizeLocked() {
+	if d.maxSize <= 0 {
+		return
+	}
+	type file struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var (
+		files []file
+		total int64
+	)
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		events.Logf(events.LOG_ERROR, "[CACHE]: Error while listing the cache dir: %v", err)
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != cacheFileExt {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{path: filepath.Join(d.dir, e.Name()), size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+	}
+	slices.SortFunc(files, func(a, b file) int { return a.modTime.Compare(b.modTime) })
+	for _, f := range files {
+		if total <= d.maxSize {
+			break
+		}
+		if err := os.Remove(f.path); err == nil {
+			total -= f.size
+		}
+	}
+}
+
+func (d *cacheDisk) purge(match func(host, path string) bool) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		events.Logf(events.LOG_ERROR, "[CACHE]: Error while listing the cache dir: %v", err)
+		return nil
+	}
+	var purged []string
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != cacheFileExt {
+			continue
+		}
+		p := filepath.Join(d.dir, e.Name())
+		f, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+		var bucket cacheBucket
+		err = gob.NewDecoder(f).Decode(&bucket)
+		_ = f.Close()
+		if err != nil || match(bucket.Host, bucket.Path) {
+			if err := os.Remove(p); err == nil && bucket.Key != "" {
+				purged = append(purged, bucket.Key)
+			}
+		}
+	}
+	return purged
+}
@@ -0,0 +1,386 @@
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/DoniLite/Mogoly/core/events"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	EncodingGzip   string = "gzip"
+	EncodingBrotli string = "br"
+	EncodingZstd   string = "zstd"
+
+	compressDefaultMinSize = 1024
+)
+
+var compressDefaultEncodings = []string{EncodingZstd, EncodingBrotli, EncodingGzip}
+
+var compressDefaultIncludeTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/*+json",
+	"application/*+xml",
+	"image/svg+xml",
+}
+
+type CompressMiddlewareConfig struct {
+	Encodings    []string `json:"encodings,omitempty" yaml:"encodings,omitempty"`         // Supported encodings in preference order (zstd, br, gzip)
+	MinSize      int      `json:"min_size,omitempty" yaml:"min_size,omitempty"`           // Responses smaller than this amount of bytes are sent as-is
+	IncludeTypes []string `json:"include_types,omitempty" yaml:"include_types,omitempty"` // Compressible content types, `text/*` style wildcards allowed
+	ExcludeTypes []string `json:"exclude_types,omitempty" yaml:"exclude_types,omitempty"` // Content types never compressed, checked before IncludeTypes
+}
+
+func (c CompressMiddlewareConfig) Validate() error {
+	for _, e := range c.Encodings {
+		if _, ok := compressEncoderPools[strings.ToLower(strings.TrimSpace(e))]; !ok {
+			return fmt.Errorf("unsupported encoding %q", e)
+		}
+	}
+	return nil
+}
+
+// The encoders share this subset of their API.
+type compressEncoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+var compressEncoderPools = map[string]*sync.Pool{
+	EncodingGzip: {New: func() any { return gzip.NewWriter(io.Discard) }},
+	EncodingBrotli: {New: func() any {
+		return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression)
+	}},
+	EncodingZstd: {New: func() any {
+		enc, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedDefault))
+		return enc
+	}},
+}
+
+func getCompressEncoder(encoding string, w io.Writer) compressEncoder {
+	switch enc := compressEncoderPools[encoding].Get().(type) {
+	case *gzip.Writer:
+		enc.Reset(w)
+		return enc
+	case *brotli.Writer:
+		enc.Reset(w)
+		return enc
+	case *zstd.Encoder:
+		enc.Reset(w)
+		return enc
+	}
+	return nil
+}
+
+func putCompressEncoder(encoding string, enc compressEncoder) {
+	compressEncoderPools[encoding].Put(enc)
+}
+
+type compressSettings struct {
+	encodings    []string
+	minSize      int
+	includeTypes []string
+	excludeTypes []string
+}
+
+// CompressMiddleware compresses responses with the best encoding accepted by the client.
+// Bodies are streamed through the encoder, only the first MinSize bytes are buffered.
+func CompressMiddleware(conf CompressMiddlewareConfig) func(next http.Handler) http.Handler {
+	settings := &compressSettings{
+		minSize:      conf.MinSize,
+		includeTypes: conf.IncludeTypes,
+		excludeTypes: conf.ExcludeTypes,
+	}
+	for _, e := range conf.Encodings {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if _, ok := compressEncoderPools[e]; !ok {
+			events.Logf(events.LOG_ERROR, "[COMPRESS]: Ignoring unsupported encoding %q", e)
+			continue
+		}
+		settings.encodings = append(settings.encodings, e)
+	}
+	if len(settings.encodings) == 0 {
+		settings.encodings = compressDefaultEncodings
+	}
+	if settings.minSize <= 0 {
+		settings.minSize = compressDefaultMinSize
+	}
+	if len(settings.includeTypes) == 0 {
+		settings.includeTypes = compressDefaultIncludeTypes
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), settings.encodings)
+			if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, settings: settings, encoding: encoding}
+			defer func() {
+				if err := cw.close(); err != nil {
+					events.Logf(events.LOG_ERROR, "[COMPRESS]: Error while finishing the %s stream: %v", encoding, err)
+				}
+			}()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding picks the supported encoding with the highest q-value in the
+// Accept-Encoding header, ties being broken by the server preference order.
+func negotiateEncoding(header string, supported []string) string {
+	if header == "" {
+		return ""
+	}
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(k), "q") {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		accepted[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range supported {
+		q, ok := accepted[enc]
+		if !ok {
+			if q, ok = accepted["*"]; !ok {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+func (s *compressSettings) compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "" {
+		return false
+	}
+	for _, pattern := range s.excludeTypes {
+		if matchMediaType(pattern, mediaType) {
+			return false
+		}
+	}
+	for _, pattern := range s.includeTypes {
+		if matchMediaType(pattern, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchMediaType matches "text/*", "application/*+json" or exact media types.
+func matchMediaType(pattern, mediaType string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if pattern == "*" || pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	prefix, suffix, ok := strings.Cut(pattern, "*")
+	if !ok {
+		return false
+	}
+	return len(mediaType) >= len(prefix)+len(suffix) && strings.HasPrefix(mediaType, prefix) && strings.HasSuffix(mediaType, suffix)
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+	settings    *compressSettings
+	encoding    string
+	status      int
+	wroteHeader bool // the handler sent its final status
+	decided     bool // the compression decision was taken and the status forwarded
+	compressing bool
+	buf         []byte
+	enc         compressEncoder
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = code
+
+	if !w.eligible() {
+		w.decide(false)
+		return
+	}
+	if w.Header().Get("Content-Type") == "" {
+		return
+	}
+	if cl := w.Header().Get("Content-Length"); cl != "" {
+		if n, err := strconv.Atoi(cl); err == nil {
+			w.decide(n >= w.settings.minSize)
+		}
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided {
+		if w.compressing {
+			return w.enc.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.settings.minSize {
+		if err := w.decideBuffered(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (w *compressWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		// A flushing handler streams its body, compress it whatever its size.
+		if err := w.decideBuffered(true); err != nil {
+			events.Logf(events.LOG_ERROR, "[COMPRESS]: Error while flushing the response: %v", err)
+			return
+		}
+	}
+	if w.compressing {
+		if err := w.enc.Flush(); err != nil {
+			events.Logf(events.LOG_ERROR, "[COMPRESS]: Error while flushing the %s encoder: %v", w.encoding, err)
+			return
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("hijack not supported by the underlying response writer")
+}
+
+// Unwrap lets http.ResponseController reach the original writer.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// eligible reports whether the response can be compressed at all, regardless of its size.
+func (w *compressWriter) eligible() bool {
+	h := w.Header()
+	switch {
+	case w.status < 200 || w.status == http.StatusNoContent || w.status == http.StatusNotModified || w.status == http.StatusPartialContent:
+		return false
+	case h.Get("Content-Encoding") != "" && !strings.EqualFold(h.Get("Content-Encoding"), "identity"):
+		return false
+	case strings.Contains(strings.ToLower(h.Get("Cache-Control")), "no-transform"):
+		return false
+	}
+	// Without a type yet, the decision waits for the body to be sniffed.
+	return h.Get("Content-Type") == "" || w.settings.compressibleType(h.Get("Content-Type"))
+}
+
+// decideBuffered takes the decision once some body bytes are known and writes them out.
+func (w *compressWriter) decideBuffered(compress bool) error {
+	if w.Header().Get("Content-Type") == "" && len(w.buf) > 0 {
+		w.Header().Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	w.decide(compress && w.settings.compressibleType(w.Header().Get("Content-Type")))
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.compressing {
+		_, err = w.enc.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (w *compressWriter) decide(compress bool) {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	h := w.Header()
+	if w.eligibleType() {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if compress {
+		w.compressing = true
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		// The representation changed, a strong validator would be wrong.
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		w.enc = getCompressEncoder(w.encoding, w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+func (w *compressWriter) eligibleType() bool {
+	ct := w.Header().Get("Content-Type")
+	return ct != "" && w.settings.compressibleType(ct) && w.Header().Get("Content-Encoding") == ""
+}
+
+// close terminates the response once the handler returned.
+func (w *compressWriter) close() error {
+	if !w.wroteHeader {
+		// Nothing was written by the handler, net/http will send an empty 200.
+		return nil
+	}
+	if !w.decided {
+		if err := w.decideBuffered(false); err != nil {
+			return err
+		}
+	}
+	if !w.compressing {
+		return nil
+	}
+	err := w.enc.Close()
+	putCompressEncoder(w.encoding, w.enc)
+	w.enc = nil
+	return err
+}
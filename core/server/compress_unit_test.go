@@ -0,0 +1,157 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+var compressTestBody = strings.Repeat(`{"id":42,"name":"mogoly","tags":["proxy","balancer"]},`, 200)
+
+func TestNegotiateEncoding(t *testing.T) {
+	supported := []string{EncodingZstd, EncodingBrotli, EncodingGzip}
+	cases := []struct{ header, want string }{
+		{"", ""},
+		{"gzip", EncodingGzip},
+		{"gzip, br", EncodingBrotli},
+		{"gzip;q=1.0, br;q=0.5", EncodingGzip},
+		{"br;q=0, gzip;q=0.1", EncodingGzip},
+		{"*", EncodingZstd},
+		{"identity", ""},
+	}
+	for _, c := range cases {
+		if got := negotiateEncoding(c.header, supported); got != c.want {
+			t.Fatalf("negotiateEncoding(%q)=%q want %q", c.header, got, c.want)
+		}
+	}
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	h := CompressMiddleware(CompressMiddlewareConfig{MinSize: 100})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"ok":true}`)
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = io.WriteString(w, compressTestBody)
+		case "/encoded":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = io.WriteString(w, compressTestBody)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", `"v1"`)
+			// write in chunks to exercise the streaming path
+			for i := 0; i < len(compressTestBody); i += 64 {
+				_, _ = io.WriteString(w, compressTestBody[i:min(i+64, len(compressTestBody))])
+			}
+		}
+	}))
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		EncodingGzip: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		EncodingBrotli: func(r io.Reader) (io.Reader, error) {
+			return brotli.NewReader(r), nil
+		},
+		EncodingZstd: func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	}
+	for encoding, decode := range decoders {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Header().Get("Content-Encoding") != encoding {
+			t.Fatalf("%s: unexpected Content-Encoding %q", encoding, rr.Header().Get("Content-Encoding"))
+		}
+		if rr.Header().Get("ETag") != `W/"v1"` || rr.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("%s: unexpected headers %v", encoding, rr.Header())
+		}
+		dr, err := decode(rr.Body)
+		if err != nil {
+			t.Fatalf("%s: decoder: %v", encoding, err)
+		}
+		got, err := io.ReadAll(dr)
+		if err != nil || string(got) != compressTestBody {
+			t.Fatalf("%s: body mismatch (err %v)", encoding, err)
+		}
+	}
+
+	for _, path := range []string{"/small", "/image", "/encoded"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if path != "/encoded" && rr.Header().Get("Content-Encoding") != "" {
+			t.Fatalf("%s should not be compressed", path)
+		}
+		if path == "/encoded" && rr.Body.String() != compressTestBody {
+			t.Fatalf("already encoded body must be left untouched")
+		}
+	}
+}
+
+func benchmarkProxyCompression(b *testing.B, middlewares []Middleware, acceptEncoding string) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, compressTestBody)
+	}))
+	defer backend.Close()
+
+	s := &Server{Name: "bench", URL: backend.URL, Middlewares: middlewares}
+	var mws []func(http.Handler) http.Handler
+	for _, m := range middlewares {
+		fn, _ := LookupMiddlewareFunc(MiddleWareName(m.Name))
+		mws = append(mws, fn(m.Config))
+	}
+	h := ChainMiddleware(s, mws...)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(compressTestBody)))
+	b.ResetTimer()
+	for range b.N {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || rr.Body.Len() == 0 {
+			b.Fatalf("unexpected response %d", rr.Code)
+		}
+	}
+}
+
+func BenchmarkProxy_Uncompressed(b *testing.B) {
+	benchmarkProxyCompression(b, nil, "")
+}
+
+func BenchmarkProxy_CompressGzip(b *testing.B) {
+	benchmarkProxyCompression(b, []Middleware{{Name: string(MogolyCompress)}}, EncodingGzip)
+}
+
+func BenchmarkProxy_CompressBrotli(b *testing.B) {
+	benchmarkProxyCompression(b, []Middleware{{Name: string(MogolyCompress)}}, EncodingBrotli)
+}
+
+func BenchmarkProxy_CompressZstd(b *testing.B) {
+	benchmarkProxyCompression(b, []Middleware{{Name: string(MogolyCompress)}}, EncodingZstd)
+}
+
+func BenchmarkCompressWriter_Gzip(b *testing.B) {
+	h := CompressMiddleware(CompressMiddlewareConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, compressTestBody)
+	}))
+	b.ReportAllocs()
+	b.SetBytes(int64(len(compressTestBody)))
+	for range b.N {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", EncodingGzip)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/DoniLite/Mogoly/core/events"
+)
+
+var corsDefaultMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+type CORSMiddlewareConfig struct {
+	AllowedOrigins   []string `json:"allowed_origins,omitempty" yaml:"allowed_origins,omitempty"`     // Exact origins or globs such as `https://*.test`, `*` allows any origin
+	AllowedMethods   []string `json:"allowed_methods,omitempty" yaml:"allowed_methods,omitempty"`     // Methods allowed on preflight, GET, HEAD, POST, PUT, PATCH and DELETE by default
+	AllowedHeaders   []string `json:"allowed_headers,omitempty" yaml:"allowed_headers,omitempty"`     // Request headers allowed on preflight, `*` echoes the requested ones
+	ExposedHeaders   []string `json:"exposed_headers,omitempty" yaml:"exposed_headers,omitempty"`     // Response headers readable by the browser
+	AllowCredentials bool     `json:"allow_credentials,omitempty" yaml:"allow_credentials,omitempty"` // Allows cookies and authorization headers
+	MaxAge           int      `json:"max_age,omitempty" yaml:"max_age,omitempty"`                     // Seconds a preflight answer can be cached by the browser
+}
+
+func (c CORSMiddlewareConfig) Validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if _, err := path.Match(strings.ToLower(origin), ""); err != nil {
+			return fmt.Errorf("invalid origin pattern %q: %w", origin, err)
+		}
+	}
+	return nil
+}
+
+type corsSettings struct {
+	origins     []string
+	anyOrigin   bool
+	methods     string
+	headers     map[string]bool
+	anyHeader   bool
+	exposed     string
+	credentials bool
+	maxAge      string
+}
+
+// CORSMiddleware adds the CORS response headers for allowed origins and answers
+// the preflight OPTIONS requests without reaching the backend.
+func CORSMiddleware(conf CORSMiddlewareConfig) func(next http.Handler) http.Handler {
+	settings := &corsSettings{
+		headers:     make(map[string]bool),
+		exposed:     strings.Join(conf.ExposedHeaders, ", "),
+		credentials: conf.AllowCredentials,
+	}
+	for _, origin := range conf.AllowedOrigins {
+		origin = strings.ToLower(strings.TrimSpace(strings.TrimSuffix(origin, "/")))
+		if origin == "*" {
+			settings.anyOrigin = true
+			continue
+		}
+		if _, err := path.Match(origin, ""); err != nil {
+			events.Logf(events.LOG_ERROR, "[CORS]: Ignoring invalid origin pattern %q: %v", origin, err)
+			continue
+		}
+		settings.origins = append(settings.origins, origin)
+	}
+	methods := conf.AllowedMethods
+	if len(methods) == 0 {
+		methods = corsDefaultMethods
+	}
+	upper := make([]string, 0, len(methods))
+	for _, m := range methods {
+		upper = append(upper, strings.ToUpper(strings.TrimSpace(m)))
+	}
+	settings.methods = strings.Join(upper, ", ")
+	for _, h := range conf.AllowedHeaders {
+		if h == "*" {
+			settings.anyHeader = true
+			continue
+		}
+		settings.headers[http.CanonicalHeaderKey(strings.TrimSpace(h))] = true
+	}
+	if conf.MaxAge > 0 {
+		settings.maxAge = strconv.Itoa(conf.MaxAge)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			if !settings.allowOrigin(origin) {
+				if preflight {
+					// Without the CORS headers the browser rejects the actual request.
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !preflight {
+				// Set once the backend answered so that its own CORS headers are replaced, not duplicated.
+				hw := newHeaderHookWriter(w, func(h http.Header, status int) {
+					settings.setOrigin(h, origin)
+					if settings.exposed != "" {
+						h.Set("Access-Control-Expose-Headers", settings.exposed)
+					}
+				})
+				next.ServeHTTP(hw, r)
+				hw.finish()
+				return
+			}
+
+			settings.setOrigin(h, origin)
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", settings.methods)
+			if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+				if allowed := settings.allowedHeaders(requested); allowed != "" {
+					h.Set("Access-Control-Allow-Headers", allowed)
+				}
+			}
+			if settings.maxAge != "" {
+				h.Set("Access-Control-Max-Age", settings.maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+func (s *corsSettings) allowOrigin(origin string) bool {
+	if s.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, pattern := range s.origins {
+		if pattern == origin {
+			return true
+		}
+		if ok, _ := path.Match(pattern, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *corsSettings) setOrigin(h http.Header, origin string) {
+	// A wildcard origin is not accepted by browsers on credentialed requests.
+	if s.anyOrigin && !s.credentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if s.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	} else {
+		h.Del("Access-Control-Allow-Credentials")
+	}
+}
+
+// allowedHeaders filters the headers requested on preflight down to the allowed ones.
+func (s *corsSettings) allowedHeaders(requested string) string {
+	var allowed []string
+	for _, name := range strings.Split(requested, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if s.anyHeader || s.headers[http.CanonicalHeaderKey(name)] {
+			allowed = append(allowed, name)
+		}
+	}
+	return strings.Join(allowed, ", ")
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"html"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/DoniLite/Mogoly/core/events"
+)
+
+// ErrorPage holds the templates rendered for one status. Templates accept the
+// {status}, {status_text}, {message}, {host}, {path}, {request_id} and
+// {retry_after} placeholders, values are escaped for the output format.
+type ErrorPage struct {
+	HTML     string `json:"html,omitempty" yaml:"html,omitempty"`           // Inline HTML template
+	HTMLFile string `json:"html_file,omitempty" yaml:"html_file,omitempty"` // HTML template read from a file, used when HTML is empty
+	JSON     string `json:"json,omitempty" yaml:"json,omitempty"`           // JSON template, sent to clients preferring application/json
+}
+
+type ErrorPagesConfig struct {
+	Pages            map[int]*ErrorPage `json:"pages,omitempty" yaml:"pages,omitempty"`                         // Templates by status (404, 502, 503, 504)
+	InterceptBackend bool               `json:"intercept_backend,omitempty" yaml:"intercept_backend,omitempty"` // Also replaces the backend answers having a configured status
+}
+
+type MaintenanceConfig struct {
+	Enabled    bool     `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	RetryAfter int      `json:"retry_after,omitempty" yaml:"retry_after,omitempty"` // Seconds sent in the Retry-After header
+	AllowIPs   []string `json:"allow_ips,omitempty" yaml:"allow_ips,omitempty"`     // IPs or CIDRs still reaching the backends
+	Message    string   `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+const maintenanceDefaultMessage = "The service is under maintenance, please retry later."
+
+type errorPageServerKey struct{}
+
+// SetMaintenance replaces the maintenance settings of the server, nil disables it.
+func (server *Server) SetMaintenance(conf *MaintenanceConfig) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.Maintenance = conf
+}
+
+func (server *Server) maintenance() *MaintenanceConfig {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.Maintenance
+}
+
+// MaintenanceHandler answers 503 with Retry-After while the server is in
+// maintenance, except to the allowed IPs which reach next.
+func (server *Server) MaintenanceHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := server.maintenance()
+		if m == nil || !m.Enabled || ipAllowed(clientIP(r.RemoteAddr), m.AllowIPs) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if m.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(m.RetryAfter))
+		}
+		message := m.Message
+		if message == "" {
+			message = maintenanceDefaultMessage
+		}
+		server.ServeError(w, r, http.StatusServiceUnavailable, message)
+	})
+}
+
+func ipAllowed(ip string, allowList []string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, entry := range allowList {
+		entry = strings.TrimSpace(entry)
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			if prefix.Contains(addr) {
+				return true
+			}
+			continue
+		}
+		if allowed, err := netip.ParseAddr(entry); err == nil && allowed.Unmap() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func withErrorPageServer(ctx context.Context, server *Server) context.Context {
+	return context.WithValue(ctx, errorPageServerKey{}, server)
+}
+
+// ServeError answers status with the page configured for it, or with message as plain text.
+func (server *Server) ServeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	page := server.errorPage(status)
+	if page == nil {
+		http.Error(w, message, status)
+		return
+	}
+	body, contentType := page.render(r, status, message, w.Header().Get("Retry-After"))
+	if body == nil {
+		http.Error(w, message, status)
+		return
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(body); err != nil {
+		events.Logf(events.LOG_ERROR, "[ERROR_PAGES]: Error while writing the %d page: %v", status, err)
+	}
+}
+
+func (server *Server) errorPage(status int) *ErrorPage {
+	if server == nil || server.ErrorPages == nil {
+		return nil
+	}
+	return server.ErrorPages.Pages[status]
+}
+
+// render picks the JSON or HTML template following the Accept header.
+func (p *ErrorPage) render(r *http.Request, status int, message, retryAfter string) ([]byte, string) {
+	htmlTemplate := p.HTML
+	if htmlTemplate == "" && p.HTMLFile != "" {
+		raw, err := os.ReadFile(p.HTMLFile)
+		if err != nil {
+			events.Logf(events.LOG_ERROR, "[ERROR_PAGES]: Cannot read %s: %v", p.HTMLFile, err)
+		}
+		htmlTemplate = string(raw)
+	}
+	requestID := RequestIDFromContext(r.Context())
+	if requestID == "" {
+		requestID = r.Header.Get(RequestIDDefaultHeader)
+	}
+	values := []string{
+		"{status}", strconv.Itoa(status),
+		"{status_text}", http.StatusText(status),
+		"{message}", message,
+		"{host}", r.Host,
+		"{path}", r.URL.Path,
+		"{request_id}", requestID,
+		"{retry_after}", retryAfter,
+	}
+
+	wantJSON := p.JSON != "" && (htmlTemplate == "" || prefersJSON(r.Header.Get("Accept")))
+	switch {
+	case wantJSON:
+		for i := 1; i < len(values); i += 2 {
+			quoted, _ := json.Marshal(values[i])
+			values[i] = string(quoted[1 : len(quoted)-1])
+		}
+		return []byte(strings.NewReplacer(values...).Replace(p.JSON)), "application/json; charset=utf-8"
+	case htmlTemplate != "":
+		for i := 1; i < len(values); i += 2 {
+			values[i] = html.EscapeString(values[i])
+		}
+		return []byte(strings.NewReplacer(values...).Replace(htmlTemplate)), "text/html; charset=utf-8"
+	}
+	return nil, ""
+}
+
+// prefersJSON reports whether the Accept header ranks application/json above text/html.
+func prefersJSON(accept string) bool {
+	jsonQ, htmlQ := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch {
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			jsonQ = max(jsonQ, q)
+		case mediaType == "text/html":
+			htmlQ = max(htmlQ, q)
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
+
+// errorPageWriter replaces the backend answers having a configured error page.
+type errorPageWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	server      *Server
+	intercepted bool
+	wroteHeader bool
+}
+
+func (w *errorPageWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.server.errorPage(code) != nil {
+		w.intercepted = true
+		w.server.ServeError(w.ResponseWriter, w.r, code, http.StatusText(code))
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorPageWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.intercepted {
+		// The backend body is dropped in favour of the error page.
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorPageWriter) Flush() {
+	if w.intercepted {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *errorPageWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("hijack not supported by the underlying response writer")
+}
+
+// Unwrap lets http.ResponseController reach the original writer.
+func (w *errorPageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/events"
+)
+
+const (
+	forwardAuthDefaultTimeout     = 5 * time.Second
+	forwardAuthDefaultTokenHeader = "Authorization"
+	forwardAuthMaxBodySize        = 1 << 20
+	forwardAuthDefaultCacheSize   = 1024
+)
+
+const (
+	ForwardAuthCacheKeyRequest string = "request" // A decision is reused for the same token, method, host and URI
+	ForwardAuthCacheKeyToken   string = "token"   // A decision is reused for every request of the token
+)
+
+// Headers that only make sense for a single connection and must not be
+// relayed between the client, the auth service and the backend.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+type ForwardAuthMiddlewareConfig struct {
+	Address             string        `json:"address" yaml:"address"`                                                 // The auth endpoint receiving the request metadata
+	Timeout             time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`                             // Maximum time spent waiting for the auth service
+	TokenHeader         string        `json:"token_header,omitempty" yaml:"token_header,omitempty"`                   // The request header identifying the caller, used as cache key
+	CacheTTL            time.Duration `json:"cache_ttl,omitempty" yaml:"cache_ttl,omitempty"`                         // How long an allow decision is reused, 0 disables caching
+	CacheKey            string        `json:"cache_key,omitempty" yaml:"cache_key,omitempty"`                         // request (default) or token, what an allow decision is reused for
+	CacheSize           int           `json:"cache_size,omitempty" yaml:"cache_size,omitempty"`                       // Maximum number of cached decisions, 1024 by default
+	AuthRequestHeaders  []string      `json:"auth_request_headers,omitempty" yaml:"auth_request_headers,omitempty"`   // Headers sent to the auth service, all of them when empty
+	AuthResponseHeaders []string      `json:"auth_response_headers,omitempty" yaml:"auth_response_headers,omitempty"` // Auth response headers copied on the upstream request
+}
+
+func (c ForwardAuthMiddlewareConfig) Validate() error {
+	if c.Address == "" {
+		return errors.New("address is required")
+	}
+	if u, err := url.Parse(c.Address); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid address %q", c.Address)
+	}
+	switch c.CacheKey {
+	case "", ForwardAuthCacheKeyRequest, ForwardAuthCacheKeyToken:
+	default:
+		return fmt.Errorf("unknown cache_key %q, expected request or token", c.CacheKey)
+	}
+	if c.CacheSize < 0 {
+		return errors.New("cache_size must not be negative")
+	}
+	return nil
+}
+
+type forwardAuthResult struct {
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// forwardAuthCache keeps the allow decisions, up to size of them.
+type forwardAuthCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*forwardAuthResult
+}
+
+func (c *forwardAuthCache) get(key string) (*forwardAuthResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(res.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return res, true
+}
+
+func (c *forwardAuthCache) set(key string, res *forwardAuthResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.size {
+		// Sweeping the expired decisions, the one expiring first makes room otherwise.
+		now := time.Now()
+		var oldest string
+		for k, v := range c.entries {
+			if now.After(v.expires) {
+				delete(c.entries, k)
+			} else if oldest == "" || v.expires.Before(c.entries[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(c.entries) >= c.size {
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[key] = res
+}
+
+// forwardAuthCacheKey returns the key of the decision for the request, empty when the
+// request carries no token.
+func forwardAuthCacheKey(conf *ForwardAuthMiddlewareConfig, r *http.Request) string {
+	token := r.Header.Get(conf.TokenHeader)
+	if token == "" || conf.CacheKey == ForwardAuthCacheKeyToken {
+		return token
+	}
+	return strings.Join([]string{token, r.Method, r.Host, r.URL.RequestURI()}, "\x00")
+}
+
+// ForwardAuthMiddleware delegates the authentication decision to an external HTTP service.
+// A 2xx answer lets the request through, any other answer is returned to the client as-is.
+func ForwardAuthMiddleware(conf ForwardAuthMiddlewareConfig) func(next http.Handler) http.Handler {
+	if conf.Timeout <= 0 {
+		conf.Timeout = forwardAuthDefaultTimeout
+	}
+	if conf.TokenHeader == "" {
+		conf.TokenHeader = forwardAuthDefaultTokenHeader
+	}
+
+	client := &http.Client{
+		Timeout: conf.Timeout,
+		// Redirects (e.g. to a login page) are decisions to hand back to the client.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	if conf.CacheSize <= 0 {
+		conf.CacheSize = forwardAuthDefaultCacheSize
+	}
+	cache := &forwardAuthCache{size: conf.CacheSize, entries: make(map[string]*forwardAuthResult)}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if conf.Address == "" {
+				events.Logf(events.LOG_ERROR, "[FORWARD_AUTH]: No auth address configured for %s", r.Host)
+				http.Error(w, "Authentication service not configured", http.StatusInternalServerError)
+				return
+			}
+
+			var (
+				key string
+				res *forwardAuthResult
+				hit bool
+			)
+			if conf.CacheTTL > 0 {
+				key = forwardAuthCacheKey(&conf, r)
+			}
+			if key != "" {
+				res, hit = cache.get(key)
+			}
+			if !hit {
+				var err error
+				res, err = forwardAuthRequest(client, &conf, r)
+				if err != nil {
+					events.Logf(events.LOG_ERROR, "[FORWARD_AUTH]: Error while calling the auth service %s: %v", conf.Address, err)
+					http.Error(w, "Authentication service unavailable", http.StatusBadGateway)
+					return
+				}
+				// Denials are not cached, the auth service sees every attempt.
+				if key != "" && res.status >= 200 && res.status < 300 {
+					res.expires = time.Now().Add(conf.CacheTTL)
+					cache.set(key, res)
+				}
+			}
+
+			if res.status >= 200 && res.status < 300 {
+				for _, name := range conf.AuthResponseHeaders {
+					key := textproto.CanonicalMIMEHeaderKey(name)
+					if values, ok := res.header[key]; ok {
+						r.Header[key] = append([]string(nil), values...)
+					} else {
+						r.Header.Del(key)
+					}
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			events.Logf(events.LOG_INFO, "[FORWARD_AUTH]: Request %s %s denied with status %d", r.Method, r.URL.RequestURI(), res.status)
+			for k, values := range res.header {
+				w.Header()[k] = append([]string(nil), values...)
+			}
+			w.WriteHeader(res.status)
+			if _, err := w.Write(res.body); err != nil {
+				events.Logf(events.LOG_ERROR, "[FORWARD_AUTH]: Error writing response: %v", err)
+			}
+		})
+	}
+}
+
+func forwardAuthRequest(client *http.Client, conf *ForwardAuthMiddlewareConfig, r *http.Request) (*forwardAuthResult, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, conf.Address, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(conf.AuthRequestHeaders) == 0 {
+		req.Header = r.Header.Clone()
+	} else {
+		for _, name := range conf.AuthRequestHeaders {
+			if values := r.Header.Values(name); len(values) > 0 {
+				req.Header[textproto.CanonicalMIMEHeaderKey(name)] = append([]string(nil), values...)
+			}
+		}
+	}
+	removeHopByHopHeaders(req.Header)
+
+	req.Header.Set("X-Forwarded-Method", r.Method)
+	req.Header.Set("X-Forwarded-Proto", requestScheme(r))
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	req.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	req.Header.Set("X-Forwarded-For", clientIP(r.RemoteAddr))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			events.Logf(events.LOG_ERROR, "[FORWARD_AUTH]: Error while closing the body reader: %v", err)
+		}
+	}()
+	body, err := io.ReadAll(io.LimitReader(res.Body, forwardAuthMaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	header := res.Header.Clone()
+	removeHopByHopHeaders(header)
+	header.Del("Content-Length")
+	return &forwardAuthResult{status: res.StatusCode, header: header, body: body}, nil
+}
+
+func removeHopByHopHeaders(h http.Header) {
+	for _, name := range hopByHopHeaders {
+		h.Del(name)
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/events"
+)
+
+const (
+	HeadersPresetSecurity string = "security"
+)
+
+type HeaderRules struct {
+	Add    map[string]string `json:"add,omitempty" yaml:"add,omitempty"`       // Values appended to the existing ones
+	Set    map[string]string `json:"set,omitempty" yaml:"set,omitempty"`       // Values replacing the existing ones
+	Remove []string          `json:"remove,omitempty" yaml:"remove,omitempty"` // Header names to drop
+}
+
+// SecurityHeadersConfig overrides the values of the security preset, empty fields keep the defaults.
+type SecurityHeadersConfig struct {
+	HSTSMaxAge            time.Duration `json:"hsts_max_age,omitempty" yaml:"hsts_max_age,omitempty"`
+	HSTSIncludeSubdomains *bool         `json:"hsts_include_subdomains,omitempty" yaml:"hsts_include_subdomains,omitempty"`
+	HSTSPreload           bool          `json:"hsts_preload,omitempty" yaml:"hsts_preload,omitempty"`
+	FrameOptions          string        `json:"frame_options,omitempty" yaml:"frame_options,omitempty"`
+	ContentSecurityPolicy string        `json:"content_security_policy,omitempty" yaml:"content_security_policy,omitempty"`
+	ReferrerPolicy        string        `json:"referrer_policy,omitempty" yaml:"referrer_policy,omitempty"`
+}
+
+type HeadersMiddlewareConfig struct {
+	Preset   string                `json:"preset,omitempty" yaml:"preset,omitempty"`     // Optional preset, only `security` is supported
+	Security SecurityHeadersConfig `json:"security,omitempty" yaml:"security,omitempty"` // Overrides for the security preset
+	Request  HeaderRules           `json:"request,omitempty" yaml:"request,omitempty"`   // Rules applied to the request sent upstream
+	Response HeaderRules           `json:"response,omitempty" yaml:"response,omitempty"` // Rules applied to the response sent to the client
+}
+
+func (c HeadersMiddlewareConfig) Validate() error {
+	if c.Preset != "" && c.Preset != HeadersPresetSecurity {
+		return fmt.Errorf("unknown headers preset %q", c.Preset)
+	}
+	return nil
+}
+
+// HeadersMiddleware adds, sets or removes request and response headers. Values can
+// reference the {client_ip}, {request_id}, {host}, {method}, {path} and {scheme} placeholders.
+func HeadersMiddleware(conf HeadersMiddlewareConfig) func(next http.Handler) http.Handler {
+
+	var security map[string]string
+	switch conf.Preset {
+	case "":
+	case HeadersPresetSecurity:
+		security = buildSecurityHeaders(conf.Security)
+	default:
+		events.Logf(events.LOG_ERROR, "[HEADERS]: Unknown headers preset %q", conf.Preset)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			applyHeaderRules(r.Header, conf.Request, r)
+
+			if security == nil && isEmptyHeaderRules(conf.Response) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			hw := newHeaderHookWriter(w, func(h http.Header, status int) {
+				for k, v := range security {
+					// HSTS is ignored by browsers over plain HTTP
+					if k == "Strict-Transport-Security" && r.TLS == nil {
+						continue
+					}
+					if h.Get(k) == "" {
+						h.Set(k, v)
+					}
+				}
+				applyHeaderRules(h, conf.Response, r)
+			})
+			next.ServeHTTP(hw, r)
+			hw.finish()
+		})
+	}
+}
+
+func buildSecurityHeaders(conf SecurityHeadersConfig) map[string]string {
+	maxAge := conf.HSTSMaxAge
+	if maxAge <= 0 {
+		maxAge = 365 * 24 * time.Hour
+	}
+	hsts := fmt.Sprintf("max-age=%d", int64(maxAge.Seconds()))
+	if conf.HSTSIncludeSubdomains == nil || *conf.HSTSIncludeSubdomains {
+		hsts += "; includeSubDomains"
+	}
+	if conf.HSTSPreload {
+		hsts += "; preload"
+	}
+	headers := map[string]string{
+		"Strict-Transport-Security": hsts,
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Content-Security-Policy":   "default-src 'self'",
+		"Referrer-Policy":           "strict-origin-when-cross-origin",
+	}
+	if conf.FrameOptions != "" {
+		headers["X-Frame-Options"] = conf.FrameOptions
+	}
+	if conf.ContentSecurityPolicy != "" {
+		headers["Content-Security-Policy"] = conf.ContentSecurityPolicy
+	}
+	if conf.ReferrerPolicy != "" {
+		headers["Referrer-Policy"] = conf.ReferrerPolicy
+	}
+	return headers
+}
+
+func isEmptyHeaderRules(rules HeaderRules) bool {
+	return len(rules.Add) == 0 && len(rules.Set) == 0 && len(rules.Remove) == 0
+}
+
+func applyHeaderRules(h http.Header, rules HeaderRules, r *http.Request) {
+	for _, name := range rules.Remove {
+		h.Del(name)
+	}
+	for k, v := range rules.Set {
+		h.Set(k, expandHeaderTemplate(v, r))
+	}
+	for k, v := range rules.Add {
+		h.Add(k, expandHeaderTemplate(v, r))
+	}
+}
+
+func expandHeaderTemplate(value string, r *http.Request) string {
+	if !strings.Contains(value, "{") {
+		return value
+	}
+	requestID := RequestIDFromContext(r.Context())
+	if requestID == "" {
+		requestID = r.Header.Get(RequestIDDefaultHeader)
+	}
+	return strings.NewReplacer(
+		"{client_ip}", clientIP(r.RemoteAddr),
+		"{request_id}", requestID,
+		"{host}", r.Host,
+		"{method}", r.Method,
+		"{path}", r.URL.Path,
+		"{scheme}", requestScheme(r),
+	).Replace(value)
+}
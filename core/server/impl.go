@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"reflect"
 	"slices"
 	"time"
 
@@ -12,6 +14,22 @@ import (
 	"github.com/DoniLite/Mogoly/core/events"
 )
 
+// MarshalYAML encodes a copy of the exported fields taken under the server lock, so
+// that the config can be persisted while requests and health checks update it.
+func (server *Server) MarshalYAML() (any, error) {
+	type plain Server
+	clone := &plain{}
+	server.mu.Lock()
+	src, dst := reflect.ValueOf(server).Elem(), reflect.ValueOf(clone).Elem()
+	for i := range src.NumField() {
+		if src.Type().Field(i).IsExported() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	server.mu.Unlock()
+	return clone, nil
+}
+
 // UpgradeProxy ensures server.Proxy is initialized for this server.
 func (server *Server) UpgradeProxy() error {
 	if server == nil {
@@ -87,7 +105,7 @@ func (server *Server) CheckHealthAll() (*HealthCheckStatus, error) {
 			continue
 		}
 		checkStart := time.Now()
-		success, err := HealthChecker(target)
+		success, err := server.healthChecker()(target)
 		u, _ := BuildServerURL(target)
 
 		target.mu.Lock()
@@ -120,14 +138,14 @@ func (server *Server) CheckHealthAny(name string) (*ServerStatus, error) {
 		return nil, fmt.Errorf("no server found for name %q", name)
 	}
 	u, _ := BuildServerURL(target)
-	success, err := HealthChecker(target)
+	success, err := server.healthChecker()(target)
 	healthy := err == nil && success
 	return &ServerStatus{Name: target.Name, Url: u, Healthy: healthy}, err
 }
 
 func (server *Server) CheckHealthSelf() (*ServerStatus, error) {
 	u, _ := BuildServerURL(server)
-	success, err := HealthChecker(server)
+	success, err := server.healthChecker()(server)
 	healthy := err == nil && success
 	return &ServerStatus{Name: server.Name, Url: u, Healthy: healthy}, err
 }
@@ -184,36 +202,36 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		err     error
 	)
 
-	server.logf(events.LOG_INFO, "[Proxy]: New incoming request <- %s Method for %s: %s", r.URL.Path, r.Method, server.Name)
-	server.logf(events.LOG_INFO, "[Load Balancer]: Selecting next server for the %s proxy", server.Name)
+	server.logRequestf(r, events.LOG_INFO, "[Proxy]: New incoming request <- %s Method for %s: %s", r.URL.Path, r.Method, server.Name)
+	server.logRequestf(r, events.LOG_INFO, "[Load Balancer]: Selecting next server for the %s proxy", server.Name)
 
 	if len(server.BalancingServers) > 0 {
 		strategy := config.GetEnv(config.BALANCER_STRATEGY, string(RoundRobin))
 		backend, err = server.GetNextServer(ServerStrategy(strategy))
 		if err != nil {
-			server.logf(events.LOG_ERROR, "[Load Balancer] error for the %s server: %v", server.Name, err)
-			http.Error(w, "No backend available", http.StatusServiceUnavailable)
+			server.logRequestf(r, events.LOG_ERROR, "[Load Balancer] error for the %s server: %v", server.Name, err)
+			server.ServeError(w, r, http.StatusServiceUnavailable, "No backend available")
 			return
 		}
 		if upErr := backend.UpgradeProxy(); upErr != nil {
-			server.logf(events.LOG_ERROR, "failed to init backend proxy for the %s server: %v", backend.Name, upErr)
-			http.Error(w, "No proxy service available", http.StatusInternalServerError)
+			server.logRequestf(r, events.LOG_ERROR, "failed to init backend proxy for the %s server: %v", backend.Name, upErr)
+			server.ServeError(w, r, http.StatusInternalServerError, "No proxy service available")
 			return
 		}
 	} else {
 		// Single-node mode: proxy to self
 		backend = server
 		if upErr := server.UpgradeProxy(); upErr != nil {
-			server.logf(events.LOG_ERROR, "failed to init proxy for the %s server: %v", server.Name, upErr)
-			http.Error(w, "No proxy service available", http.StatusInternalServerError)
+			server.logRequestf(r, events.LOG_ERROR, "failed to init proxy for the %s server: %v", server.Name, upErr)
+			server.ServeError(w, r, http.StatusInternalServerError, "No proxy service available")
 			return
 		}
 	}
 
 	baseURL, err := parseServerURL(backend)
 	if err != nil {
-		server.logf(events.LOG_ERROR, "[Proxy]: invalid backend URL for %s: %v", backend.Name, err)
-		http.Error(w, "Invalid backend url", http.StatusInternalServerError)
+		server.logRequestf(r, events.LOG_ERROR, "[Proxy]: invalid backend URL for %s: %v", backend.Name, err)
+		server.ServeError(w, r, http.StatusInternalServerError, "Invalid backend url")
 		return
 	}
 
@@ -223,17 +241,38 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	target.Path = joinedPath
 	target.RawQuery = r.URL.RawQuery
 
-	// Clone request with context and body; copy headers
-	req, err := http.NewRequestWithContext(r.Context(), r.Method, target.String(), r.Body)
+	// Clone request with context and body; copy headers. The context carries the
+	// server so that proxy errors are answered with its error pages, and the PROXY
+	// protocol header to send when the backends expect one.
+	ctx := withErrorPageServer(r.Context(), server)
+	if server.ProxyProtocol > 0 {
+		ctx = withProxyProtocol(ctx, r, server.ProxyProtocol)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.Method, target.String(), r.Body)
 	if err != nil {
-		server.logf(events.LOG_ERROR, "[Fatal]: cannot create outbound request for %s server: %v", server.Name, err)
-		http.Error(w, "Failed to create backend request", http.StatusInternalServerError)
+		server.logRequestf(r, events.LOG_ERROR, "[Fatal]: cannot create outbound request for %s server: %v", server.Name, err)
+		server.ServeError(w, r, http.StatusInternalServerError, "Failed to create backend request")
 		return
 	}
+	// Keep the declared length (-1 for chunked bodies), the reverse proxy drops bodies of length 0.
+	req.ContentLength = r.ContentLength
 	req.Header = r.Header.Clone()
 	appendForwardHeaders(req.Header, r, baseURL.Scheme)
 
-	server.logf(events.LOG_INFO, "[Proxy]: Forwarding %s -> %s (backend Name: %s)", r.URL.String(), target.String(), backend.Name)
+	server.logRequestf(r, events.LOG_INFO, "[Proxy]: Forwarding %s -> %s (backend Name: %s)", r.URL.String(), target.String(), backend.Name)
+
+	// Report the picked backend and its time to first byte to mogoly:accesslog.
+	if pt := proxyTraceFromContext(r.Context()); pt != nil {
+		pt.Backend = backend.Name
+		start := time.Now()
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+			GotFirstResponseByte: func() { pt.UpstreamLatency = time.Since(start) },
+		}))
+	}
+
+	if server.ErrorPages != nil && server.ErrorPages.InterceptBackend {
+		w = &errorPageWriter{ResponseWriter: w, r: r, server: server}
+	}
 
 	// Delegate to the preconfigured reverse proxy for the backend.
 	backend.proxy.ServeHTTP(w, req)
@@ -246,3 +285,19 @@ func (s *Server) logf(level events.LogType, format string, args ...any) {
 	// Non-blocking send; drop if channel is full
 	events.Logf(level, format, args...)
 }
+
+// logRequestf logs a line about r, tagged with its request id when mogoly:requestid set one.
+func (s *Server) logRequestf(r *http.Request, level events.LogType, format string, args ...any) {
+	if s == nil {
+		return
+	}
+	proxyLogf(r, level, format, args...)
+}
+
+func proxyLogf(r *http.Request, level events.LogType, format string, args ...any) {
+	if id := RequestIDFromContext(r.Context()); id != "" {
+		format += " (request id: %s)"
+		args = append(args, id)
+	}
+	events.Logf(level, format, args...)
+}
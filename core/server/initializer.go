@@ -1,11 +1,38 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
 	"net/http/httputil"
 	"net/url"
+
+	"github.com/DoniLite/Mogoly/core/events"
 )
 
 func NewProxy(target *url.URL) *httputil.ReverseProxy {
 	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = proxyErrorHandler
+	proxy.Transport = proxyTransport{}
 	return proxy
 }
+
+// proxyErrorHandler answers 502, or 504 on timeouts, with the error pages of the
+// server. Request bodies cut by mogoly:bodylimit get a 413.
+func proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		proxyLogf(r, events.LOG_INFO, "[Proxy]: Request body of %s %s exceeds %d bytes", r.Method, r.URL.Path, maxBytesErr.Limit)
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	status := http.StatusBadGateway
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		status = http.StatusGatewayTimeout
+	}
+	proxyLogf(r, events.LOG_ERROR, "[Proxy]: Error while reaching %s: %v", r.URL.Host, err)
+	server, _ := r.Context().Value(errorPageServerKey{}).(*Server)
+	server.ServeError(w, r, status, http.StatusText(status))
+}
@@ -1,15 +1,48 @@
 package server
 
+import "errors"
+
 const (
 	MogolyRatelimiter MiddleWareName = "mogoly:ratelimiter"
+	MogolyForwardAuth MiddleWareName = "mogoly:forwardauth"
+	MogolyHeaders     MiddleWareName = "mogoly:headers"
+	MogolyStripPrefix MiddleWareName = "mogoly:stripprefix"
+	MogolyAddPrefix   MiddleWareName = "mogoly:addprefix"
+	MogolyRewrite     MiddleWareName = "mogoly:rewrite"
+	MogolyRedirect    MiddleWareName = "mogoly:redirect"
+	MogolyCompress    MiddleWareName = "mogoly:compress"
+	MogolyCache       MiddleWareName = "mogoly:cache"
+	MogolyCORS        MiddleWareName = "mogoly:cors"
+	MogolyBodyLimit   MiddleWareName = "mogoly:bodylimit"
+	MogolyRequestID   MiddleWareName = "mogoly:requestid"
+	MogolyAccessLog   MiddleWareName = "mogoly:accesslog"
+	MogolyWAF         MiddleWareName = "mogoly:waf"
 )
 
-var MiddlewaresList MiddlewareSets = MiddlewareSets{
-	MogolyRatelimiter: struct {
-		Fn   MogolyMiddleware
-		Conf any
-	}{
-		Fn:   RateLimiterMiddleware,
-		Conf: RateLimitMiddlewareConfig{},
-	},
-}
\ No newline at end of file
+// MiddlewaresList exposes the registered middlewares by name.
+//
+// Deprecated: use RegisterMiddleware to add middlewares and BuildMiddleware to
+// create them, this map is only kept in sync for the existing callers. It is
+// written under the registry lock, read it with LookupMiddlewareFunc.
+var MiddlewaresList MiddlewareSets = MiddlewareSets{}
+
+func init() {
+	if err := errors.Join(
+		RegisterMiddleware(MogolyRatelimiter, rateLimiterMiddleware),
+		RegisterMiddleware(MogolyForwardAuth, ForwardAuthMiddleware),
+		RegisterMiddleware(MogolyHeaders, HeadersMiddleware),
+		RegisterMiddleware(MogolyStripPrefix, StripPrefixMiddleware),
+		RegisterMiddleware(MogolyAddPrefix, AddPrefixMiddleware),
+		RegisterMiddleware(MogolyRewrite, RewriteMiddleware),
+		RegisterMiddleware(MogolyRedirect, RedirectMiddleware),
+		RegisterMiddleware(MogolyCompress, CompressMiddleware),
+		RegisterMiddleware(MogolyCache, CacheMiddleware),
+		RegisterMiddleware(MogolyCORS, CORSMiddleware),
+		RegisterMiddleware(MogolyBodyLimit, BodyLimitMiddleware),
+		RegisterMiddleware(MogolyRequestID, RequestIDMiddleware),
+		RegisterMiddleware(MogolyAccessLog, AccessLogMiddleware),
+		RegisterMiddleware(MogolyWAF, WAFMiddleware),
+	); err != nil {
+		panic(err)
+	}
+}
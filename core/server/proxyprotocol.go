@@ -0,0 +1,394 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/events"
+)
+
+// Versions of the PROXY protocol header sent to the backends.
+const (
+	PROXY_PROTOCOL_V1 int = 1 // Human-readable header
+	PROXY_PROTOCOL_V2 int = 2 // Binary header
+)
+
+// A v1 header is at most 107 bytes long, CRLF included.
+const proxyV1MaxLength = 107
+
+// The header reads of the connections get 10s when the listener sets no timeout.
+const defaultProxyHeaderTimeout = 10 * time.Second
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolConfig makes a listener accept the PROXY protocol v1 and v2 headers sent
+// by the load balancers in front of Mogoly, so that the client addresses they carry
+// replace those of the load balancers.
+type ProxyProtocolConfig struct {
+	TrustedCIDRs []string `json:"trusted_cidrs,omitempty" yaml:"trusted_cidrs,omitempty"` // IPs or CIDRs of the load balancers, their connections must start with a header
+}
+
+func (c *ProxyProtocolConfig) Validate() error {
+	if len(c.TrustedCIDRs) == 0 {
+		return fmt.Errorf("trusted_cidrs is required")
+	}
+	var errs []error
+	for _, entry := range c.TrustedCIDRs {
+		if !validIPOrCIDR(entry) {
+			errs = append(errs, fmt.Errorf("invalid trusted_cidrs entry %q", entry))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Listener wraps ln so that the connections of the trusted sources are read their
+// PROXY protocol header, within timeout, before anything else. Their RemoteAddr and
+// LocalAddr are those of the header, the connections of the other sources are
+// returned untouched and a header they send is never honored.
+func (c *ProxyProtocolConfig) Listener(ln net.Listener, timeout time.Duration) net.Listener {
+	if timeout <= 0 {
+		timeout = defaultProxyHeaderTimeout
+	}
+	return &proxyProtocolListener{Listener: ln, trusted: c.TrustedCIDRs, timeout: timeout}
+}
+
+type proxyProtocolListener struct {
+	net.Listener
+	trusted []string
+	timeout time.Duration
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !ipAllowed(clientIP(conn.RemoteAddr().String()), l.trusted) {
+		return conn, nil
+	}
+	// The header is read by the goroutine serving the connection, a slow load balancer
+	// does not hold the accept loop.
+	return &proxyConn{Conn: conn, br: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+// proxyConn reads the PROXY protocol header of the connection on its first use.
+type proxyConn struct {
+	net.Conn
+	br      *bufio.Reader
+	timeout time.Duration
+
+	once          sync.Once
+	remote, local net.Addr
+	err           error
+
+	mu           sync.Mutex
+	readDeadline time.Time // Set by the user of the connection, restored after the header
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.remote, c.local, c.err = readProxyHeader(c.br)
+		c.mu.Lock()
+		c.Conn.SetReadDeadline(c.readDeadline)
+		c.mu.Unlock()
+		if c.err != nil {
+			c.err = fmt.Errorf("PROXY protocol header from %s: %w", c.Conn.RemoteAddr(), c.err)
+			events.Logf(events.LOG_INFO, "[PROXY_PROTOCOL]: %v", c.err)
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	if c.readHeader(); c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.readHeader(); c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.readHeader(); c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *proxyConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// readProxyHeader reads a v1 or v2 header and returns the source and destination
+// addresses it carries, nil for the headers of the health checks of the load balancers
+// (v1 UNKNOWN, v2 LOCAL) and the address families other than IPv4 and IPv6.
+func readProxyHeader(br *bufio.Reader) (src, dst net.Addr, err error) {
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch first[0] {
+	case 'P':
+		return readProxyV1(br)
+	case proxyV2Signature[0]:
+		return readProxyV2(br)
+	}
+	return nil, nil, errors.New("missing header")
+}
+
+func readProxyV1(br *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	header, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, nil, errors.New("v1 header not terminated by CRLF")
+	}
+	fields := strings.Split(header, " ")
+	if fields[0] != "PROXY" || len(fields) < 2 {
+		return nil, nil, fmt.Errorf("invalid v1 header %q", header)
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if (fields[1] != "TCP4" && fields[1] != "TCP6") || len(fields) != 6 {
+		return nil, nil, fmt.Errorf("invalid v1 header %q", header)
+	}
+	src, err := parseProxyV1Addr(fields[2], fields[4], fields[1] == "TCP6")
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseProxyV1Addr(fields[3], fields[5], fields[1] == "TCP6")
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseProxyV1Addr(ip, port string, v6 bool) (net.Addr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Is6() != v6 {
+		return nil, fmt.Errorf("invalid v1 address %q", ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid v1 port %q", port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+func readProxyV2(br *bufio.Reader) (net.Addr, net.Addr, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(header[:12], proxyV2Signature) {
+		return nil, nil, errors.New("invalid v2 signature")
+	}
+	if header[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("unsupported version %d", header[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, nil, err
+	}
+	switch command := header[12] & 0x0f; command {
+	case 0x0: // LOCAL
+		return nil, nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, nil, fmt.Errorf("unknown v2 command %#x", command)
+	}
+
+	var size int
+	switch header[13] >> 4 {
+	case 0x1: // AF_INET
+		size = 4
+	case 0x2: // AF_INET6
+		size = 16
+	default: // AF_UNSPEC and AF_UNIX
+		return nil, nil, nil
+	}
+	if len(payload) < 2*size+4 {
+		return nil, nil, errors.New("truncated v2 addresses")
+	}
+	srcIP, _ := netip.AddrFromSlice(payload[:size])
+	dstIP, _ := netip.AddrFromSlice(payload[size : 2*size])
+	src := netip.AddrPortFrom(srcIP, binary.BigEndian.Uint16(payload[2*size:]))
+	dst := netip.AddrPortFrom(dstIP, binary.BigEndian.Uint16(payload[2*size+2:]))
+	if header[13]&0x0f == 0x2 { // SOCK_DGRAM
+		return net.UDPAddrFromAddrPort(src), net.UDPAddrFromAddrPort(dst), nil
+	}
+	return net.TCPAddrFromAddrPort(src), net.TCPAddrFromAddrPort(dst), nil
+}
+
+// WriteProxyHeader writes the PROXY protocol header of the given version announcing a
+// connection from src to dst. The addresses other than IP ones are sent as unknown.
+func WriteProxyHeader(w io.Writer, version int, src, dst net.Addr) error {
+	srcAP, srcOK := addrPortOf(src)
+	dstAP, dstOK := addrPortOf(dst)
+	known := srcOK && dstOK
+	if known && srcAP.Addr().Is4() != dstAP.Addr().Is4() {
+		srcAP = netip.AddrPortFrom(netip.AddrFrom16(srcAP.Addr().As16()), srcAP.Port())
+		dstAP = netip.AddrPortFrom(netip.AddrFrom16(dstAP.Addr().As16()), dstAP.Port())
+	}
+
+	var header []byte
+	switch version {
+	case PROXY_PROTOCOL_V1:
+		if !known {
+			header = []byte("PROXY UNKNOWN\r\n")
+			break
+		}
+		family := "TCP4"
+		if !srcAP.Addr().Is4() {
+			family = "TCP6"
+		}
+		header = fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", family, srcAP.Addr(), dstAP.Addr(), srcAP.Port(), dstAP.Port())
+	case PROXY_PROTOCOL_V2:
+		header = append(header, proxyV2Signature...)
+		header = append(header, 0x21) // Version 2, PROXY command
+		if !known {
+			header = append(header, 0x00, 0, 0) // AF_UNSPEC
+			break
+		}
+		family := byte(0x11) // AF_INET, SOCK_STREAM
+		if !srcAP.Addr().Is4() {
+			family = 0x21 // AF_INET6, SOCK_STREAM
+		}
+		srcIP, dstIP := srcAP.Addr().AsSlice(), dstAP.Addr().AsSlice()
+		header = append(header, family)
+		header = binary.BigEndian.AppendUint16(header, uint16(2*len(srcIP)+4))
+		header = append(header, srcIP...)
+		header = append(header, dstIP...)
+		header = binary.BigEndian.AppendUint16(header, srcAP.Port())
+		header = binary.BigEndian.AppendUint16(header, dstAP.Port())
+	default:
+		return fmt.Errorf("unknown PROXY protocol version %d", version)
+	}
+	_, err := w.Write(header)
+	return err
+}
+
+func addrPortOf(addr net.Addr) (netip.AddrPort, bool) {
+	if addr == nil {
+		return netip.AddrPort{}, false
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()), true
+}
+
+// proxyProtocolKey carries the PROXY protocol header to send before the request.
+type proxyProtocolKey struct{}
+
+type proxyProtocolHeader struct {
+	version  int
+	src, dst net.Addr
+}
+
+// withProxyProtocol makes the backend proxies open a connection to send r announced by
+// a PROXY protocol header of the given version.
+func withProxyProtocol(ctx context.Context, r *http.Request, version int) context.Context {
+	header := proxyProtocolHeader{version: version}
+	if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		header.src = net.TCPAddrFromAddrPort(ap)
+	}
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		header.dst = local
+	}
+	return context.WithValue(ctx, proxyProtocolKey{}, header)
+}
+
+// proxyTransport sends the requests through the default transport, or through the one
+// writing a PROXY protocol header on each connection for those asking for it. The
+// header belongs to the connection of a single client, these connections are not
+// kept alive to be reused by others.
+type proxyTransport struct{}
+
+var proxyProtocolTransport = newProxyProtocolTransport()
+
+func newProxyProtocolTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DisableKeepAlives = true
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		header, _ := ctx.Value(proxyProtocolKey{}).(proxyProtocolHeader)
+		if err := WriteProxyHeader(conn, header.version, header.src, header.dst); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	return t
+}
+
+func (proxyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if _, ok := r.Context().Value(proxyProtocolKey{}).(proxyProtocolHeader); ok {
+		return proxyProtocolTransport.RoundTrip(r)
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// ValidateProxyProtocol checks the version of the PROXY protocol header sent to the
+// backends of the server, the udp servers send none.
+func (server *Server) ValidateProxyProtocol() error {
+	switch {
+	case server.ProxyProtocol == 0:
+		return nil
+	case server.ProxyProtocol != PROXY_PROTOCOL_V1 && server.ProxyProtocol != PROXY_PROTOCOL_V2:
+		return fmt.Errorf("server %q: unknown proxy_protocol version %d, expected 1 or 2", server.Name, server.ProxyProtocol)
+	case server.UDP != nil:
+		return fmt.Errorf("server %q: proxy_protocol is not supported on udp servers", server.Name)
+	}
+	return nil
+}
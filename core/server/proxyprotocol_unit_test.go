@@ -0,0 +1,215 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyHeader_RoundTrip(t *testing.T) {
+	cases := []struct {
+		name     string
+		version  int
+		src, dst string
+		want     string // v1 header, empty for v2
+	}{
+		{"v1 ipv4", PROXY_PROTOCOL_V1, "203.0.113.7:51000", "10.0.0.1:443", "PROXY TCP4 203.0.113.7 10.0.0.1 51000 443\r\n"},
+		{"v1 ipv6", PROXY_PROTOCOL_V1, "[2001:db8::7]:51000", "[2001:db8::1]:443", "PROXY TCP6 2001:db8::7 2001:db8::1 51000 443\r\n"},
+		{"v2 ipv4", PROXY_PROTOCOL_V2, "203.0.113.7:51000", "10.0.0.1:443", ""},
+		{"v2 ipv6", PROXY_PROTOCOL_V2, "[2001:db8::7]:51000", "[2001:db8::1]:443", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			src, _ := net.ResolveTCPAddr("tcp", tc.src)
+			dst, _ := net.ResolveTCPAddr("tcp", tc.dst)
+			var buf bytes.Buffer
+			if err := WriteProxyHeader(&buf, tc.version, src, dst); err != nil {
+				t.Fatal(err)
+			}
+			if tc.want != "" && buf.String() != tc.want {
+				t.Fatalf("header %q, want %q", buf.String(), tc.want)
+			}
+			buf.WriteString("payload")
+			br := bufio.NewReader(&buf)
+			gotSrc, gotDst, err := readProxyHeader(br)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotSrc.String() != tc.src || gotDst.String() != tc.dst {
+				t.Fatalf("read %s -> %s, want %s -> %s", gotSrc, gotDst, tc.src, tc.dst)
+			}
+			if rest, _ := io.ReadAll(br); string(rest) != "payload" {
+				t.Fatalf("payload after the header %q", rest)
+			}
+		})
+	}
+
+	for _, bad := range []string{"GET / HTTP/1.1\r\n", "PROXY TCP4 1.2.3.4\r\n", "PROXY TCP4 ::1 ::1 1 2\r\n", "PROXY " + strings.Repeat("x", 120)} {
+		if _, _, err := readProxyHeader(bufio.NewReader(strings.NewReader(bad))); err == nil {
+			t.Errorf("invalid header %q accepted", bad)
+		}
+	}
+	// The health checks of the load balancers keep the real addresses.
+	for _, version := range []int{PROXY_PROTOCOL_V1, PROXY_PROTOCOL_V2} {
+		var buf bytes.Buffer
+		WriteProxyHeader(&buf, version, nil, nil)
+		if src, dst, err := readProxyHeader(bufio.NewReader(&buf)); err != nil || src != nil || dst != nil {
+			t.Errorf("v%d unknown header read as %v -> %v, %v", version, src, dst, err)
+		}
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	cases := []struct {
+		name    string
+		trusted []string
+		header  string
+		want    string // Remote address seen, the client one when empty
+		fails   bool
+	}{
+		{"trusted", []string{"127.0.0.0/8"}, "PROXY TCP4 203.0.113.7 10.0.0.1 51000 80\r\n", "203.0.113.7:51000", false},
+		{"trusted without header", []string{"127.0.0.1"}, "", "", true},
+		{"untrusted", []string{"10.0.0.0/8"}, "PROXY TCP4 203.0.113.7 10.0.0.1 51000 80\r\n", "", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			raw, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ln := (&ProxyProtocolConfig{TrustedCIDRs: tc.trusted}).Listener(raw, time.Second)
+			defer ln.Close()
+
+			client, err := net.Dial("tcp", raw.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			client.Write([]byte(tc.header + "hello"))
+
+			conn, err := ln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			want := tc.want
+			if want == "" {
+				want = client.LocalAddr().String()
+			}
+			if got := conn.RemoteAddr().String(); got != want {
+				t.Fatalf("remote address %s, want %s", got, want)
+			}
+			buf := make([]byte, 64)
+			n, err := conn.Read(buf)
+			if tc.fails {
+				if err == nil {
+					t.Fatalf("connection of a trusted source without header read %q", buf[:n])
+				}
+				return
+			}
+			if want := tc.header + "hello"; tc.want == "" && string(buf[:n]) != want {
+				t.Fatalf("untrusted header consumed, read %q", buf[:n])
+			} else if tc.want != "" && string(buf[:n]) != "hello" {
+				t.Fatalf("read %q after the header", buf[:n])
+			}
+		})
+	}
+}
+
+func TestProxyProtocol_Backends(t *testing.T) {
+	headers := make(chan string, 2)
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	backend.Listener = &headerListener{Listener: backend.Listener, headers: headers}
+	backend.Start()
+	defer backend.Close()
+	lb := &Server{Name: "app.test", URL: backend.URL, ProxyProtocol: PROXY_PROTOCOL_V1}
+	if err := lb.UpgradeProxy(); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		req := httptest.NewRequest("GET", "http://app.test/", nil)
+		req.RemoteAddr = "203.0.113.7:51000"
+		req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 80}))
+		rec := httptest.NewRecorder()
+		lb.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d", rec.Code)
+		}
+		// Each request gets a new connection announcing its client.
+		select {
+		case h := <-headers:
+			if h != "PROXY TCP4 203.0.113.7 10.0.0.1 51000 80\r\n" {
+				t.Fatalf("http backend got %q", h)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no PROXY protocol header sent to the http backend")
+		}
+	}
+
+	ln, tcpBackend := listenTCP(t)
+	tcp := &Server{Name: "db.test", Host: tcpBackend.Host, Port: tcpBackend.Port, TCP: &TCPConfig{}, ProxyProtocol: PROXY_PROTOCOL_V2}
+	client, proxied := net.Pipe()
+	defer client.Close()
+	go tcp.ServeTCP(&addrConn{
+		Conn:   proxied,
+		remote: &net.TCPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 51000},
+		local:  &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5432},
+	})
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	src, dst, err := readProxyHeader(bufio.NewReader(conn))
+	if err != nil || src == nil || src.String() != "203.0.113.7:51000" || dst.String() != "10.0.0.1:5432" {
+		t.Fatalf("tcp backend read %v -> %v, %v", src, dst, err)
+	}
+
+	if err := (&Server{Name: "dns.test", UDP: &UDPConfig{}, ProxyProtocol: 2}).ValidateProxyProtocol(); err == nil {
+		t.Fatal("proxy_protocol accepted on a udp server")
+	}
+	if err := (&Server{Name: "app.test", ProxyProtocol: 3}).ValidateProxyProtocol(); err == nil {
+		t.Fatal("unknown proxy_protocol version accepted")
+	}
+}
+
+// headerListener reports the first line of its connections, the rest is served as HTTP.
+type headerListener struct {
+	net.Listener
+	headers chan string
+}
+
+func (l *headerListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	line, _ := br.ReadString('\n')
+	l.headers <- line
+	return &bufferedConn{Conn: conn, r: br}, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// addrConn is a connection accepted from remote on local.
+type addrConn struct {
+	net.Conn
+	remote, local net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr { return c.remote }
+func (c *addrConn) LocalAddr() net.Addr  { return c.local }
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/DoniLite/Mogoly/core/events"
+)
+
+const (
+	RedirectCanonicalWWW  string = "www"
+	RedirectCanonicalApex string = "apex"
+)
+
+type RedirectRule struct {
+	Regex       string `json:"regex" yaml:"regex"`                             // Pattern matched against the full request URL (scheme://host/path?query)
+	Replacement string `json:"replacement" yaml:"replacement"`                 // Target URL supporting $1 / ${name} capture groups
+	Permanent   bool   `json:"permanent,omitempty" yaml:"permanent,omitempty"` // Use a permanent status instead of a temporary one
+}
+
+type RedirectMiddlewareConfig struct {
+	Rules              []RedirectRule `json:"rules,omitempty" yaml:"rules,omitempty"`                             // Evaluated in order, the first match wins
+	Canonical          string         `json:"canonical,omitempty" yaml:"canonical,omitempty"`                     // `www` or `apex` host canonicalisation
+	CanonicalPermanent bool           `json:"canonical_permanent,omitempty" yaml:"canonical_permanent,omitempty"` // Status family used for the canonical redirect
+}
+
+func (c RedirectMiddlewareConfig) Validate() error {
+	for _, rule := range c.Rules {
+		if _, err := regexp.Compile(rule.Regex); err != nil {
+			return fmt.Errorf("rule %q: %w", rule.Regex, err)
+		}
+	}
+	switch c.Canonical {
+	case "", RedirectCanonicalWWW, RedirectCanonicalApex:
+		return nil
+	}
+	return fmt.Errorf("unknown canonical host mode %q", c.Canonical)
+}
+
+// TLSRedirectConfig tunes the HTTP -> HTTPS redirect done by the router for servers with ForceTLS.
+type TLSRedirectConfig struct {
+	StatusCode int `json:"status_code,omitempty" yaml:"status_code,omitempty"` // One of 301, 302, 303, 307, 308 (default 301)
+	Port       int `json:"port,omitempty" yaml:"port,omitempty"`               // HTTPS port put in the Location, omitted when 0 or 443
+}
+
+func (c *TLSRedirectConfig) Validate() error {
+	var errs []error
+	switch c.StatusCode {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		errs = append(errs, fmt.Errorf("status_code must be one of 301, 302, 303, 307 or 308, got %d", c.StatusCode))
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("invalid port %d", c.Port))
+	}
+	return errors.Join(errs...)
+}
+
+type compiledRedirectRule struct {
+	re          *regexp.Regexp
+	replacement string
+	permanent   bool
+}
+
+// RedirectMiddleware answers requests matching the declared rules with a redirect
+// instead of forwarding them, and optionally canonicalises the host (www <-> apex).
+func RedirectMiddleware(conf RedirectMiddlewareConfig) func(next http.Handler) http.Handler {
+	rules := make([]compiledRedirectRule, 0, len(conf.Rules))
+	for _, rule := range conf.Rules {
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			events.Logf(events.LOG_ERROR, "[REDIRECT]: Skipping invalid redirect regex %q: %v", rule.Regex, err)
+			continue
+		}
+		rules = append(rules, compiledRedirectRule{re: re, replacement: rule.Replacement, permanent: rule.Permanent})
+	}
+	switch conf.Canonical {
+	case "", RedirectCanonicalWWW, RedirectCanonicalApex:
+	default:
+		events.Logf(events.LOG_ERROR, "[REDIRECT]: Unknown canonical host mode %q", conf.Canonical)
+		conf.Canonical = ""
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if target, ok := canonicalHostURL(r, conf.Canonical); ok {
+				events.Logf(events.LOG_INFO, "[REDIRECT]: Canonical host redirect %s -> %s", r.Host, target)
+				http.Redirect(w, r, target, redirectStatus(r.Method, conf.CanonicalPermanent))
+				return
+			}
+			if len(rules) > 0 {
+				current := requestFullURL(r)
+				for _, rule := range rules {
+					if !rule.re.MatchString(current) {
+						continue
+					}
+					target := rule.re.ReplaceAllString(current, rule.replacement)
+					if target == current {
+						break
+					}
+					events.Logf(events.LOG_INFO, "[REDIRECT]: Redirecting %s -> %s", current, target)
+					http.Redirect(w, r, target, redirectStatus(r.Method, rule.permanent))
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// TLSRedirectTarget returns the https location and status used to upgrade a plain HTTP request.
+func (server *Server) TLSRedirectTarget(r *http.Request) (string, int) {
+	status := http.StatusMovedPermanently
+	port := 0
+	if server.TLSRedirect != nil {
+		port = server.TLSRedirect.Port
+		switch server.TLSRedirect.StatusCode {
+		case 0:
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			status = server.TLSRedirect.StatusCode
+		default:
+			server.logf(events.LOG_ERROR, "[REDIRECT]: Invalid TLS redirect status %d for %s, using %d", server.TLSRedirect.StatusCode, server.Name, status)
+		}
+	}
+	host := stripHostPort(r.Host)
+	if port != 0 && port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	u := *r.URL
+	u.Scheme = "https"
+	u.Host = host
+	return u.String(), status
+}
+
+func canonicalHostURL(r *http.Request, mode string) (string, bool) {
+	if mode == "" {
+		return "", false
+	}
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host, port = r.Host, ""
+	}
+	var target string
+	switch {
+	case mode == RedirectCanonicalWWW && !strings.HasPrefix(host, "www."):
+		target = "www." + host
+	case mode == RedirectCanonicalApex && strings.HasPrefix(host, "www."):
+		target = strings.TrimPrefix(host, "www.")
+	default:
+		return "", false
+	}
+	if port != "" {
+		target = net.JoinHostPort(target, port)
+	}
+	u := *r.URL
+	u.Scheme = requestScheme(r)
+	u.Host = target
+	return u.String(), true
+}
+
+func requestFullURL(r *http.Request) string {
+	return requestScheme(r) + "://" + r.Host + r.URL.RequestURI()
+}
+
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// redirectStatus keeps the request method on non GET/HEAD redirects (307/308).
+func redirectStatus(method string, permanent bool) int {
+	safe := method == http.MethodGet || method == http.MethodHead
+	switch {
+	case permanent && safe:
+		return http.StatusMovedPermanently
+	case permanent:
+		return http.StatusPermanentRedirect
+	case safe:
+		return http.StatusFound
+	default:
+		return http.StatusTemporaryRedirect
+	}
+}
+
+// stripHostPort returns the host without its port, IPv6 addresses without their brackets.
+func stripHostPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
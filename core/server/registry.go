@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/DoniLite/Mogoly/core/events"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnknownMiddleware is returned for middleware names that were never registered.
+var ErrUnknownMiddleware = errors.New("unknown middleware")
+
+// MiddlewareConfigValidator is implemented by the configs checking their values
+// once decoded. Validate is called at config load time.
+type MiddlewareConfigValidator interface {
+	Validate() error
+}
+
+// MiddlewareConfigError reports a middleware that cannot be built from its config.
+type MiddlewareConfigError struct {
+	Server     string
+	Middleware string
+	Err        error
+}
+
+func (e *MiddlewareConfigError) Error() string {
+	if e.Server == "" {
+		return fmt.Sprintf("middleware %q: %v", e.Middleware, e.Err)
+	}
+	return fmt.Sprintf("server %q: middleware %q: %v", e.Server, e.Middleware, e.Err)
+}
+
+func (e *MiddlewareConfigError) Unwrap() error {
+	return e.Err
+}
+
+type registeredMiddleware struct {
+	decode func(raw any) (any, error)
+	build  func(conf any) func(next http.Handler) http.Handler
+}
+
+var (
+	middlewareRegistryMu sync.RWMutex
+	middlewareRegistry   = make(map[MiddleWareName]*registeredMiddleware)
+)
+
+// RegisterMiddleware makes a middleware usable by name in the server configs.
+// The raw config found in YAML/JSON is decoded into C, unknown fields being
+// rejected, and validated when C implements MiddlewareConfigValidator.
+func RegisterMiddleware[C any](name MiddleWareName, factory func(conf C) func(next http.Handler) http.Handler) error {
+	if name == "" {
+		return errors.New("middleware name cannot be empty")
+	}
+	if factory == nil {
+		return fmt.Errorf("middleware %q: nil factory", name)
+	}
+	entry := &registeredMiddleware{
+		decode: func(raw any) (any, error) {
+			return decodeTypedMiddlewareConfig[C](raw)
+		},
+		build: func(conf any) func(next http.Handler) http.Handler {
+			return factory(conf.(C))
+		},
+	}
+
+	middlewareRegistryMu.Lock()
+	defer middlewareRegistryMu.Unlock()
+	if _, exists := middlewareRegistry[name]; exists {
+		return fmt.Errorf("middleware %q is already registered", name)
+	}
+	middlewareRegistry[name] = entry
+
+	var zero C
+	MiddlewaresList[name] = struct {
+		Fn   MogolyMiddleware
+		Conf any
+	}{
+		Fn: func(config any) func(next http.Handler) http.Handler {
+			conf, err := entry.decode(config)
+			if err != nil {
+				events.Logf(events.LOG_ERROR, "[MIDDLEWARE]: Invalid config for %s: %v", name, err)
+				conf = zero
+			}
+			return entry.build(conf)
+		},
+		Conf: zero,
+	}
+	return nil
+}
+
+// RegisteredMiddlewares returns the sorted names of the registered middlewares.
+func RegisteredMiddlewares() []MiddleWareName {
+	middlewareRegistryMu.RLock()
+	defer middlewareRegistryMu.RUnlock()
+	names := make([]MiddleWareName, 0, len(middlewareRegistry))
+	for name := range middlewareRegistry {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// LookupMiddlewareFunc returns the MiddlewaresList entry of the middleware, read under
+// the registry lock.
+func LookupMiddlewareFunc(name MiddleWareName) (MogolyMiddleware, bool) {
+	middlewareRegistryMu.RLock()
+	defer middlewareRegistryMu.RUnlock()
+	entry, ok := MiddlewaresList[name]
+	return entry.Fn, ok
+}
+
+func lookupMiddleware(name string) (*registeredMiddleware, error) {
+	middlewareRegistryMu.RLock()
+	entry, ok := middlewareRegistry[MiddleWareName(name)]
+	middlewareRegistryMu.RUnlock()
+	if !ok {
+		return nil, &MiddlewareConfigError{Middleware: name, Err: fmt.Errorf("%w (registered: %v)", ErrUnknownMiddleware, RegisteredMiddlewares())}
+	}
+	return entry, nil
+}
+
+// BuildMiddleware decodes and validates the config of m and returns the middleware.
+func BuildMiddleware(m Middleware) (func(next http.Handler) http.Handler, error) {
+	entry, err := lookupMiddleware(m.Name)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := entry.decode(m.Config)
+	if err != nil {
+		return nil, &MiddlewareConfigError{Middleware: m.Name, Err: err}
+	}
+	return entry.build(conf), nil
+}
+
+// ValidateMiddleware checks that m names a registered middleware with a valid config.
+func ValidateMiddleware(m Middleware) error {
+	entry, err := lookupMiddleware(m.Name)
+	if err != nil {
+		return err
+	}
+	if _, err := entry.decode(m.Config); err != nil {
+		return &MiddlewareConfigError{Middleware: m.Name, Err: err}
+	}
+	return nil
+}
+
+// ValidateMiddlewares checks the middlewares of the server, of its routes and of its
+// balancing servers.
+func (server *Server) ValidateMiddlewares() error {
+	var errs []error
+	for _, m := range server.Middlewares {
+		if err := ValidateMiddleware(m); err != nil {
+			var mErr *MiddlewareConfigError
+			if errors.As(err, &mErr) {
+				mErr.Server = server.Name
+			}
+			errs = append(errs, err)
+		}
+	}
+	for i, route := range server.Routes {
+		if route == nil {
+			continue
+		}
+		if err := route.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("server %q: route %s: %w", server.Name, route.RouteLabel(i), err))
+		}
+	}
+	for _, bs := range server.BalancingServers {
+		if bs == nil {
+			continue
+		}
+		if err := bs.ValidateMiddlewares(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// decodeTypedMiddlewareConfig turns the raw config (nil, C, *C or the maps produced
+// by the YAML/JSON decoders) into C.
+func decodeTypedMiddlewareConfig[C any](raw any) (C, error) {
+	var conf C
+	switch v := raw.(type) {
+	case nil:
+	case C:
+		conf = v
+	case *C:
+		if v != nil {
+			conf = *v
+		}
+	default:
+		if err := decodeKnownFields(raw, &conf); err != nil {
+			return conf, err
+		}
+	}
+	if v, ok := any(&conf).(MiddlewareConfigValidator); ok {
+		if err := v.Validate(); err != nil {
+			return conf, err
+		}
+	} else if v, ok := any(conf).(MiddlewareConfigValidator); ok {
+		if err := v.Validate(); err != nil {
+			return conf, err
+		}
+	}
+	return conf, nil
+}
+
+// decodeKnownFields decodes the YAML encoding of raw into out, rejecting the fields
+// out does not have.
+func decodeKnownFields(raw, out any) error {
+	encoded, err := yaml.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(encoded))
+	dec.KnownFields(true)
+	if err := dec.Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const (
+	RequestIDDefaultHeader string = "X-Request-ID"
+
+	requestIDMaxLength = 128
+)
+
+type requestIDContextKey struct{}
+
+type RequestIDMiddlewareConfig struct {
+	Header string `json:"header,omitempty" yaml:"header,omitempty"` // Header carrying the ID, X-Request-ID by default
+}
+
+// WithRequestID returns a copy of ctx carrying the request id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// RequestIDFromContext returns the id set by mogoly:requestid, empty when there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// RequestIDMiddleware keeps the incoming request id or generates one, then forwards
+// it to the backend, echoes it in the response and stores it in the request context.
+func RequestIDMiddleware(conf RequestIDMiddlewareConfig) func(next http.Handler) http.Handler {
+	header := http.CanonicalHeaderKey(conf.Header)
+	if header == "" {
+		header = RequestIDDefaultHeader
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(header)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			r.Header.Set(header, id)
+			r = r.WithContext(WithRequestID(r.Context(), id))
+
+			// Set once the backend answered so that an echoed id is not duplicated.
+			hw := newHeaderHookWriter(w, func(h http.Header, status int) {
+				h.Set(header, id)
+			})
+			next.ServeHTTP(hw, r)
+			hw.finish()
+		})
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID only trusts short printable ids to keep the logs and headers clean.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > requestIDMaxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
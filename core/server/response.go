@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// headerHookWriter runs a hook right before the final status line is written so
+// that middlewares can adjust the headers coming from the backend.
+type headerHookWriter struct {
+	http.ResponseWriter
+	hook        func(h http.Header, status int)
+	wroteHeader bool
+	hijacked    bool
+}
+
+func newHeaderHookWriter(w http.ResponseWriter, hook func(h http.Header, status int)) *headerHookWriter {
+	return &headerHookWriter{ResponseWriter: w, hook: hook}
+}
+
+func (w *headerHookWriter) WriteHeader(code int) {
+	// Informational answers (100 Continue, 103 Early Hints) can be sent several
+	// times before the final status and must not consume the hook.
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.hook(w.Header(), code)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerHookWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headerHookWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish runs the hook for the handlers that returned without writing anything.
+// A hijacked connection is left alone since it no longer carries an HTTP answer.
+func (w *headerHookWriter) finish() {
+	if !w.wroteHeader && !w.hijacked {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (w *headerHookWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		conn, rw, err := h.Hijack()
+		w.hijacked = err == nil
+		return conn, rw, err
+	}
+	return nil, nil, errors.New("hijack not supported by the underlying response writer")
+}
+
+// Unwrap lets http.ResponseController reach the original writer.
+func (w *headerHookWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/DoniLite/Mogoly/core/events"
+)
+
+const forwardedPrefixHeader = "X-Forwarded-Prefix"
+
+type StripPrefixMiddlewareConfig struct {
+	Prefixes       []string `json:"prefixes" yaml:"prefixes"`                                   // Prefixes removed from the path, the longest match wins
+	TrustedProxies []string `json:"trusted_proxies,omitempty" yaml:"trusted_proxies,omitempty"` // IPs or CIDRs whose X-Forwarded-Prefix is kept, the others are overwritten
+}
+
+func (c StripPrefixMiddlewareConfig) Validate() error {
+	return validateTrustedProxies(c.TrustedProxies)
+}
+
+type AddPrefixMiddlewareConfig struct {
+	Prefix         string   `json:"prefix" yaml:"prefix"`                                       // Prefix prepended to the path
+	TrustedProxies []string `json:"trusted_proxies,omitempty" yaml:"trusted_proxies,omitempty"` // IPs or CIDRs whose X-Forwarded-Prefix is kept, the others are removed
+}
+
+func (c AddPrefixMiddlewareConfig) Validate() error {
+	return validateTrustedProxies(c.TrustedProxies)
+}
+
+type RewriteMiddlewareConfig struct {
+	Regex          string   `json:"regex" yaml:"regex"`                                         // Pattern matched against the request path
+	Replacement    string   `json:"replacement" yaml:"replacement"`                             // Replacement supporting $1 / ${name} capture groups
+	TrustedProxies []string `json:"trusted_proxies,omitempty" yaml:"trusted_proxies,omitempty"` // IPs or CIDRs whose X-Forwarded-Prefix is kept, the others are overwritten
+}
+
+func (c RewriteMiddlewareConfig) Validate() error {
+	if c.Regex == "" {
+		return errors.New("regex is required")
+	}
+	if _, err := regexp.Compile(c.Regex); err != nil {
+		return err
+	}
+	return validateTrustedProxies(c.TrustedProxies)
+}
+
+// StripPrefixMiddleware removes a path prefix before the request reaches the backend
+// and records it in X-Forwarded-Prefix so the application can build its own links.
+func StripPrefixMiddleware(conf StripPrefixMiddlewareConfig) func(next http.Handler) http.Handler {
+	prefixes := make([]string, 0, len(conf.Prefixes))
+	for _, p := range conf.Prefixes {
+		if p = normalizePrefix(p); p != "" {
+			prefixes = append(prefixes, p)
+		}
+	}
+	// Longest prefixes first so that /api/v1 wins over /api
+	slices.SortFunc(prefixes, func(a, b string) int { return len(b) - len(a) })
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, prefix := range prefixes {
+				if !hasPathPrefix(r.URL.Path, prefix) {
+					continue
+				}
+				setRequestPath(r, ensureLeadingSlash(strings.TrimPrefix(r.URL.Path, prefix)), func(raw string) string {
+					return ensureLeadingSlash(strings.TrimPrefix(raw, prefix))
+				})
+				r = forwardPrefix(r, prefix, conf.TrustedProxies)
+				break
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AddPrefixMiddleware prepends a path prefix before the request reaches the backend.
+func AddPrefixMiddleware(conf AddPrefixMiddlewareConfig) func(next http.Handler) http.Handler {
+	prefix := normalizePrefix(conf.Prefix)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if prefix != "" {
+				setRequestPath(r, singleSlashJoin(prefix, r.URL.Path), func(raw string) string {
+					return singleSlashJoin(prefix, raw)
+				})
+				// The path seen by the client keeps its prefix, only a trusted one is forwarded
+				r = forwardPrefix(r, "", conf.TrustedProxies)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RewriteMiddleware rewrites the request path with a regular expression. When the
+// rewrite only drops a leading part of the path, that part is reported in X-Forwarded-Prefix.
+func RewriteMiddleware(conf RewriteMiddlewareConfig) func(next http.Handler) http.Handler {
+	re, err := regexp.Compile(conf.Regex)
+	if err != nil || conf.Regex == "" {
+		events.Logf(events.LOG_ERROR, "[REWRITE]: Invalid rewrite regex %q: %v", conf.Regex, err)
+		re = nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if re == nil || !re.MatchString(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			original := r.URL.Path
+			rewritten := ensureLeadingSlash(re.ReplaceAllString(original, conf.Replacement))
+			// The escaped form cannot be rewritten reliably, let net/url re-encode it.
+			setRequestPath(r, rewritten, nil)
+			if rewritten != original && strings.HasSuffix(original, rewritten) {
+				r = forwardPrefix(r, strings.TrimSuffix(original, rewritten), conf.TrustedProxies)
+			}
+			events.Logf(events.LOG_DEBUG, "[REWRITE]: Path rewritten %s -> %s", original, rewritten)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedPrefixKey marks the requests whose X-Forwarded-Prefix was set by a middleware.
+type forwardedPrefixKey struct{}
+
+// forwardPrefix appends prefix to the X-Forwarded-Prefix of r. The value sent by the
+// client is only kept when it comes from a trusted proxy, the header is removed when
+// nothing is left. The prefixes added by the previous middlewares of r are kept.
+func forwardPrefix(r *http.Request, prefix string, trustedProxies []string) *http.Request {
+	prior := ""
+	if r.Context().Value(forwardedPrefixKey{}) != nil || ipAllowed(clientIP(r.RemoteAddr), trustedProxies) {
+		prior = strings.TrimSuffix(r.Header.Get(forwardedPrefixHeader), "/")
+	}
+	if prior+prefix == "" {
+		r.Header.Del(forwardedPrefixHeader)
+	} else {
+		r.Header.Set(forwardedPrefixHeader, prior+prefix)
+	}
+	if r.Context().Value(forwardedPrefixKey{}) != nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), forwardedPrefixKey{}, true))
+}
+
+func validateTrustedProxies(entries []string) error {
+	var errs []error
+	for _, entry := range entries {
+		if !validIPOrCIDR(entry) {
+			errs = append(errs, fmt.Errorf("invalid trusted_proxies entry %q", entry))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setRequestPath updates both the decoded and escaped paths of the request URL.
+func setRequestPath(r *http.Request, path string, rewriteRaw func(raw string) string) {
+	r.URL.Path = path
+	if r.URL.RawPath == "" {
+		return
+	}
+	if rewriteRaw == nil {
+		r.URL.RawPath = ""
+		return
+	}
+	r.URL.RawPath = rewriteRaw(r.URL.RawPath)
+}
+
+func normalizePrefix(prefix string) string {
+	prefix = strings.TrimSuffix(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return ""
+	}
+	return ensureLeadingSlash(prefix)
+}
+
+// hasPathPrefix reports whether prefix matches whole path segments of path.
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || path[len(prefix)] == '/'
+}
+
+func ensureLeadingSlash(path string) string {
+	if strings.HasPrefix(path, "/") {
+		return path
+	}
+	return "/" + path
+}
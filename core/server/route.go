@@ -0,0 +1,59 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Route sends the requests of a host matching all its conditions to another server.
+// Routes are tried by decreasing Priority, in config order for equal priorities, and
+// the requests matching no route reach the backends of the host server itself.
+type Route struct {
+	Name        string            `json:"name,omitempty" yaml:"name,omitempty"`
+	Priority    int               `json:"priority,omitempty" yaml:"priority,omitempty"`       // Higher priorities are tried first
+	PathPrefix  string            `json:"path_prefix,omitempty" yaml:"path_prefix,omitempty"` // Matches the path itself and its sub paths, `/api` does not match `/apiary`
+	PathRegex   string            `json:"path_regex,omitempty" yaml:"path_regex,omitempty"`   // Go regexp matched against the path
+	Methods     []string          `json:"methods,omitempty" yaml:"methods,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"` // Header name -> regexp on its values, empty only requires the header
+	Query       map[string]string `json:"query,omitempty" yaml:"query,omitempty"`     // Query param -> regexp on its values, empty only requires the param
+	Server      string            `json:"server,omitempty" yaml:"server,omitempty"`   // Name of the server receiving the requests, the host server when empty
+	Middlewares []Middleware      `json:"middlewares,omitempty" yaml:"middlewares,omitempty"`
+}
+
+func (r *Route) Validate() error {
+	var errs []error
+	if r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+		errs = append(errs, fmt.Errorf("path_prefix %q must start with /", r.PathPrefix))
+	}
+	if r.PathRegex != "" {
+		if _, err := regexp.Compile(r.PathRegex); err != nil {
+			errs = append(errs, fmt.Errorf("path_regex: %w", err))
+		}
+	}
+	for name, pattern := range r.Headers {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("header %s: %w", name, err))
+		}
+	}
+	for name, pattern := range r.Query {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("query %s: %w", name, err))
+		}
+	}
+	for _, m := range r.Middlewares {
+		if err := ValidateMiddleware(m); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RouteLabel names the route in logs and errors.
+func (r *Route) RouteLabel(idx int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("#%d", idx)
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/DoniLite/Mogoly/core/events"
+	"gopkg.in/yaml.v3"
 )
 
 var (
@@ -67,40 +68,56 @@ type RateLimitMiddlewareConfig struct {
 	LimitWindow  time.Duration `json:"limit_window,omitempty" yaml:"limit_window,omitempty"`
 }
 
-func RateLimiterMiddleware(config any) func(next http.Handler) http.Handler {
-	conf := RateLimitMiddlewareConfig{ReqPerMinute: requestsPerMinute, LimitWindow: rateLimitWindow}
-	switch v := config.(type) {
-	case *RateLimitMiddlewareConfig:
-		if v != nil {
-			conf = *v
-		}
-	case RateLimitMiddlewareConfig:
-		conf = v
-	case map[string]any:
-		if rpm, ok := v["request_per_minute"]; ok {
-			switch x := rpm.(type) {
-			case float64:
-				conf.ReqPerMinute = int(x)
-			case int:
-				conf.ReqPerMinute = x
-			}
-		}
-		if lw, ok := v["limit_window"]; ok {
-			switch x := lw.(type) {
-			case string:
-				if d, err := time.ParseDuration(x); err == nil {
-					conf.LimitWindow = d
-				}
-			case float64:
-				conf.LimitWindow = time.Duration(int64(x)) * time.Second
-			}
+// Duration is a time.Duration written as a Go duration string or a number of seconds.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	if value.Tag == "!!int" || value.Tag == "!!float" {
+		var seconds float64
+		if err := value.Decode(&seconds); err != nil {
+			return err
 		}
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+	return value.Decode((*time.Duration)(d))
+}
+
+// UnmarshalYAML keeps accepting limit_window as a number of seconds, as the JSON configs do.
+func (c *RateLimitMiddlewareConfig) UnmarshalYAML(value *yaml.Node) error {
+	var raw rateLimitMiddlewareYAML
+	if err := decodeKnownFields(value, &raw); err != nil {
+		return err
 	}
+	c.ReqPerMinute = raw.ReqPerMinute
+	c.LimitWindow = time.Duration(raw.LimitWindow)
+	return nil
+}
+
+// rateLimitMiddlewareYAML is RateLimitMiddlewareConfig as written in the configs.
+type rateLimitMiddlewareYAML struct {
+	ReqPerMinute int      `yaml:"request_per_minute,omitempty"`
+	LimitWindow  Duration `yaml:"limit_window,omitempty"`
+}
+
+// RateLimiterMiddleware limits the requests of each client address. config is a
+// RateLimitMiddlewareConfig, a pointer to one or its decoded YAML/JSON map, the
+// defaults are used when it is invalid.
+func RateLimiterMiddleware(config any) func(next http.Handler) http.Handler {
+	conf, err := decodeTypedMiddlewareConfig[RateLimitMiddlewareConfig](config)
+	if err != nil {
+		events.Logf(events.LOG_ERROR, "[MIDDLEWARE]: Invalid config for %s: %v", MogolyRatelimiter, err)
+		conf = RateLimitMiddlewareConfig{}
+	}
+	return rateLimiterMiddleware(conf)
+}
+
+func rateLimiterMiddleware(conf RateLimitMiddlewareConfig) func(next http.Handler) http.Handler {
 	if conf.ReqPerMinute <= 0 {
-		conf.ReqPerMinute = 5
+		conf.ReqPerMinute = requestsPerMinute
 	}
 	if conf.LimitWindow <= 0 {
-		conf.LimitWindow = time.Minute
+		conf.LimitWindow = rateLimitWindow
 	}
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
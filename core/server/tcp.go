@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/config"
+	"github.com/DoniLite/Mogoly/core/events"
+)
+
+const (
+	TCP_TLS_PASSTHROUGH string = "passthrough" // The TLS stream reaches the backends untouched
+	TCP_TLS_TERMINATE   string = "terminate"   // Mogoly terminates TLS, the backends get the plain stream
+
+	DefaultTCPConnectTimeout = 10 * time.Second
+	// Database clients keep pooled connections open, a shorter default would cut them.
+	DefaultTCPIdleTimeout = time.Hour
+	// Shorter timeouts would cut every connection, 0 keeps the defaults.
+	MinTCPTimeout = 10 * time.Millisecond
+)
+
+// TCPConfig turns a server into a route of the tcp entrypoints: the connections whose
+// TLS SNI matches its name or hosts are forwarded to its backends, the balancing
+// servers or the server itself.
+type TCPConfig struct {
+	TLS            string        `json:"tls,omitempty" yaml:"tls,omitempty"`                         // passthrough (default) or terminate
+	ConnectTimeout time.Duration `json:"connect_timeout,omitempty" yaml:"connect_timeout,omitempty"` // Dial timeout of the backends, 10s by default
+	IdleTimeout    time.Duration `json:"idle_timeout,omitempty" yaml:"idle_timeout,omitempty"`       // Closes connections without traffic in either direction, 1h by default
+	AllowedCIDRs   []string      `json:"allowed_cidrs,omitempty" yaml:"allowed_cidrs,omitempty"`     // IPs or CIDRs allowed to connect, everyone when empty
+}
+
+func (c *TCPConfig) Validate() error {
+	var errs []error
+	switch strings.ToLower(c.TLS) {
+	case "", TCP_TLS_PASSTHROUGH, TCP_TLS_TERMINATE:
+	default:
+		errs = append(errs, fmt.Errorf("unknown tls mode %q, expected passthrough or terminate", c.TLS))
+	}
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{{"connect_timeout", c.ConnectTimeout}, {"idle_timeout", c.IdleTimeout}} {
+		if timeout.value < 0 || timeout.value > 0 && timeout.value < MinTCPTimeout {
+			errs = append(errs, fmt.Errorf("%s must be 0 or at least %s, got %s", timeout.name, MinTCPTimeout, timeout.value))
+		}
+	}
+	for _, entry := range c.AllowedCIDRs {
+		if !validIPOrCIDR(entry) {
+			errs = append(errs, fmt.Errorf("invalid allowed_cidrs entry %q", entry))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// TerminatesTLS reports whether the TLS connections are terminated before reaching the backends.
+func (c *TCPConfig) TerminatesTLS() bool {
+	return c != nil && strings.EqualFold(c.TLS, TCP_TLS_TERMINATE)
+}
+
+func (c *TCPConfig) connectTimeout() time.Duration {
+	if c == nil || c.ConnectTimeout <= 0 {
+		return DefaultTCPConnectTimeout
+	}
+	return c.ConnectTimeout
+}
+
+func (c *TCPConfig) idleTimeout() time.Duration {
+	if c == nil || c.IdleTimeout <= 0 {
+		return DefaultTCPIdleTimeout
+	}
+	return c.IdleTimeout
+}
+
+// ServeTCP forwards conn to the next backend of the server and copies the streams both
+// ways until one side closes or the connection stays idle for the configured timeout.
+// conn is closed on return.
+func (server *Server) ServeTCP(conn net.Conn) {
+	defer conn.Close()
+	conf := server.TCP
+	remote := conn.RemoteAddr().String()
+	if conf != nil && len(conf.AllowedCIDRs) > 0 && !ipAllowed(clientIP(remote), conf.AllowedCIDRs) {
+		server.logf(events.LOG_INFO, "[TCP_PROXY]: Connection from %s to the %s server refused by its allow-list", remote, server.Name)
+		return
+	}
+
+	backend, err := server.nextBackend()
+	if err != nil {
+		server.logf(events.LOG_ERROR, "[Load Balancer] error for the %s server: %v", server.Name, err)
+		return
+	}
+	addr, err := tcpAddress(backend)
+	if err != nil {
+		server.logf(events.LOG_ERROR, "[TCP_PROXY]: invalid backend address for %s: %v", backend.Name, err)
+		return
+	}
+	upstream, err := net.DialTimeout("tcp", addr, conf.connectTimeout())
+	if err != nil {
+		server.logf(events.LOG_ERROR, "[TCP_PROXY]: Error while reaching %s for the %s server: %v", addr, server.Name, err)
+		return
+	}
+	defer upstream.Close()
+	if server.ProxyProtocol > 0 {
+		if err := WriteProxyHeader(upstream, server.ProxyProtocol, conn.RemoteAddr(), conn.LocalAddr()); err != nil {
+			server.logf(events.LOG_ERROR, "[TCP_PROXY]: Error while sending the PROXY protocol header to %s: %v", addr, err)
+			return
+		}
+	}
+
+	server.logf(events.LOG_INFO, "[TCP_PROXY]: Forwarding %s -> %s (backend Name: %s)", remote, addr, backend.Name)
+	start := time.Now()
+	sent, received := pipeTCP(conn, upstream, conf.idleTimeout())
+	server.logf(events.LOG_DEBUG, "[TCP_PROXY]: Connection %s -> %s closed after %s, %d bytes sent and %d received", remote, addr, time.Since(start).Round(time.Millisecond), sent, received)
+}
+
+// nextBackend returns the balancing server picked by the configured strategy, or the
+// server itself when it balances nothing.
+func (server *Server) nextBackend() (*Server, error) {
+	if len(server.BalancingServers) == 0 {
+		return server, nil
+	}
+	strategy := config.GetEnv(config.BALANCER_STRATEGY, string(RoundRobin))
+	return server.GetNextServer(ServerStrategy(strategy))
+}
+
+// pipeTCP copies a to b and b to a until both directions are done. A direction ending
+// half-closes the other side when possible so that protocols relying on it work, the
+// whole connection is cut once no byte crossed it for idle.
+func pipeTCP(a, b net.Conn, idle time.Duration) (aToB, bToA int64) {
+	var lastActive activity
+	lastActive.touch()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(max(min(idle/2, time.Second), time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if lastActive.since() >= idle {
+					a.Close()
+					b.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		aToB = copyActive(b, a, &lastActive)
+	}()
+	go func() {
+		defer wg.Done()
+		bToA = copyActive(a, b, &lastActive)
+	}()
+	wg.Wait()
+	return aToB, bToA
+}
+
+// copyActive copies src to dst, recording the activity, then closes the write side of dst.
+func copyActive(dst, src net.Conn, lastActive *activity) int64 {
+	n, _ := io.Copy(&activeWriter{w: dst, lastActive: lastActive}, src)
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		dst.Close()
+	}
+	return n
+}
+
+type activeWriter struct {
+	w          io.Writer
+	lastActive *activity
+}
+
+func (w *activeWriter) Write(p []byte) (int, error) {
+	w.lastActive.touch()
+	return w.w.Write(p)
+}
+
+// activity records the last time a byte crossed a connection.
+type activity struct {
+	last atomic.Int64
+}
+
+func (a *activity) touch() {
+	a.last.Store(time.Now().UnixNano())
+}
+
+func (a *activity) since() time.Duration {
+	return time.Since(time.Unix(0, a.last.Load()))
+}
+
+func validIPOrCIDR(entry string) bool {
+	entry = strings.TrimSpace(entry)
+	if _, err := netip.ParsePrefix(entry); err == nil {
+		return true
+	}
+	_, err := netip.ParseAddr(entry)
+	return err == nil
+}
+
+// tcpAddress returns the host:port of a TCP or UDP backend, from its host and port or its URL.
+func tcpAddress(s *Server) (string, error) {
+	if s.Host != "" && s.Port != 0 {
+		return net.JoinHostPort(s.Host, strconv.Itoa(s.Port)), nil
+	}
+	if s.URL != "" {
+		if u, err := url.Parse(s.URL); err == nil && u.Port() != "" {
+			return u.Host, nil
+		}
+	}
+	return "", fmt.Errorf("incomplete server fields for a backend address (need host and port, or url)")
+}
+
+// TCPHealthChecker reports whether the server accepts TCP connections.
+func TCPHealthChecker(server *Server) (bool, error) {
+	events.Logf(events.LOG_INFO, "[HEALTH_CHECKER]: Initializing tcp health checking for the %s server", server.Name)
+	addr, err := tcpAddress(server)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return false, err
+	}
+	conn.Close()
+	return true, nil
+}
+
+// healthChecker returns the check of the backends of the server, a UDP probe or a TCP
+// dial for the servers routed by the udp and tcp entrypoints, an HTTP request otherwise.
+func (server *Server) healthChecker() func(*Server) (bool, error) {
+	switch {
+	case server.UDP != nil || strings.EqualFold(server.Protocol, "udp"):
+		return UDPHealthChecker
+	case server.TCP != nil || strings.EqualFold(server.Protocol, "tcp"):
+		return TCPHealthChecker
+	}
+	return HealthChecker
+}
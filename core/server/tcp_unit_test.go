@@ -0,0 +1,125 @@
+package server
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func listenTCP(t *testing.T) (net.Listener, *Server) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	port := ln.Addr().(*net.TCPAddr).Port
+	return ln, &Server{Name: "backend", Host: "127.0.0.1", Port: port}
+}
+
+func TestServeTCP_IdleTimeout(t *testing.T) {
+	ln, backend := listenTCP(t)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	s := &Server{Name: "db.test", Host: backend.Host, Port: backend.Port, TCP: &TCPConfig{IdleTimeout: 100 * time.Millisecond}}
+
+	client, proxied := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.ServeTCP(proxied)
+		close(done)
+	}()
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo %q, %v", buf, err)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle connection not closed")
+	}
+}
+
+func TestPipeTCP_TinyIdleTimeout(t *testing.T) {
+	a, peer := net.Pipe()
+	b, _ := net.Pipe()
+	defer peer.Close()
+	done := make(chan struct{})
+	go func() {
+		pipeTCP(a, b, time.Nanosecond) // Used to panic on a zero ticker interval
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle connection not closed")
+	}
+}
+
+func TestServeTCP_AllowList(t *testing.T) {
+	_, backend := listenTCP(t)
+	s := &Server{Name: "db.test", Host: backend.Host, Port: backend.Port, TCP: &TCPConfig{AllowedCIDRs: []string{"10.0.0.0/8"}}}
+
+	front, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer front.Close()
+	go func() {
+		conn, err := front.Accept()
+		if err == nil {
+			s.ServeTCP(conn)
+		}
+	}()
+	conn, err := net.Dial("tcp", front.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("connection from a client outside the allow-list not closed: %v", err)
+	}
+}
+
+func TestTCPHealthChecker(t *testing.T) {
+	ln, backend := listenTCP(t)
+	if ok, err := TCPHealthChecker(backend); !ok || err != nil {
+		t.Fatalf("listening backend unhealthy: %v", err)
+	}
+
+	// The backend speaks no HTTP, its tcp server dials it instead.
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	lb := &Server{Name: "db.test", TCP: &TCPConfig{}, BalancingServers: []*Server{
+		{Name: "db-1", URL: "tcp://127.0.0.1:" + strconv.Itoa(backend.Port)},
+	}}
+	hc, err := lb.CheckHealthAll()
+	if err != nil || len(hc.Pass) != 1 || !lb.BalancingServers[0].IsHealthy {
+		t.Fatalf("tcp backend not dialed: %+v %v", hc, err)
+	}
+
+	ln.Close()
+	if ok, _ := TCPHealthChecker(backend); ok {
+		t.Fatal("closed backend healthy")
+	}
+}
@@ -10,18 +10,27 @@ import (
 type Server struct {
 	ID               string       // THe server ID based on its registration order
 	Name             string       `json:"name,omitempty" yaml:"name,omitempty"`             // The server name
-	Protocol         string       `json:"protocol,omitempty" yaml:"protocol,omitempty"`     // The protocol for the server this field can be `http` or `https`
+	Hosts            []string     `json:"hosts,omitempty" yaml:"hosts,omitempty"`           // Extra hosts answered besides Name, `*.app.test` matches any subdomain of app.test
+	Protocol         string       `json:"protocol,omitempty" yaml:"protocol,omitempty"`     // The protocol for the server this field can be `http`, `https`, `tcp` or `udp`
 	Host             string       `json:"host,omitempty" yaml:"host,omitempty"`             // The server host
 	Port             int          `json:"port,omitempty" yaml:"port,omitempty"`             // The port on which the server is running
 	URL              string       `json:"url,omitempty" yaml:"url,omitempty"`               // If this field is provided the URL will be used for request forwarding
 	IsHealthy        bool         `json:"is_healthy,omitempty" yaml:"is_healthy,omitempty"` // Specifying the server health check state
 	BalancingServers []*Server    `json:"balance,omitempty" yaml:"balance,omitempty"`       // If specified these servers will be used for load balancing request
 	Middlewares      []Middleware `json:"middlewares,omitempty" yaml:"middlewares,omitempty"`
+	Routes           []*Route     `json:"routes,omitempty" yaml:"routes,omitempty"`           // Path/header rules sending part of the requests to other servers
+	EntryPoints      []string     `json:"entrypoints,omitempty" yaml:"entrypoints,omitempty"` // Entrypoints serving the server, all those of its kind (http, tcp or udp) when empty
 	LastHealthCheck  *time.Time
 	proxy            *httputil.ReverseProxy
 	mu               sync.Mutex
 	idx              int
 	ForceTLS         bool
+	TLSRedirect      *TLSRedirectConfig `json:"tls_redirect,omitempty" yaml:"tls_redirect,omitempty"`     // Tunes the HTTP -> HTTPS redirect applied when ForceTLS is set
+	ErrorPages       *ErrorPagesConfig  `json:"error_pages,omitempty" yaml:"error_pages,omitempty"`       // Pages sent instead of the plain text errors
+	Maintenance      *MaintenanceConfig `json:"maintenance,omitempty" yaml:"maintenance,omitempty"`       // Answers 503 to everyone but the allow-list when enabled
+	TCP              *TCPConfig         `json:"tcp,omitempty" yaml:"tcp,omitempty"`                       // Routes the server on the tcp entrypoints instead of the http ones
+	UDP              *UDPConfig         `json:"udp,omitempty" yaml:"udp,omitempty"`                       // Makes the server the target of the udp entrypoints
+	ProxyProtocol    int                `json:"proxy_protocol,omitempty" yaml:"proxy_protocol,omitempty"` // Version (1 or 2) of the PROXY protocol header sent to the backends, none when 0
 }
 
 type Middleware struct {
@@ -62,4 +71,3 @@ type MiddlewareSets map[MiddleWareName]struct {
 	Fn   MogolyMiddleware
 	Conf any
 }
-
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/events"
+)
+
+// Sessions of DNS clients end with their answer, syslog ones send continuously.
+const DefaultUDPSessionTimeout = 30 * time.Second
+
+// Each session holds a socket, the cap keeps spoofed source addresses from exhausting
+// the file descriptors.
+const DefaultUDPMaxSessions = 4096
+
+// UDPConfig turns a server into the target of the udp entrypoints it is attached to:
+// their datagrams are forwarded to its backends, the balancing servers or the server
+// itself. Each client address is bound to one backend for the session so that the
+// replies go back to it.
+type UDPConfig struct {
+	SessionTimeout time.Duration `json:"session_timeout,omitempty" yaml:"session_timeout,omitempty"` // Ends the sessions without datagrams in either direction, 30s by default
+	MaxSessions    int           `json:"max_sessions,omitempty" yaml:"max_sessions,omitempty"`       // Datagrams of new clients are dropped once reached, 4096 by default
+}
+
+func (c *UDPConfig) Validate() error {
+	var errs []error
+	if c.SessionTimeout < 0 {
+		errs = append(errs, fmt.Errorf("session_timeout must not be negative"))
+	}
+	if c.MaxSessions < 0 {
+		errs = append(errs, fmt.Errorf("max_sessions must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// SessionIdleTimeout returns the session timeout, the default one when unset.
+func (c *UDPConfig) SessionIdleTimeout() time.Duration {
+	if c == nil || c.SessionTimeout <= 0 {
+		return DefaultUDPSessionTimeout
+	}
+	return c.SessionTimeout
+}
+
+// SessionLimit returns the maximum number of sessions, the default one when unset.
+func (c *UDPConfig) SessionLimit() int {
+	if c == nil || c.MaxSessions <= 0 {
+		return DefaultUDPMaxSessions
+	}
+	return c.MaxSessions
+}
+
+// DialUDP picks the backend of a new session and returns a socket connected to it.
+func (server *Server) DialUDP() (*net.UDPConn, *Server, error) {
+	backend, err := server.nextBackend()
+	if err != nil {
+		return nil, nil, err
+	}
+	addr, err := tcpAddress(backend)
+	if err != nil {
+		return nil, backend, err
+	}
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, backend, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, backend, err
+	}
+	return conn, backend, nil
+}
+
+// UDPHealthChecker probes the server with an empty datagram. UDP backends do not have
+// to answer: the server is unhealthy only when the host reports the port unreachable.
+func UDPHealthChecker(server *Server) (bool, error) {
+	events.Logf(events.LOG_INFO, "[HEALTH_CHECKER]: Initializing udp health checking for the %s server", server.Name)
+	addr, err := tcpAddress(server)
+	if err != nil {
+		return false, err
+	}
+	conn, err := net.DialTimeout("udp", addr, 3*time.Second)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write(nil); err != nil {
+		return false, err
+	}
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 1))
+	var netErr net.Error
+	if err == nil || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return true, nil
+	}
+	return false, err
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"net"
+	"testing"
+)
+
+func TestUDPHealthChecker(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	lb := &Server{Name: "dns.local", UDP: &UDPConfig{}, BalancingServers: []*Server{
+		{Name: "ns1", Host: "127.0.0.1", Port: port},
+	}}
+	hc, err := lb.CheckHealthAll()
+	if err != nil || len(hc.Pass) != 1 {
+		t.Fatalf("silent udp backend unhealthy: %+v %v", hc, err)
+	}
+
+	pc.Close()
+	if ok, err := UDPHealthChecker(lb.BalancingServers[0]); ok {
+		t.Fatalf("closed port healthy: %v", err)
+	}
+}
@@ -0,0 +1,325 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/DoniLite/Mogoly/core/events"
+	goevents "github.com/DoniLite/go-events"
+)
+
+const (
+	WAFActionBlock = "block" // Rejects the request as soon as the rule matches
+	WAFActionLog   = "log"   // Only logs the match
+	WAFActionScore = "score" // Adds the rule score, the request is rejected once the threshold is reached
+
+	WAFTargetMethod  = "method"
+	WAFTargetPath    = "path"
+	WAFTargetQuery   = "query"
+	WAFTargetHeaders = "headers" // Every header as `Name: value`, `header:<Name>` targets a single one
+	WAFTargetBody    = "body"    // The first BodyInspectBytes of the body
+
+	WAFRulesetStarter = "starter"
+)
+
+const (
+	wafDefaultThreshold    = 5
+	wafDefaultInspectBytes = 8 << 10
+	wafHeaderTargetPrefix  = "header:"
+)
+
+type WAFRule struct {
+	ID      string   `json:"id,omitempty" yaml:"id,omitempty"`
+	Targets []string `json:"targets,omitempty" yaml:"targets,omitempty"` // method, path, query, headers, header:<Name> or body
+	Regex   string   `json:"regex,omitempty" yaml:"regex,omitempty"`     // Go regexp, use (?i) for case insensitive matches
+	Literal string   `json:"literal,omitempty" yaml:"literal,omitempty"` // Case insensitive substring, used when Regex is empty
+	Action  string   `json:"action,omitempty" yaml:"action,omitempty"`   // block (default), log or score
+	Score   int      `json:"score,omitempty" yaml:"score,omitempty"`     // Added to the request score by the score action, 1 by default
+	Message string   `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+type WAFMiddlewareConfig struct {
+	Ruleset          string    `json:"ruleset,omitempty" yaml:"ruleset,omitempty"`                       // `starter` adds the bundled injection rules before Rules
+	Rules            []WAFRule `json:"rules,omitempty" yaml:"rules,omitempty"`                           // Rules evaluated in order
+	DetectOnly       bool      `json:"detect_only,omitempty" yaml:"detect_only,omitempty"`               // Logs and emits the blocks without rejecting the requests
+	ScoreThreshold   int       `json:"score_threshold,omitempty" yaml:"score_threshold,omitempty"`       // Score rejecting the request, 5 by default
+	BodyInspectBytes int64     `json:"body_inspect_bytes,omitempty" yaml:"body_inspect_bytes,omitempty"` // Body prefix read by the body rules, 8 KiB by default
+	BlockStatus      int       `json:"block_status,omitempty" yaml:"block_status,omitempty"`             // Status of the rejected requests, 403 by default
+}
+
+func (c WAFMiddlewareConfig) Validate() error {
+	if c.Ruleset != "" && c.Ruleset != WAFRulesetStarter {
+		return fmt.Errorf("unknown ruleset %q", c.Ruleset)
+	}
+	if c.BlockStatus != 0 && (c.BlockStatus < 400 || c.BlockStatus > 599) {
+		return fmt.Errorf("invalid block status %d", c.BlockStatus)
+	}
+	var errs []error
+	for i, rule := range c.Rules {
+		if _, err := compileWAFRule(rule); err != nil {
+			errs = append(errs, fmt.Errorf("rule %d (%s): %w", i, rule.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WAFStarterRules returns the bundled rules catching the common injection payloads.
+// They use the score action so that a single weak match does not reject a request.
+func WAFStarterRules() []WAFRule {
+	inputs := []string{WAFTargetPath, WAFTargetQuery, WAFTargetBody}
+	return []WAFRule{
+		{ID: "sqli-union", Targets: inputs, Regex: `(?i)\bunion\b[\s(/*]+(all\s+)?select\b`, Action: WAFActionScore, Score: 5, Message: "SQL injection (UNION SELECT)"},
+		{ID: "sqli-tautology", Targets: inputs, Regex: `(?i)['"]\s*(or|and)\s+['"]?\w+['"]?\s*=\s*['"]?\w+`, Action: WAFActionScore, Score: 5, Message: "SQL injection (tautology)"},
+		{ID: "sqli-comment", Targets: inputs, Regex: `(?i)['"]\s*(;|--|#|/\*)`, Action: WAFActionScore, Score: 2, Message: "SQL injection (quote and comment)"},
+		{ID: "sqli-functions", Targets: inputs, Regex: `(?i)\b(sleep|benchmark|pg_sleep|waitfor\s+delay|load_file|xp_cmdshell)\b\s*[('"]`, Action: WAFActionScore, Score: 5, Message: "SQL injection (dangerous function)"},
+		{ID: "xss-script", Targets: inputs, Regex: `(?i)<\s*/?\s*(script|iframe|object|embed|svg)\b`, Action: WAFActionScore, Score: 5, Message: "XSS (script tag)"},
+		{ID: "xss-handler", Targets: inputs, Regex: `(?i)\bon(error|load|click|mouseover|focus)\s*=`, Action: WAFActionScore, Score: 4, Message: "XSS (event handler)"},
+		{ID: "xss-javascript-uri", Targets: inputs, Regex: `(?i)javascript\s*:`, Action: WAFActionScore, Score: 4, Message: "XSS (javascript: URI)"},
+		{ID: "traversal", Targets: inputs, Regex: `(\.\.[/\\]){2,}|(?i)(%2e%2e|\.\.)(%2f|%5c)`, Action: WAFActionScore, Score: 5, Message: "Path traversal"},
+		{ID: "lfi-sensitive-files", Targets: inputs, Regex: `(?i)(/etc/(passwd|shadow|hosts)|\bwin\.ini\b|/proc/self/)`, Action: WAFActionScore, Score: 5, Message: "Local file inclusion"},
+		{ID: "cmd-injection", Targets: inputs, Regex: "(?i)(;|\\|\\|?|&&|`|\\$\\()\\s*(cat|ls|id|whoami|uname|wget|curl|nc|bash|sh|powershell)\\b", Action: WAFActionScore, Score: 5, Message: "Command injection"},
+		{ID: "jndi-lookup", Targets: []string{WAFTargetPath, WAFTargetQuery, WAFTargetHeaders, WAFTargetBody}, Regex: `(?i)\$\{\s*(jndi|env|sys|lower|upper)\s*:`, Action: WAFActionScore, Score: 5, Message: "JNDI lookup (Log4Shell)"},
+	}
+}
+
+type wafRule struct {
+	WAFRule
+	regex   *regexp.Regexp
+	literal string
+}
+
+func compileWAFRule(rule WAFRule) (*wafRule, error) {
+	compiled := &wafRule{WAFRule: rule}
+	switch {
+	case rule.Regex != "":
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, err
+		}
+		compiled.regex = re
+	case rule.Literal != "":
+		compiled.literal = strings.ToLower(rule.Literal)
+	default:
+		return nil, errors.New("regex or literal is required")
+	}
+	if len(rule.Targets) == 0 {
+		return nil, errors.New("at least one target is required")
+	}
+	for _, target := range rule.Targets {
+		switch {
+		case target == WAFTargetMethod, target == WAFTargetPath, target == WAFTargetQuery, target == WAFTargetHeaders, target == WAFTargetBody:
+		case strings.HasPrefix(target, wafHeaderTargetPrefix) && len(target) > len(wafHeaderTargetPrefix):
+		default:
+			return nil, fmt.Errorf("unknown target %q", target)
+		}
+	}
+	switch rule.Action {
+	case "":
+		compiled.Action = WAFActionBlock
+	case WAFActionBlock, WAFActionLog:
+	case WAFActionScore:
+		if compiled.Score <= 0 {
+			compiled.Score = 1
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %q", rule.Action)
+	}
+	return compiled, nil
+}
+
+func (rule *wafRule) match(value string) bool {
+	if rule.regex != nil {
+		return rule.regex.MatchString(value)
+	}
+	return strings.Contains(strings.ToLower(value), rule.literal)
+}
+
+type waf struct {
+	rules        []*wafRule
+	detectOnly   bool
+	threshold    int
+	inspectBytes int64
+	status       int
+}
+
+// WAFMiddleware matches the requests against the configured rules and rejects the
+// ones hitting a block rule or reaching the score threshold. In detect-only mode
+// the decisions are logged and emitted but the requests still reach the backend.
+func WAFMiddleware(conf WAFMiddlewareConfig) func(next http.Handler) http.Handler {
+	fw := &waf{
+		detectOnly:   conf.DetectOnly,
+		threshold:    conf.ScoreThreshold,
+		inspectBytes: conf.BodyInspectBytes,
+		status:       conf.BlockStatus,
+	}
+	if fw.threshold <= 0 {
+		fw.threshold = wafDefaultThreshold
+	}
+	if fw.inspectBytes <= 0 {
+		fw.inspectBytes = wafDefaultInspectBytes
+	}
+	if fw.status == 0 {
+		fw.status = http.StatusForbidden
+	}
+	rules := conf.Rules
+	if conf.Ruleset == WAFRulesetStarter {
+		rules = append(WAFStarterRules(), rules...)
+	}
+	for _, rule := range rules {
+		compiled, err := compileWAFRule(rule)
+		if err != nil {
+			events.Logf(events.LOG_ERROR, "[WAF]: Ignoring the %s rule: %v", rule.ID, err)
+			continue
+		}
+		fw.rules = append(fw.rules, compiled)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if fw.inspect(r) && !fw.detectOnly {
+				http.Error(w, http.StatusText(fw.status), fw.status)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// wafRequest lazily extracts the request parts matched by the rules.
+type wafRequest struct {
+	r          *http.Request
+	body       []byte
+	bodyRead   bool
+	inspectMax int64
+}
+
+func (req *wafRequest) values(target string) []string {
+	r := req.r
+	switch {
+	case target == WAFTargetMethod:
+		return []string{r.Method}
+	case target == WAFTargetPath:
+		return withUnescaped(r.URL.EscapedPath(), url.PathUnescape)
+	case target == WAFTargetQuery:
+		if r.URL.RawQuery == "" {
+			return nil
+		}
+		return withUnescaped(r.URL.RawQuery, url.QueryUnescape)
+	case target == WAFTargetHeaders:
+		values := make([]string, 0, len(r.Header))
+		for name, vs := range r.Header {
+			for _, v := range vs {
+				values = append(values, name+": "+v)
+			}
+		}
+		return values
+	case strings.HasPrefix(target, wafHeaderTargetPrefix):
+		return r.Header.Values(strings.TrimPrefix(target, wafHeaderTargetPrefix))
+	case target == WAFTargetBody:
+		if !req.bodyRead {
+			req.bodyRead = true
+			req.body = peekBody(r, req.inspectMax)
+		}
+		if len(req.body) == 0 {
+			return nil
+		}
+		return withUnescaped(string(req.body), url.QueryUnescape)
+	}
+	return nil
+}
+
+// withUnescaped returns raw and its unescaped form when they differ, so that
+// encoded payloads are matched as well.
+func withUnescaped(raw string, unescape func(string) (string, error)) []string {
+	if decoded, err := unescape(raw); err == nil && decoded != raw {
+		return []string{raw, decoded}
+	}
+	return []string{raw}
+}
+
+// peekBody reads up to max bytes of the body and puts them back in front of the
+// remaining body for the next handlers.
+func peekBody(r *http.Request, max int64) []byte {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	prefix, err := io.ReadAll(io.LimitReader(r.Body, max))
+	if err != nil {
+		events.Logf(events.LOG_ERROR, "[WAF]: Cannot read the request body: %v", err)
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(prefix), r.Body), r.Body}
+	return prefix
+}
+
+// inspect evaluates the rules and reports whether the request must be rejected.
+func (fw *waf) inspect(r *http.Request) bool {
+	req := &wafRequest{r: r, inspectMax: fw.inspectBytes}
+	score := 0
+	var matched []string
+	var blockedBy *wafRule
+	for _, rule := range fw.rules {
+		if !rule.matches(req) {
+			continue
+		}
+		switch rule.Action {
+		case WAFActionLog:
+			events.Logf(events.LOG_INFO, "[WAF]: Rule %s matched %s %s from %s: %s", rule.ID, r.Method, r.URL.Path, clientIP(r.RemoteAddr), rule.Message)
+			continue
+		case WAFActionScore:
+			score += rule.Score
+		case WAFActionBlock:
+			blockedBy = rule
+		}
+		matched = append(matched, rule.ID)
+		if blockedBy != nil || score >= fw.threshold {
+			break
+		}
+	}
+	if blockedBy == nil && score < fw.threshold {
+		return false
+	}
+
+	mode := "Blocked"
+	if fw.detectOnly {
+		mode = "Detected (detect only)"
+	}
+	events.Logf(events.LOG_ERROR, "[WAF]: %s %s %s from %s, rules %v, score %d", mode, r.Method, r.URL.Path, clientIP(r.RemoteAddr), matched, score)
+	payload := map[string]any{
+		"rules":       matched,
+		"score":       score,
+		"blocked":     !fw.detectOnly,
+		"method":      r.Method,
+		"host":        r.Host,
+		"path":        r.URL.Path,
+		"remote_addr": clientIP(r.RemoteAddr),
+		"request_id":  RequestIDFromContext(r.Context()),
+	}
+	if blockedBy != nil {
+		payload["message"] = blockedBy.Message
+	}
+	events.GetEventBus().Emit(events.WAFBlockEvent, &goevents.EventData{
+		Message: fmt.Sprintf("%s %s %s", mode, r.Method, r.URL.Path),
+		Payload: payload,
+	})
+	return true
+}
+
+func (rule *wafRule) matches(req *wafRequest) bool {
+	for _, target := range rule.Targets {
+		for _, value := range req.values(target) {
+			if rule.match(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
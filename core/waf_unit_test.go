@@ -0,0 +1,125 @@
+package core
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DoniLite/Mogoly/core/events"
+	"github.com/DoniLite/Mogoly/core/router"
+	"github.com/DoniLite/Mogoly/core/server"
+	goevents "github.com/DoniLite/go-events"
+)
+
+func TestWAFMiddleware_StarterRuleset(t *testing.T) {
+	var received string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+	}))
+	defer backend.Close()
+
+	blocked := make(chan *goevents.EventData, 8)
+	events.AddEventHandler(events.WAFBlockEvent, func(data *goevents.EventData, args ...string) {
+		blocked <- data
+	})
+
+	h := router.CreateSingleHttpServer(&server.Server{
+		Name:        "waf.test",
+		URL:         backend.URL,
+		Middlewares: []server.Middleware{{Name: string(server.MogolyWAF), Config: map[string]any{"ruleset": "starter"}}},
+	})
+	do := func(method, target, body string) int {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rr.Code
+	}
+
+	for _, target := range []string{
+		"http://waf.test/items?id=1%20UNION%20SELECT%20password%20FROM%20users",
+		"http://waf.test/search?q=%3Cscript%3Ealert(1)%3C/script%3E",
+		"http://waf.test/files?name=..%2F..%2Fetc%2Fpasswd",
+		"http://waf.test/ping?host=127.0.0.1;cat%20/etc/hosts",
+	} {
+		if code := do("GET", target, ""); code != http.StatusForbidden {
+			t.Fatalf("%s: expected 403, got %d", target, code)
+		}
+	}
+	if code := do("POST", "http://waf.test/login", "user=admin' OR '1'='1"); code != http.StatusForbidden {
+		t.Fatalf("body injection: expected 403, got %d", code)
+	}
+
+	select {
+	case data := <-blocked:
+		payload := data.Payload.(map[string]any)
+		if payload["blocked"] != true || payload["host"] != "waf.test" {
+			t.Fatalf("unexpected event payload %v", payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no waf event emitted")
+	}
+
+	for _, target := range []string{"http://waf.test/items?id=1&page=2&ls=1", "http://waf.test/blog/select-a-union-rep"} {
+		if code := do("GET", target, ""); code != http.StatusOK {
+			t.Fatalf("%s: false positive, got %d", target, code)
+		}
+	}
+	if code := do("POST", "http://waf.test/comments", "text=It's a nice day"); code != http.StatusOK || received != "text=It's a nice day" {
+		t.Fatalf("clean body: got %d, backend received %q", code, received)
+	}
+}
+
+func TestWAFMiddleware_CustomRules(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	conf := server.WAFMiddlewareConfig{
+		BlockStatus: http.StatusNotAcceptable,
+		Rules: []server.WAFRule{
+			{ID: "no-trace", Targets: []string{"method"}, Literal: "trace"},
+			{ID: "scanner", Targets: []string{"header:User-Agent"}, Regex: `(?i)sqlmap|nikto`},
+			{ID: "admin", Targets: []string{"path"}, Literal: "/admin", Action: "score", Score: 3},
+			{ID: "internal", Targets: []string{"headers"}, Literal: "x-internal: 1", Action: "score", Score: 3},
+			{ID: "audit", Targets: []string{"query"}, Literal: "debug", Action: "log"},
+		},
+	}
+	do := func(h http.Handler, method, target string, header http.Header) int {
+		req := httptest.NewRequest(method, target, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	h := server.WAFMiddleware(conf)(next)
+	cases := []struct {
+		method, target string
+		header         http.Header
+		want           int
+	}{
+		{"TRACE", "http://waf.test/", nil, http.StatusNotAcceptable},
+		{"GET", "http://waf.test/", http.Header{"User-Agent": {"sqlmap/1.7"}}, http.StatusNotAcceptable},
+		{"GET", "http://waf.test/admin?debug=1", nil, http.StatusNoContent},
+		{"GET", "http://waf.test/admin", http.Header{"X-Internal": {"1"}}, http.StatusNotAcceptable},
+	}
+	for _, c := range cases {
+		if got := do(h, c.method, c.target, c.header); got != c.want {
+			t.Fatalf("%s %s %v: expected %d, got %d", c.method, c.target, c.header, c.want, got)
+		}
+	}
+
+	conf.DetectOnly = true
+	h = server.WAFMiddleware(conf)(next)
+	if got := do(h, "TRACE", "http://waf.test/", nil); got != http.StatusNoContent {
+		t.Fatalf("detect only: expected the request to pass, got %d", got)
+	}
+
+	if err := (server.WAFMiddlewareConfig{Rules: []server.WAFRule{{Targets: []string{"cookies"}, Literal: "x"}}}).Validate(); err == nil {
+		t.Fatal("unknown target accepted")
+	}
+}
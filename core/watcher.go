@@ -1,20 +1,69 @@
 package core
 
 import (
+	"bytes"
+	"fmt"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/DoniLite/Mogoly/core/events"
 	"github.com/DoniLite/Mogoly/core/router"
+	goevents "github.com/DoniLite/go-events"
 	"github.com/fsnotify/fsnotify"
 )
 
-func WatchConfig(path string, onReload func(*router.Config)) error {
+// ConfigReloadApplied and ConfigReloadFailed are the messages of the
+// events.ConfigFileUpdateEvent emitted by WatchRouterConfig.
+const (
+	ConfigReloadApplied = "config_reload_applied"
+	ConfigReloadFailed  = "config_reload_failed"
+)
+
+// WatchConfig calls onReload with every valid new version of the config file at path
+// until stop is called. Versions that cannot be read or parsed are logged and skipped.
+func WatchConfig(path string, onReload func(*router.Config)) (stop func(), err error) {
+	return watchConfig(path, onReload, nil, nil)
+}
+
+// WatchRouterConfig reconciles the router with every new version of the config file at
+// path. Invalid versions are rejected and the last good config keeps running, the
+// versions written by the router itself are skipped. Each attempt emits
+// events.ConfigFileUpdateEvent with a payload holding the path, success, and the error
+// or the summary of the applied changes. stop closes the watcher and waits for the
+// reload in progress, if any.
+func WatchRouterConfig(path string) (stop func(), err error) {
+	return watchConfig(path, func(cfg *router.Config) {
+		summary, err := router.ReconcileConfig(cfg)
+		if err != nil {
+			emitConfigReload(path, nil, err)
+			return
+		}
+		emitConfigReload(path, summary, nil)
+	}, func(err error) {
+		emitConfigReload(path, nil, err)
+	}, router.IsPersistedConfig)
+}
+
+func emitConfigReload(path string, summary *router.ReconcileSummary, err error) {
+	payload := map[string]any{"path": path, "success": err == nil}
+	message := ConfigReloadApplied
+	if err != nil {
+		events.Logf(events.LOG_ERROR, "[WATCHER]: Rejected %s, keeping the running config: %v", path, err)
+		payload["error"] = err.Error()
+		message = ConfigReloadFailed
+	} else {
+		payload["summary"] = summary.String()
+	}
+	events.GetEventBus().Emit(events.ConfigFileUpdateEvent, &goevents.EventData{Message: message, Payload: payload})
+}
+
+func watchConfig(path string, onReload func(*router.Config), onError func(error), skip func(content []byte) bool) (func(), error) {
 	events.Logf(events.LOG_INFO, "[WATCHER]: Initializing watcher for config path: %s", path)
 	w, err := fsnotify.NewWatcher()
 	if err != nil {
 		events.Logf(events.LOG_ERROR, "[WATCHER]: Error while initializing the watcher: %v", err.Error())
-		return err
+		return nil, err
 	}
 	// Watch the directory; filter for the target filename.
 	dir, file := filepath.Split(path)
@@ -23,16 +72,22 @@ func WatchConfig(path string, onReload func(*router.Config)) error {
 	}
 	if err := w.Add(dir); err != nil {
 		events.Logf(events.LOG_ERROR, "[WATCHER]: Error while watching the dir: %s \nerror: %s", dir, err.Error())
-		return err
+		w.Close()
+		return nil, err
 	}
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		debounce := time.NewTimer(0)
 		if !debounce.Stop() {
 			<-debounce.C
 		}
 		for {
 			select {
-			case e := <-w.Events:
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
 				if filepath.Base(e.Name) != file {
 					break
 				}
@@ -58,25 +113,49 @@ func WatchConfig(path string, onReload func(*router.Config)) error {
 					}
 					time.Sleep(80 * time.Millisecond)
 				}
+				if err == nil && len(bytes.TrimSpace(content)) == 0 {
+					// Most likely caught between the truncate and the write of an editor.
+					err = fmt.Errorf("empty config file: %s", path)
+				}
 				if err != nil {
 					events.Logf(events.LOG_ERROR, "[WATCHER]: error loading config file: %v", err)
+					if onError != nil {
+						onError(err)
+					}
+					continue
+				}
+				if skip != nil && skip(content) {
+					events.Logf(events.LOG_INFO, "[WATCHER]: Skipping %s, written by the router", path)
 					continue
 				}
 				format, err := DiscoverConfigFormat(path)
 				if err != nil {
 					events.Logf(events.LOG_ERROR, "[WATCHER]: error discovering config format: %v", err)
+					if onError != nil {
+						onError(err)
+					}
 					continue
 				}
 				cfg, err := ParseConfig(content, format)
 				if err != nil {
 					events.Logf(events.LOG_ERROR, "[WATCHER]: config reload error: %v", err)
+					if onError != nil {
+						onError(err)
+					}
 					continue
 				}
 				onReload(cfg)
-			case err := <-w.Errors:
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
 				events.Logf(events.LOG_ERROR, "[WATCHER]: watch error: %v", err)
 			}
 		}
 	}()
-	return nil
+	return sync.OnceFunc(func() {
+		w.Close()
+		<-done
+		events.Logf(events.LOG_INFO, "[WATCHER]: Stopped watching %s", path)
+	}), nil
 }
@@ -3,10 +3,13 @@ package core
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/DoniLite/Mogoly/core/events"
 	"github.com/DoniLite/Mogoly/core/router"
+	goevents "github.com/DoniLite/go-events"
 )
 
 func TestWatchConfig_ReloadsOnChange(t *testing.T) {
@@ -19,9 +22,11 @@ func TestWatchConfig_ReloadsOnChange(t *testing.T) {
 	}
 
 	reloaded := make(chan *router.Config, 1)
-	if err := WatchConfig(fp, func(c *router.Config) { reloaded <- c }); err != nil {
+	stop, err := WatchConfig(fp, func(c *router.Config) { reloaded <- c })
+	if err != nil {
 		t.Fatalf("watch: %v", err)
 	}
+	defer stop()
 	time.Sleep(150 * time.Millisecond) // allow watcher to start
 
 	// mutate
@@ -39,3 +44,117 @@ func TestWatchConfig_ReloadsOnChange(t *testing.T) {
 		t.Fatalf("timed out waiting reload")
 	}
 }
+
+func TestWatchConfig_StartStopTwice(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "conf.yml")
+	if err := os.WriteFile(fp, []byte("server: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for run := range 2 {
+		reloaded := make(chan *router.Config, 4)
+		stop, err := WatchConfig(fp, func(c *router.Config) { reloaded <- c })
+		if err != nil {
+			t.Fatalf("watch %d: %v", run, err)
+		}
+		time.Sleep(150 * time.Millisecond)
+		if err := os.WriteFile(fp, []byte("server:\n  - name: a\n    url: http://127.0.0.1:8080\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-reloaded:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("watch %d: timed out waiting reload", run)
+		}
+
+		stop()
+		stop()
+		if err := os.WriteFile(fp, []byte("server: []\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case cfg := <-reloaded:
+			t.Fatalf("watch %d: reloaded after stop: %#v", run, cfg)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func TestWatchRouterConfig_RejectsInvalidFile(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "router.yaml")
+	if err := os.WriteFile(fp, []byte("server: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan *goevents.EventData, 4)
+	events.AddEventHandler(events.ConfigFileUpdateEvent, func(data *goevents.EventData, args ...string) {
+		received <- data
+	})
+	stop, err := WatchRouterConfig(fp)
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	defer stop()
+	time.Sleep(150 * time.Millisecond)
+
+	invalid := []byte("server:\n  - name: a\n    url: http://127.0.0.1:8080\n    middlewares:\n      - name: mogoly:nope\n")
+	if err := os.WriteFile(fp, invalid, 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-received:
+		payload := data.Payload.(map[string]any)
+		if data.Message != ConfigReloadFailed || payload["success"] != false || !strings.Contains(payload["error"].(string), "mogoly:nope") {
+			t.Fatalf("unexpected event %s %v", data.Message, payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the reload event")
+	}
+}
+
+func TestWatchRouterConfig_SkipsRouterWrites(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fp, err := router.ConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&router.Config{}).PersistConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan *goevents.EventData, 4)
+	events.AddEventHandler(events.ConfigFileUpdateEvent, func(data *goevents.EventData, args ...string) {
+		if data.Payload.(map[string]any)["path"] == fp {
+			received <- data
+		}
+	})
+	stop, err := WatchRouterConfig(fp)
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	defer stop()
+	time.Sleep(150 * time.Millisecond)
+
+	persisted := &router.Config{Variables: map[string]string{"MOGOLY_WATCH_TEST": "1"}}
+	if err := persisted.PersistConfig(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-received:
+		t.Fatalf("the write of the router was reloaded: %s %v", data.Message, data.Payload)
+	case <-time.After(time.Second):
+	}
+
+	// An edit of the user is still picked up.
+	if err := os.WriteFile(fp, []byte("server:\n  - name: a\n    middlewares:\n      - name: mogoly:nope\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-received:
+		if data.Message != ConfigReloadFailed {
+			t.Fatalf("unexpected event %s %v", data.Message, data.Payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the reload event")
+	}
+}
@@ -46,7 +46,12 @@ func NewServer(onMsg HandlerFunc, originChecker func(r *http.Request) bool) *Ser
 }
 
 func NewErrorMessage(errMsg, details string) *Message {
-	payloadBytes, _ := json.Marshal(ErrorPayload{Details: details})
+	return NewErrorMessageWithCode(errMsg, details, 0)
+}
+
+// NewErrorMessageWithCode creates an error message whose payload carries one of the ErrorCode constants.
+func NewErrorMessageWithCode(errMsg, details string, code int) *Message {
+	payloadBytes, _ := json.Marshal(ErrorPayload{Code: code, Details: details})
 	return &Message{
 		Action: Action{
 			Type:    ERROR,
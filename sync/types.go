@@ -52,6 +52,14 @@ type ErrorPayload struct {
 	Details string `json:"details"`
 }
 
+// Codes of ErrorPayload telling the kind of the error, 0 when it has none.
+const (
+	ErrorCodeNotFound = iota + 1
+	ErrorCodeAlreadyExists
+	ErrorCodeUnavailable
+	ErrorCodeInvalid
+)
+
 // Represents a message payload sending between the server and the client
 type Message struct {
 	RequestID string `json:"request_id"`